@@ -0,0 +1,332 @@
+package dao
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/log"
+	"github.com/ssgo/u"
+)
+
+// 按顺序匹配，相同类型时优先使用前面的缩写（b 优先于 uti）
+var typeTags = []string{"c", "v", "dt", "d", "tm", "b", "i", "ui", "ti", "uti", "bi", "ubi", "f", "uf", "ff", "uff", "si", "usi", "mi", "umi", "t", "bb"}
+
+var typeSizeMatcher = regexp.MustCompile(`^([a-z ]+?)\s*\(([0-9, ]+)\)(.*)$`)
+var camelWordMatcher = regexp.MustCompile(`^[a-z]+|^[A-Z][a-z0-9]*`)
+
+// makeTypeTag 通过 typeMapping 将数据库中的类型转换为描述文件中的缩写，exact 为 false 表示无法精确表示
+func makeTypeTag(typ string) (tag string, exact bool) {
+	rawType := strings.TrimSpace(typ)
+	typ = strings.TrimSpace(strings.ReplaceAll(strings.ToLower(typ), " zerofill", ""))
+	if strings.HasPrefix(typ, "enum(") || strings.HasPrefix(typ, "set(") {
+		// e(a,b,c)，值中不能有空格和逗号，只转换类型的大小写，值保持原样
+		values := make([]string, 0)
+		exact = true
+		for _, v := range strings.Split(rawType[strings.IndexByte(rawType, '(')+1:len(rawType)-1], "','") {
+			v = strings.Trim(v, "'")
+			if strings.ContainsAny(v, " ,'") {
+				exact = false
+			}
+			values = append(values, v)
+		}
+		return typ[0:1] + "(" + strings.Join(values, ",") + ")", exact
+	}
+	base := typ
+	size := ""
+	if m := typeSizeMatcher.FindStringSubmatch(typ); m != nil {
+		base = m[1] + m[3]
+		size = strings.ReplaceAll(m[2], " ", "")
+	}
+	switch base {
+	case "mediumint":
+		base = "middleint"
+	case "mediumint unsigned":
+		base = "middleint unsigned"
+	case "datetime":
+		// dt 为 DATETIME(6)
+		return "dt", true
+	case "timestamp":
+		return "ts", true
+	case "time":
+		return "tm", true
+	case "decimal", "numeric":
+		// dec10_2
+		return "dec" + strings.Replace(u.StringIf(size != "", size, "10,0"), ",", "_", 1), true
+	case "json", "jsonb":
+		return "j", true
+	case "varbinary", "binary":
+		return base[0:1] + u.StringIf(base == "binary", "n", "b") + u.StringIf(size != "", size, "1"), true
+	case "bit":
+		return "bit" + u.StringIf(size != "1", size, ""), true
+	case "year":
+		return "y", true
+	case "mediumtext":
+		return "mt", true
+	case "text":
+		if size != "" {
+			// 旧版本的 sqlite 中 c 和 v 都保存为 TEXT(n)
+			return "v" + size, true
+		}
+	}
+
+	for _, t := range typeTags {
+		mapped := strings.ToLower(typeMapping["mysql"][t])
+		if mapped == typ {
+			return t, true
+		}
+		if mapped == base {
+			if t == "c" || t == "v" {
+				return t + size, true
+			}
+			// 整数的显示宽度可以忽略
+			return t, true
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(base, "text"):
+		return "t", true
+	case strings.HasSuffix(base, "blob"):
+		return "bb", true
+	}
+	return "v255", false
+}
+
+// tableGroupName 按表名的第一个单词分组，如 userDevice、user_device 都属于 user
+func tableGroupName(table string) string {
+	if pos := strings.IndexByte(table, '_'); pos > 0 {
+		return u.GetUpperName(table[0:pos])
+	}
+	return u.GetUpperName(camelWordMatcher.FindString(table))
+}
+
+// MakeDescFromDB 读取数据库的表结构，生成描述文件（er.txt）的内容
+// 无法精确表示的类型、默认值和索引会输出警告，其中没有导出的索引在使用这个文件导入时会被当作删除（没有允许时拒绝执行）
+func MakeDescFromDB(conn *db.DB, logger *log.Logger) (string, error) {
+	tables, err := GetTables(conn)
+	if err != nil {
+		return "", err
+	}
+
+	groupNames := make([]string, 0)
+	groups := map[string][]string{}
+	for _, table := range tables {
+		if strings.HasPrefix(table, "_") || strings.HasPrefix(table, ".") {
+			continue
+		}
+		schema, err := GetTableSchema(conn, table)
+		if err != nil {
+			return "", err
+		}
+
+		tableDesc := makeTableDesc(schema, func(field, msg string) {
+			if logger != nil {
+				logger.Warning(msg, "tableName", table, "fieldName", field)
+			} else {
+				fmt.Println(u.Yellow(fmt.Sprint(table, ".", field, " ", msg)))
+			}
+		})
+		groupName := tableGroupName(table)
+		if groups[groupName] == nil {
+			groupNames = append(groupNames, groupName)
+		}
+		groups[groupName] = append(groups[groupName], tableDesc)
+	}
+
+	// 只有一张表的分组合并到 Default
+	out := make([]string, 0)
+	defaultTables := make([]string, 0)
+	for _, groupName := range groupNames {
+		if len(groups[groupName]) == 1 {
+			defaultTables = append(defaultTables, groups[groupName]...)
+			continue
+		}
+		out = append(out, "// "+groupName+"\n\n"+strings.Join(groups[groupName], "\n\n"))
+	}
+	if len(defaultTables) > 0 {
+		out = append(out, "// Default\n\n"+strings.Join(defaultTables, "\n\n"))
+	}
+	return strings.Join(out, "\n\n") + "\n", nil
+}
+
+// makeTableDesc 生成一张表的描述，warn 输出无法精确导出的内容
+func makeTableDesc(schema *TableSchema, warn func(field, msg string)) string {
+	table := schema.Name
+	// 每个字段的索引缩写，复合索引使用分组编号
+	indexNames := make([]string, 0)
+	indexFields := map[string][]string{}
+	indexBy := map[string]TableIndex{}
+	indexColumns := map[string][]TableIndex{}
+	for _, index := range schema.Indexes {
+		if indexFields[index.Key_name] == nil {
+			indexNames = append(indexNames, index.Key_name)
+			indexBy[index.Key_name] = index
+		}
+		indexFields[index.Key_name] = append(indexFields[index.Key_name], index.Column_name)
+		indexColumns[index.Key_name] = append(indexColumns[index.Key_name], index)
+	}
+	// 按名称排序，复合索引的分组编号不受索引创建顺序的影响
+	sort.SliceStable(indexNames, func(i, j int) bool {
+		if indexNames[i] == "PRIMARY" || indexNames[j] == "PRIMARY" {
+			return indexNames[i] == "PRIMARY" && indexNames[j] != "PRIMARY"
+		}
+		return indexNames[i] < indexNames[j]
+	})
+	fieldOrders := map[string]int{}
+	for i, field := range schema.Fields {
+		fieldOrders[field.Field] = i
+	}
+	fkNames := map[string]bool{}
+	for _, ref := range schema.Refs {
+		fkNames[ref.Name] = true
+	}
+	autoIds := map[string]bool{}
+	for _, field := range schema.Fields {
+		if strings.Contains(field.Extra, "auto_increment") {
+			autoIds[field.Field] = true
+		}
+	}
+	fieldIndexes := map[string]string{}
+	groupId := 0
+	for _, keyName := range indexNames {
+		index := indexBy[keyName]
+		if keyName != "PRIMARY" && len(indexFields[keyName]) == 1 && autoIds[indexFields[keyName][0]] {
+			// SQLite 中 AI 会额外创建唯一索引
+			continue
+		}
+		if fkNames[keyName] {
+			// mysql 为外键自动创建的同名索引，由外键管理
+			continue
+		}
+		tag := ""
+		if keyName == "PRIMARY" {
+			tag = "PK"
+		} else if index.Index_type == "FULLTEXT" || strings.HasPrefix(keyName, "tk_") {
+			tag = "TI"
+		} else if index.Non_unique == 0 {
+			tag = "U"
+		} else {
+			tag = "I"
+		}
+		columns := strings.Join(indexFields[keyName], ", ")
+		if tag == "TI" && len(indexFields[keyName]) > 1 {
+			// 描述文件中的全文索引只能包含一个字段
+			warn(indexFields[keyName][0], "fulltext index "+keyName+" ("+columns+") not exported, importing the file will drop it")
+			continue
+		}
+		// 描述文件中一个字段只能属于一个索引，复合索引中任意一个字段已经导出了其他索引时整个索引都不导出
+		exported := true
+		for _, field := range indexFields[keyName] {
+			if fieldIndexes[field] != "" {
+				warn(field, "has multiple indexes, only "+fieldIndexes[field]+" exported, index "+keyName+" ("+columns+") not exported, importing the file will drop it")
+				exported = false
+				break
+			}
+		}
+		if !exported {
+			continue
+		}
+		if len(indexFields[keyName]) > 1 && tag != "PK" {
+			groupId++
+			tag += u.String(groupId)
+		}
+		// 索引中的字段顺序与表中的字段顺序不同时输出位置 I1:2
+		withPos := false
+		for i := 1; i < len(indexFields[keyName]); i++ {
+			if fieldOrders[indexFields[keyName][i]] < fieldOrders[indexFields[keyName][i-1]] {
+				withPos = true
+			}
+		}
+		for i, index := range indexColumns[keyName] {
+			field := index.Column_name
+			fieldTag := tag
+			if withPos {
+				fieldTag += ":" + u.String(i+1)
+			}
+			if tag != "PK" && tag != "TI" {
+				if index.Sub_part > 0 {
+					fieldTag += "(" + u.String(index.Sub_part) + ")"
+				}
+				if index.Collation == "D" {
+					fieldTag += "-"
+				}
+			}
+			fieldIndexes[field] = fieldTag
+		}
+	}
+
+	refs := map[string]TableRef{}
+	for _, ref := range schema.Refs {
+		refs[ref.Field] = ref
+	}
+
+	lines := make([][2]string, 0)
+	// mysql 的表选项，只导出与默认值不同的部分
+	tableLine := []string{table}
+	options := schema.Options
+	if options.Engine != "" && !strings.EqualFold(options.Engine, "InnoDB") {
+		tableLine = append(tableLine, "engine="+options.Engine)
+	}
+	if options.Collation != "" && options.Collation != "utf8mb4_general_ci" {
+		tableLine = append(tableLine, "collate="+options.Collation)
+	}
+	if options.RowFormat != "" {
+		tableLine = append(tableLine, "rowFormat="+options.RowFormat)
+	}
+	if options.Partition != "" {
+		tableLine = append(tableLine, "partition=\""+options.Partition+"\"")
+	}
+	lines = append(lines, [2]string{strings.Join(tableLine, " "), schema.Comment})
+	for _, field := range schema.Fields {
+		tag, exact := makeTypeTag(field.Type)
+		if !exact {
+			warn(field.Field, "type "+field.Type+" exported as "+tag)
+		}
+		a := []string{field.Field, tag}
+		isAutoId := strings.Contains(field.Extra, "auto_increment")
+		if isAutoId {
+			a = append(a, "AI")
+		} else if fieldIndexes[field.Field] != "" {
+			a = append(a, fieldIndexes[field.Field])
+		}
+		if strings.Contains(field.Extra, "DEFAULT_GENERATED") {
+			a = append(a, u.StringIf(strings.Contains(field.Extra, "on update"), "ctu", "ct"))
+		} else if field.Default != nil && *field.Default != "" && strings.ToUpper(*field.Default) != "NULL" {
+			warn(field.Field, "default value "+*field.Default+" not exported")
+		}
+		if field.Null == "NO" && !isAutoId && !strings.HasPrefix(fieldIndexes[field.Field], "PK") && !strings.Contains(field.Extra, "DEFAULT_GENERATED") {
+			a = append(a, "nn")
+		}
+		if collate := options.Columns[field.Field]; collate != "" && collate != u.StringIf(options.Collation != "", options.Collation, "utf8mb4_general_ci") {
+			a = append(a, "collate="+collate)
+		}
+		if ref, ok := refs[field.Field]; ok {
+			if ref.RefField == "id" {
+				a = append(a, ">"+ref.RefTable)
+			} else {
+				a = append(a, ">"+ref.RefTable+"."+ref.RefField)
+			}
+		}
+		lines = append(lines, [2]string{strings.Join(a, " "), field.Comment})
+	}
+
+	// 注释对齐
+	width := 19
+	for _, line := range lines {
+		if len(line[0]) >= width {
+			width = len(line[0]) + 1
+		}
+	}
+	out := make([]string, 0)
+	for _, line := range lines {
+		if line[1] != "" {
+			out = append(out, line[0]+strings.Repeat(" ", width-len(line[0]))+"// "+line[1])
+		} else {
+			out = append(out, line[0])
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,198 @@
+package dao
+
+import (
+	"strings"
+	"testing"
+)
+
+const testExportDesc = `// Account
+
+Account
+id ubi AI
+tenantId ubi I1:2
+name v50 I1:1
+email v100 U
+code c8 U2
+region v20 U2
+score ff I-
+balance dec10_2 nn
+attrs j
+avatar bb
+birthday d
+createTime dt ct
+memo t
+status e(Active,Blocked) nn
+tags s(a,b)
+
+AccountLog
+id ubi AI
+accountId ubi I
+time dt I-
+
+AccountRegion
+code c8 PK
+name v50
+`
+
+// TestEnumTypeTag 导出的枚举和集合再导入时与原来的类型相同，值区分大小写
+func TestEnumTypeTag(t *testing.T) {
+	for _, typ := range []string{"enum('Active','Blocked')", "ENUM('on','Off')", "set('Read','Write')"} {
+		tag, exact := makeTypeTag(typ)
+		if !exact {
+			t.Fatal("not exact: ", typ, tag)
+		}
+		groups := MakeERFromDesc("mysql", "Test\nstatus "+tag+"\n")
+		if field := groups[0].Tables[0].Fields[0]; field.Type != "ENUM"+typ[4:] && field.Type != "SET"+typ[3:] {
+			t.Fatalf("%s exported as %s imported as %s", typ, tag, field.Type)
+		}
+	}
+	if tag, _ := makeTypeTag("enum('Active','Blocked')"); tag != "e(Active,Blocked)" {
+		t.Fatal("enum tag: ", tag)
+	}
+}
+
+func TestMakeTypeTag(t *testing.T) {
+	for typ, expected := range map[string]string{
+		"bigint unsigned":  "ubi",
+		"int(11)":          "i",
+		"tinyint unsigned": "b",
+		"varchar(50)":      "v50",
+		"char(12)":         "c12",
+		"datetime(6)":      "dt",
+		"decimal(10,2)":    "dec10_2",
+		"json":             "j",
+		"longtext":         "t",
+		"mediumtext":       "mt",
+		"varbinary(16)":    "vb16",
+		"text(20)":         "v20",
+		"blob":             "bb",
+		"double":           "ff",
+	} {
+		if tag, exact := makeTypeTag(typ); tag != expected || !exact {
+			t.Errorf("%s exported as %s %v, expected %s", typ, tag, exact, expected)
+		}
+	}
+	if tag, exact := makeTypeTag("geometry"); exact {
+		t.Error("geometry exported as exact ", tag)
+	}
+}
+
+// TestMakeTableDesc 按 mysql 的表结构导出：索引的位置、方向、前缀长度，默认值，排序规则和外键
+func TestMakeTableDesc(t *testing.T) {
+	dflt := func(v string) *string { return &v }
+	schema := &TableSchema{
+		Name:    "Post",
+		Comment: "文章",
+		Fields: []TableDesc{
+			{Field: "id", Type: "bigint unsigned", Null: "NO", Key: "PRI", Extra: "auto_increment"},
+			{Field: "userId", Type: "bigint unsigned", Null: "NO"},
+			{Field: "title", Type: "varchar(200)", Null: "NO", Comment: "标题"},
+			{Field: "body", Type: "longtext", Null: "YES"},
+			{Field: "status", Type: "enum('Draft','Published')", Null: "NO", Default: dflt("Draft")},
+			{Field: "createTime", Type: "datetime(6)", Null: "NO", Default: dflt("CURRENT_TIMESTAMP(6)"), Extra: "DEFAULT_GENERATED"},
+			{Field: "updateTime", Type: "datetime(6)", Null: "NO", Default: dflt("CURRENT_TIMESTAMP(6)"), Extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP(6)"},
+		},
+		Indexes: []TableIndex{
+			{Key_name: "PRIMARY", Seq_in_index: 1, Column_name: "id"},
+			{Non_unique: 1, Key_name: "ik_Post_user", Seq_in_index: 1, Column_name: "createTime", Collation: "D"},
+			{Non_unique: 1, Key_name: "ik_Post_user", Seq_in_index: 2, Column_name: "userId", Collation: "A"},
+			{Non_unique: 1, Key_name: "ik_Post_title", Seq_in_index: 1, Column_name: "title", Sub_part: 20},
+			{Non_unique: 1, Key_name: "tk_Post_body", Seq_in_index: 1, Column_name: "body", Index_type: "FULLTEXT"},
+			{Non_unique: 1, Key_name: "tk_Post_all", Seq_in_index: 1, Column_name: "title", Index_type: "FULLTEXT"},
+			{Non_unique: 1, Key_name: "tk_Post_all", Seq_in_index: 2, Column_name: "body", Index_type: "FULLTEXT"},
+			{Key_name: "uk_Post_title", Seq_in_index: 1, Column_name: "title"},
+			{Non_unique: 1, Key_name: "fk_Post_userId", Seq_in_index: 1, Column_name: "userId"},
+		},
+		Refs: []TableRef{{Name: "fk_Post_userId", Field: "userId", RefTable: "User", RefField: "id"}},
+		Options: TableOptions{
+			Engine:    "InnoDB",
+			Collation: "utf8mb4_bin",
+			Columns:   map[string]string{"title": "utf8mb4_general_ci", "body": "utf8mb4_bin"},
+		},
+	}
+	warnings := make([]string, 0)
+	desc := makeTableDesc(schema, func(field, msg string) {
+		warnings = append(warnings, field+" "+msg)
+	})
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(desc, "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	expected := []string{
+		"Post collate=utf8mb4_bin // 文章",
+		"id ubi AI",
+		"userId ubi I1:2 nn >User",
+		"title v200 I(20) nn collate=utf8mb4_general_ci // 标题",
+		"body t TI",
+		"status e(Draft,Published) nn",
+		"createTime dt I1:1- ct",
+		"updateTime dt ctu",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("desc:\n%s\nexpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// 不能表示的索引和默认值输出警告，不会输出无法导入的 TI1
+	if len(warnings) != 3 || !strings.Contains(warnings[0], "tk_Post_all") || !strings.Contains(warnings[1], "uk_Post_title") || !strings.Contains(warnings[2], "Draft") {
+		t.Fatalf("warnings: %q", warnings)
+	}
+
+	// 导出的描述再导入时得到相同的索引和类型
+	table := MakeERFromDesc("mysql", desc)[0].Tables[0]
+	fields := map[string]TableField{}
+	for _, field := range table.Fields {
+		fields[field.Name] = field
+	}
+	if f := fields["title"]; f.Type != "VARCHAR(200)" || f.Index != "INDEX" || f.IndexLen != 20 || f.Collate != "utf8mb4_general_ci" {
+		t.Fatalf("title: %+v", f)
+	}
+	if f := fields["body"]; f.Type != "LONGTEXT" || f.Index != "FULLTEXT" {
+		t.Fatalf("body: %+v", f)
+	}
+	if f := fields["createTime"]; f.IndexGroup != "1" || f.IndexPos != 1 || !f.IndexDesc || f.Default != typeMapping["mysql"]["ct"] {
+		t.Fatalf("createTime: %+v", f)
+	}
+	if f := fields["updateTime"]; !f.OnUpdate {
+		t.Fatalf("updateTime: %+v", f)
+	}
+	if f := fields["userId"]; f.IndexGroup != "1" || f.IndexPos != 2 || f.RefTable != "User" {
+		t.Fatalf("userId: %+v", f)
+	}
+	if table.Collate != "utf8mb4_bin" || table.Comment != "文章" {
+		t.Fatalf("table: %+v", table)
+	}
+}
+
+// TestMakeDescFromDBSqlite 导出 SQLite 数据库得到原来的描述，导入到新数据库后再导出得到相同的内容，对原数据库没有变更
+func TestMakeDescFromDBSqlite(t *testing.T) {
+	conn := newTestDB(t)
+	if err := MakeDBFromDesc(conn, testExportDesc, nil); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := MakeDescFromDB(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc != testExportDesc {
+		t.Fatalf("desc:\n%s\nexpected:\n%s", desc, testExportDesc)
+	}
+
+	plans, err := MakePlanFromDesc(conn, desc, nil)
+	if err != nil || len(plans) != 0 {
+		t.Fatalf("plans of exported desc: %s %v", MakePlanSql(plans), err)
+	}
+
+	conn2 := newTestDB(t)
+	if err := MakeDBFromDesc(conn2, desc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if desc2, _ := MakeDescFromDB(conn2, nil); desc2 != desc {
+		t.Fatalf("desc after import:\n%s\nexpected:\n%s", desc2, desc)
+	}
+	for _, table := range []string{"Account", "AccountLog", "AccountRegion"} {
+		if dumpSqliteTable(conn2, table) != dumpSqliteTable(conn, table) {
+			t.Fatalf("%s:\n%s\nexpected:\n%s", table, dumpSqliteTable(conn2, table), dumpSqliteTable(conn, table))
+		}
+	}
+}
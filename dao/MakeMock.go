@@ -0,0 +1,141 @@
+package dao
+
+import (
+	_ "embed"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed a_mock.go.tpl
+var mockTpl string
+
+type MockData struct {
+	*TableData
+	Imports []string
+	Methods []MockMethod
+}
+
+// MockMethod Dao 的一个导出方法，返回值总是带名字，没有设置 XxxFunc 时直接 return 返回零值
+type MockMethod struct {
+	Name        string
+	Params      string // id uint64, args ...interface{}
+	Args        string // id, args...
+	RecordArgs  string // id, args
+	Returns     string // 源代码中的返回值，*UserItem
+	Results     string // 带名字的返回值，(r0 *UserItem)
+	FirstResult string // 第一个返回值的名字，为空表示没有返回值
+	FirstType   string
+}
+
+// makeMock 从生成的表文件中读取 Dao 的所有导出方法，生成接口和记录调用的 Mock，自定义模版中增加的方法也会包含在内
+func makeMock(tableFile, mockFile string, tableData *TableData) error {
+	src, err := os.ReadFile(tableFile)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, tableFile, src, 0)
+	if err != nil {
+		return err
+	}
+	text := func(node ast.Node) string {
+		return string(src[fset.Position(node.Pos()).Offset:fset.Position(node.End()).Offset])
+	}
+
+	daoType := tableData.FixedTableName + "Dao"
+	data := MockData{TableData: tableData, Methods: make([]MockMethod, 0)}
+	signatures := make([]string, 0)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || !fn.Name.IsExported() {
+			continue
+		}
+		if star, ok := fn.Recv.List[0].Type.(*ast.StarExpr); !ok || text(star.X) != daoType {
+			continue
+		}
+
+		method := MockMethod{Name: fn.Name.Name}
+		params := make([]string, 0)
+		args := make([]string, 0)
+		recordArgs := make([]string, 0)
+		for _, field := range fn.Type.Params.List {
+			names := make([]string, 0)
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+			if len(names) == 0 {
+				names = append(names, "p"+strconv.Itoa(len(args)))
+			}
+			_, isVariadic := field.Type.(*ast.Ellipsis)
+			for _, name := range names {
+				if name == "_" {
+					name = "p" + strconv.Itoa(len(args))
+				}
+				params = append(params, name+" "+text(field.Type))
+				recordArgs = append(recordArgs, name)
+				if isVariadic {
+					args = append(args, name+"...")
+				} else {
+					args = append(args, name)
+				}
+			}
+		}
+		method.Params = strings.Join(params, ", ")
+		method.Args = strings.Join(args, ", ")
+		method.RecordArgs = strings.Join(recordArgs, ", ")
+
+		results := make([]string, 0)
+		if fn.Type.Results != nil {
+			method.Returns = text(fn.Type.Results)
+			for _, field := range fn.Type.Results.List {
+				names := make([]string, 0)
+				for _, name := range field.Names {
+					names = append(names, name.Name)
+				}
+				if len(names) == 0 {
+					names = append(names, "r"+strconv.Itoa(len(results)))
+				}
+				for _, name := range names {
+					if method.FirstResult == "" {
+						method.FirstResult = name
+						method.FirstType = text(field.Type)
+					}
+					results = append(results, name+" "+text(field.Type))
+				}
+			}
+		}
+		if len(results) > 0 {
+			method.Results = "(" + strings.Join(results, ", ") + ")"
+		}
+		data.Methods = append(data.Methods, method)
+		signatures = append(signatures, method.Params, method.Results)
+	}
+
+	// 只导入方法签名中用到的包
+	used := strings.Join(signatures, " ")
+	for _, imp := range file.Imports {
+		importPath, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(importPath)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && strings.Contains(used, name+".") {
+			data.Imports = append(data.Imports, imp.Path.Value)
+		}
+	}
+
+	tpl, err := loadTemplate("a_mock.go.tpl", mockTpl)
+	if err != nil {
+		return err
+	}
+	if err = writeWithTpl(mockFile, tpl, data); err != nil {
+		return fmt.Errorf("%s: %w", mockFile, err)
+	}
+	return nil
+}
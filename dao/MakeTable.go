@@ -1,8 +1,12 @@
 package dao
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/ssgo/db"
@@ -23,6 +27,8 @@ type TableFieldDesc struct {
 type TableKeyDesc struct {
 	Key_name    string
 	Column_name string
+	Collation   string // A 升序，D 降序
+	Sub_part    int    // 前缀索引的长度
 }
 
 type TableField struct {
@@ -30,17 +36,66 @@ type TableField struct {
 	Type       string
 	Index      string
 	IndexGroup string
+	IndexPos   int  // 在复合索引中的位置，来自 I1:2，未指定时按字段顺序填入没有指定的位置
+	IndexLen   int  // 前缀索引的长度，来自 I(20)，只在 mysql 中有效
+	IndexDesc  bool // 降序索引，来自 I-
 	Default    string
 	Comment    string
 	Null       string
 	Extra      string
+	OnUpdate   bool
+	RefTable   string // 引用的表，来自 >Table.field
+	RefField   string // 引用的字段
+	OldName    string // 重命名前的字段名，来自 <oldName
+	Collate    string // 字段的排序规则，来自 collate=utf8mb4_bin，只在 mysql 中有效
+	DaoType    string // 生成代码时使用的类型（mysql 的写法），用于 sqlite 等没有对应类型的数据库，如 json、enum('a','b')
 	Desc       string
 }
 
 type TableStruct struct {
-	Name    string
-	Comment string
-	Fields  []TableField
+	Name          string
+	Comment       string
+	AllowDrop     bool   // 表名后标记 allowDrop 时允许执行会丢失数据的变更
+	Engine        string // 表名后的选项，如 engine=InnoDB charset=utf8mb4 collate=utf8mb4_bin，只在 mysql 中有效
+	Charset       string
+	Collate       string
+	RowFormat     string
+	Partition     string // 分区，如 partition="HASH(userId) PARTITIONS 8"
+	AutoIncrement int    // 自增字段的起始值，只在创建表或当前值更小时设置
+	Fields        []TableField
+}
+
+// TablePlan 一张表需要执行的变更，Sqls 为空表示结构一致无需更新
+type TablePlan struct {
+	Name      string
+	Comment   string
+	IsNew     bool
+	AllowDrop bool
+	Rebuild   bool // sqlite3 通过重建表修改，Sqls 中包含 BEGIN、COMMIT，需要在同一个连接中执行
+	Sqls      []string
+	Diffs     []TableDiff
+}
+
+// TableDiff 描述文件与数据库之间的一处差异，Old 为数据库中的值，New 为描述文件中的值
+type TableDiff struct {
+	Type   string // table、option、field、index、primary、foreignKey、trigger
+	Action string // add、drop、change、rename
+	Name   string
+	Old    string
+	New    string
+	Level  string // safe、lossy（可能丢失数据）、destructive（删除字段、索引、主键）
+	Rows   int    // lossy 变更会影响的数据行数，-1 表示无法检查
+}
+
+// Refused 返回没有允许时不能执行的变更：destructive，以及存在受影响数据或无法检查的 lossy
+func (plan *TablePlan) Refused() []TableDiff {
+	refused := make([]TableDiff, 0)
+	for _, diff := range plan.Diffs {
+		if diff.Level == "destructive" || (diff.Level == "lossy" && diff.Rows != 0) {
+			refused = append(refused, diff)
+		}
+	}
+	return refused
 }
 
 func (field *TableField) Parse(tableType string) {
@@ -49,8 +104,10 @@ func (field *TableField) Parse(tableType string) {
 	//}
 
 	if strings.HasPrefix(tableType, "sqlite") || tableType == "chai" {
-		// sqlite3 不能修改字段，统一使用NULL
-		field.Null = "NULL"
+		if tableType == "chai" {
+			// chai 不能修改字段，统一使用NULL（sqlite3 通过重建表修改字段）
+			field.Null = "NULL"
+		}
 		if field.Extra == "AUTOINCREMENT" {
 			field.Extra = "PRIMARY KEY AUTOINCREMENT"
 			field.Type = "integer"
@@ -58,12 +115,19 @@ func (field *TableField) Parse(tableType string) {
 		}
 	}
 
+	if fixDBType(tableType) == "pgsql" && strings.Contains(field.Extra, "IDENTITY") && !strings.Contains(strings.ToUpper(field.Type), "INT") {
+		// pgsql 的自增字段只能使用整数类型
+		field.Type = "BIGINT"
+	}
+
 	a := make([]string, 0)
 
 	if tableType == "mysql" {
 		a = append(a, fmt.Sprintf("`%s` %s", field.Name, field.Type))
 		lowerType := strings.ToLower(field.Type)
-		if strings.Contains(lowerType, "varchar") || strings.Contains(lowerType, "text") {
+		if field.Collate != "" {
+			a = append(a, " COLLATE "+field.Collate)
+		} else if strings.Contains(lowerType, "varchar") || strings.Contains(lowerType, "text") {
 			a = append(a, " COLLATE utf8mb4_general_ci")
 		}
 	} else {
@@ -92,9 +156,18 @@ func (field *TableField) Parse(tableType string) {
 			a = append(a, " DEFAULT '"+field.Default+"'")
 		}
 	}
+	if strings.HasPrefix(tableType, "sqlite") {
+		if check := makeSqliteCheck(field.Name, field.DaoType); check != "" {
+			a = append(a, " CHECK("+check+")")
+		}
+	}
 	if strings.HasPrefix(tableType, "sqlite") || tableType == "chai" {
 		field.Comment = ""
-		field.Type = "numeric"
+		if tableType == "chai" {
+			field.Type = "numeric"
+		}
+	} else if fixDBType(tableType) == "pgsql" {
+		// pgsql 不支持在字段中定义注释，使用 COMMENT ON 单独设置
 		// } else if tableType == "mysql" {
 	} else {
 		if field.Comment != "" {
@@ -116,6 +189,18 @@ func (field *TableField) Parse(tableType string) {
 // var ddlIndexFieldMatcher = regexp.MustCompile("[`\\[]?([^)]+)[`\\]]?\\s*(,|$)")
 
 func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
+	plan, err := MakeTablePlan(conn, table)
+	if err != nil {
+		if logger != nil {
+			logger.Error(err.Error())
+		}
+		return err
+	}
+	return RunTablePlan(conn, plan, logger)
+}
+
+// MakeTablePlan 对比描述与数据库中的表结构，生成需要执行的SQL但不执行
+func MakeTablePlan(conn *db.DB, table *TableStruct) (*TablePlan, error) {
 	//fmt.Println(u.JsonP(ddlKeyMatcher.FindAllStringSubmatch("(`key`,id, `name` )", 100)), "====================")
 	fieldSets := make([]string, 0)
 	//fieldSetBy := make(map[string]string)
@@ -123,7 +208,22 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 	keySets := make([]string, 0)
 	keySetBy := make(map[string]string)
 	keySetFields := make(map[string]string)
+	// 索引的字段在所有字段处理完后按位置排序生成
+	keyNames := make([]string, 0)
+	keyFields := make(map[string][]TableField)
+	pkFields := make([]TableField, 0)
+	isPgsql := fixDBType(conn.Config.Type) == "pgsql"
+	isMysql := !strings.HasPrefix(conn.Config.Type, "sqlite") && conn.Config.Type != "chai" && !isPgsql
+	// mysql 的字符字段默认使用表的排序规则，字段中的 collate= 优先
+	tableCollate := ""
+	if isMysql {
+		tableCollate = getMysqlTableCollate(conn, table)
+	}
 	for i, field := range table.Fields {
+		lowerType := strings.ToLower(field.Type)
+		if tableCollate != "" && field.Collate == "" && (strings.Contains(lowerType, "char") || strings.Contains(lowerType, "text")) {
+			field.Collate = tableCollate
+		}
 		field.Parse(conn.Config.Type)
 		table.Fields[i] = field
 
@@ -140,44 +240,34 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 		case "PRIMARY KEY", "primary key":
 			if strings.HasPrefix(conn.Config.Type, "sqlite") {
 				if field.Extra != "PRIMARY KEY AUTOINCREMENT" {
-					pks = append(pks, field.Name)
+					pkFields = append(pkFields, field)
 				}
 			} else {
-				pks = append(pks, field.Name)
+				pkFields = append(pkFields, field)
 			}
 		case "UNIQUE", "unique":
 			keyName := fmt.Sprint("uk_", table.Name, "_", field.Name)
 			if field.IndexGroup != "" {
 				keyName = fmt.Sprint("uk_", table.Name, "_", field.IndexGroup)
 			}
-			if keySetBy[keyName] != "" {
-				keySetFields[keyName] += " " + field.Name
-				// 复合索引
-				if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" {
-					keySetBy[keyName] = strings.Replace(keySetBy[keyName], ")", ", "+conn.Quote(field.Name)+")", 1)
-					// } else if conn.Config.Type == "mysql" {
-				} else {
-					keySetBy[keyName] = strings.Replace(keySetBy[keyName], ") COMMENT", ", "+conn.Quote(field.Name)+") COMMENT", 1)
-				}
-			} else {
-				keySetFields[keyName] = field.Name
-				keySet := ""
-				if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" {
-					keySet = fmt.Sprintf("CREATE UNIQUE INDEX \"%s\" ON \"%s\" (\"%s\")", keyName, table.Name, field.Name)
-					// } else if conn.Config.Type == "mysql" {
-				} else {
-					keySet = fmt.Sprintf("UNIQUE KEY "+conn.Quote("%s")+" ("+conn.Quote("%s")+") COMMENT '%s'", keyName, field.Name, field.Comment)
-				}
-				keySets = append(keySets, keySet)
-				keySetBy[keyName] = keySet
+			if keyFields[keyName] == nil {
+				keyNames = append(keyNames, keyName)
 			}
-		case "FULLTEXT", "fulltext":
+			keyFields[keyName] = append(keyFields[keyName], field)
+		case "FULLTEXT", "fulltext", "USING GIN":
 			if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" {
+			} else if isPgsql {
+				keyName := fmt.Sprint("tk_", table.Name, "_", field.Name)
+				keySet := fmt.Sprintf("CREATE INDEX \"%s\" ON \"%s\" USING GIN (to_tsvector('simple', \"%s\"))", keyName, table.Name, field.Name)
+				keySetFields[keyName] = field.Name
+				keyNames = append(keyNames, keyName)
+				keySetBy[keyName] = keySet
 				// } else if conn.Config.Type == "mysql" {
 			} else {
 				keyName := fmt.Sprint("tk_", table.Name, "_", field.Name)
 				keySet := fmt.Sprintf("FULLTEXT KEY "+conn.Quote("%s")+" ("+conn.Quote("%s")+") COMMENT '%s'", keyName, field.Name, field.Comment)
-				keySets = append(keySets, keySet)
+				keySetFields[keyName] = field.Name
+				keyNames = append(keyNames, keyName)
 				keySetBy[keyName] = keySet
 			}
 		case "INDEX", "index":
@@ -185,98 +275,134 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 			if field.IndexGroup != "" {
 				keyName = fmt.Sprint("ik_", table.Name, "_", field.IndexGroup)
 			}
-			if keySetBy[keyName] != "" {
-				keySetFields[keyName] += " " + field.Name
-				// 复合索引
-				if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" {
-					keySetBy[keyName] = strings.Replace(keySetBy[keyName], ")", ", \""+field.Name+"\")", 1)
-					// } else if conn.Config.Type == "mysql" {
-				} else {
-					keySetBy[keyName] = strings.Replace(keySetBy[keyName], ") COMMENT", ", `"+field.Name+"`) COMMENT", 1)
-				}
-			} else {
-				keySetFields[keyName] = field.Name
-				keySet := ""
-				if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" {
-					keySet = fmt.Sprintf("CREATE INDEX \"%s\" ON \"%s\" (\"%s\")", keyName, table.Name, field.Name)
-					// } else if conn.Config.Type == "mysql" {
-				} else {
-					keySet = fmt.Sprintf("KEY "+conn.Quote("%s")+" ("+conn.Quote("%s")+") COMMENT '%s'", keyName, field.Name, field.Comment)
-				}
-				keySets = append(keySets, keySet)
-				keySetBy[keyName] = keySet
+			if keyFields[keyName] == nil {
+				keyNames = append(keyNames, keyName)
 			}
+			keyFields[keyName] = append(keyFields[keyName], field)
 		}
 
 		fieldSets = append(fieldSets, field.Desc)
 		//fieldSetBy[field.Name] = field.Desc
 	}
 	//fmt.Println(u.JsonP(table.Fields))
+
+	// 复合索引按 I1:2 指定的位置排列字段，mysql 支持前缀长度 I(20)，I- 为降序
+	sortIndexFields(pkFields)
+	for _, field := range pkFields {
+		pks = append(pks, field.Name)
+	}
+	for _, keyName := range keyNames {
+		if keySetBy[keyName] == "" {
+			fields := keyFields[keyName]
+			comment := fields[0].Comment
+			sortIndexFields(fields)
+			columns := make([]string, 0)
+			columnNames := make([]string, 0)
+			isUnique := strings.ToUpper(fields[0].Index) == "UNIQUE"
+			for _, field := range fields {
+				if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" || isPgsql {
+					columns = append(columns, "\""+field.Name+"\""+u.StringIf(field.IndexDesc, " DESC", ""))
+					columnNames = append(columnNames, makeIndexColumnName(field.Name, 0, field.IndexDesc))
+					// } else if conn.Config.Type == "mysql" {
+				} else {
+					column := conn.Quote(field.Name)
+					if field.IndexLen > 0 {
+						column += fmt.Sprintf("(%d)", field.IndexLen)
+					}
+					columns = append(columns, column+u.StringIf(field.IndexDesc, " DESC", ""))
+					columnNames = append(columnNames, makeIndexColumnName(field.Name, field.IndexLen, field.IndexDesc))
+				}
+			}
+			keySetFields[keyName] = strings.Join(columnNames, " ")
+			if strings.HasPrefix(conn.Config.Type, "sqlite") || conn.Config.Type == "chai" || isPgsql {
+				keySetBy[keyName] = fmt.Sprintf("CREATE %sINDEX \"%s\" ON \"%s\" (%s)", u.StringIf(isUnique, "UNIQUE ", ""), keyName, table.Name, strings.Join(columns, ", "))
+				// } else if conn.Config.Type == "mysql" {
+			} else {
+				keySetBy[keyName] = fmt.Sprintf("%sKEY %s (%s) COMMENT '%s'", u.StringIf(isUnique, "UNIQUE ", ""), conn.Quote(keyName), strings.Join(columns, ", "), comment)
+			}
+		}
+		keySets = append(keySets, keySetBy[keyName])
+	}
 	//fmt.Println(u.JsonP(keySetBy), 3)
 	//fmt.Println(u.JsonP(keySets), 4)
 
-	var result *db.ExecResult
-	var tableInfo map[string]interface{}
+	// 外键约束 fk_表名_字段名，只有开启 EnableForeignKey 时才创建
+	fkKeys := make([]string, 0)
+	fkSetBy := make(map[string]string)
+	fkRefs := make(map[string]string)
+	if EnableForeignKey {
+		for _, field := range table.Fields {
+			if field.RefTable != "" {
+				keyName := fmt.Sprint("fk_", table.Name, "_", field.Name)
+				fkKeys = append(fkKeys, keyName)
+				fkRefs[keyName] = field.Name + ">" + field.RefTable + "." + field.RefField
+				fkSetBy[keyName] = "CONSTRAINT " + conn.Quote(keyName) + " FOREIGN KEY (" + conn.Quote(field.Name) + ") REFERENCES " + conn.Quote(field.RefTable) + " (" + conn.Quote(field.RefField) + ")"
+			}
+		}
+	}
+
+	plan := &TablePlan{
+		Name:      table.Name,
+		Comment:   table.Comment,
+		AllowDrop: table.AllowDrop,
+		Sqls:      make([]string, 0),
+		Diffs:     make([]TableDiff, 0),
+	}
+	addDiff := func(typ, action, name, oldValue, newValue string) *TableDiff {
+		level := "safe"
+		if (action == "drop" && (typ == "table" || typ == "field" || typ == "index" || typ == "primary")) || (action == "change" && typ == "primary") {
+			level = "destructive"
+		}
+		plan.Diffs = append(plan.Diffs, TableDiff{Type: typ, Action: action, Name: name, Old: oldValue, New: newValue, Level: level})
+		return &plan.Diffs[len(plan.Diffs)-1]
+	}
+
+	var r *db.QueryResult
 	if strings.HasPrefix(conn.Config.Type, "sqlite") {
-		tableInfo = conn.Query("SELECT \"name\", \"sql\" FROM \"sqlite_master\" WHERE \"type\"='table' AND \"name\"='" + table.Name + "'").MapOnR1()
-		tableInfo["comment"] = ""
+		r = conn.Query("SELECT \"name\", \"sql\" FROM \"sqlite_master\" WHERE \"type\"='table' AND \"name\"='" + table.Name + "'")
 	} else if conn.Config.Type == "chai" {
-		tableInfo = conn.Query("SELECT \"name\", \"sql\" FROM \"__chai_catalog\" WHERE \"type\"='table' AND \"name\"='" + table.Name + "'").MapOnR1()
-		tableInfo["comment"] = ""
+		r = conn.Query("SELECT \"name\", \"sql\" FROM \"__chai_catalog\" WHERE \"type\"='table' AND \"name\"='" + table.Name + "'")
+	} else if isPgsql {
+		r = conn.Query("SELECT c.relname AS name, obj_description(c.oid, 'pg_class') AS comment FROM pg_class c JOIN pg_namespace n ON n.oid=c.relnamespace WHERE n.nspname=current_schema() AND c.relkind IN ('r','p') AND c.relname='" + table.Name + "'")
 		// } else if conn.Config.Type == "mysql" {
 	} else {
-		tableInfo = conn.Query("SELECT TABLE_NAME name, TABLE_COMMENT comment FROM information_schema.TABLES WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table.Name + "'").MapOnR1()
+		r = conn.Query("SELECT TABLE_NAME name, TABLE_COMMENT comment FROM information_schema.TABLES WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table.Name + "'")
 	}
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	tableInfo := r.MapOnR1()
 	oldTableComment := u.String(tableInfo["comment"])
 
-	sqlLog := make([]string, 0)
 	if tableInfo["name"] != nil && tableInfo["name"] != "" {
 		// 合并字段
 		oldFieldList := make([]*TableFieldDesc, 0)
 		oldFields := make(map[string]*TableFieldDesc)
 		oldIndexes := make(map[string]string)
 		oldIndexInfos := make([]*TableKeyDesc, 0)
+		oldPkName := ""
+		oldOptions := TableOptions{Columns: map[string]string{}}
 
 		oldComments := map[string]string{}
+		oldChecks := map[string]string{} // sqlite 中 json、enum、set 字段的 CHECK 约束对应的类型
 		if strings.HasPrefix(conn.Config.Type, "sqlite") {
-			tmpFields := []struct {
-				Name       string
-				Type       string
-				Notnull    bool
-				Dflt_value any
-				Pk         bool
-			}{}
-			conn.Query("PRAGMA table_info(" + conn.Quote(table.Name) + ")").To(&tmpFields)
-			for _, f := range tmpFields {
+			oldChecks = getSqliteChecks(u.String(tableInfo["sql"]))
+			for _, f := range getSqliteColumns(conn, table.Name) {
 				oldFieldList = append(oldFieldList, &TableFieldDesc{
 					Field:   f.Name,
 					Type:    f.Type,
 					Null:    u.StringIf(f.Notnull, "NO", "YES"),
-					Key:     u.StringIf(f.Pk, "PRI", ""),
-					Default: u.String(f.Dflt_value),
+					Key:     u.StringIf(f.Pk > 0, "PRI", ""),
+					Default: strings.Trim(u.String(f.Dflt_value), "'"),
 				})
 			}
 
-			tmpIndexes := []struct {
-				Name    string
-				Unique  bool
-				Origin  string
-				Partial int
-			}{}
-			conn.Query("PRAGMA index_list(" + conn.Quote(table.Name) + ")").To(&tmpIndexes)
-			for _, i := range tmpIndexes {
-				tmpIndexInfo := []struct {
-					Name  string
-					Seqno int
-					Cid   int
-				}{}
-				conn.Query("PRAGMA index_info(" + conn.Quote(i.Name) + ")").To(&tmpIndexInfo)
-				if len(tmpIndexInfo) > 0 {
-					oldIndexInfos = append(oldIndexInfos, &TableKeyDesc{
-						Key_name:    i.Name,
-						Column_name: tmpIndexInfo[0].Name,
-					})
-				}
+			for _, i := range getSqliteIndexes(conn, table.Name) {
+				oldIndexInfos = append(oldIndexInfos, &TableKeyDesc{
+					Key_name:    i.Key_name,
+					Column_name: i.Column_name,
+					Collation:   u.StringIf(i.Desc, "D", "A"),
+				})
 			}
 
 			// tableM := ddlTableMatcher.FindStringSubmatch(u.String(tableInfo["sql"]))
@@ -345,19 +471,51 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 
 		} else if conn.Config.Type == "chai" {
 
+		} else if isPgsql {
+			for _, f := range getPgsqlColumns(conn, table.Name) {
+				fieldDesc := &TableFieldDesc{
+					Field: f.Field,
+					Type:  fixPgsqlType(f.Type),
+					Null:  u.StringIf(f.Nullable, "YES", "NO"),
+					Extra: u.StringIf(f.Identity != "", typeMapping["pgsql"]["AI"], ""),
+				}
+				if f.Dflt != nil {
+					fieldDesc.Default = fixPgsqlDefault(*f.Dflt)
+				}
+				if f.Comment != nil {
+					oldComments[f.Field] = *f.Comment
+				}
+				oldFieldList = append(oldFieldList, fieldDesc)
+			}
+
+			for _, i := range getPgsqlIndexes(conn, table.Name) {
+				keyName := i.Key_name
+				if i.Is_primary {
+					// 统一使用 PRIMARY 表示主键，删除时使用原始的约束名称
+					oldPkName = keyName
+					keyName = "PRIMARY"
+				}
+				oldIndexInfos = append(oldIndexInfos, &TableKeyDesc{
+					Key_name:    keyName,
+					Column_name: i.Column_name,
+					Collation:   u.StringIf(i.Is_desc, "D", "A"),
+				})
+			}
 			// } else if conn.Config.Type == "mysql" {
 		} else {
 			_ = conn.Query("SELECT column_name, column_comment FROM information_schema.columns WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table.Name + "'").ToKV(&oldComments)
 			_ = conn.Query("DESC " + conn.Quote(table.Name)).To(&oldFieldList)
 			_ = conn.Query("SHOW INDEX FROM " + conn.Quote(table.Name)).To(&oldIndexInfos)
+			oldOptions = getMysqlTableOptions(conn, table.Name)
 		}
 		//fmt.Println(u.JsonP(oldComments), 111)
 
 		for _, indexInfo := range oldIndexInfos {
+			column := makeIndexColumnName(indexInfo.Column_name, indexInfo.Sub_part, indexInfo.Collation == "D")
 			if oldIndexes[indexInfo.Key_name] == "" {
-				oldIndexes[indexInfo.Key_name] = indexInfo.Column_name
+				oldIndexes[indexInfo.Key_name] = column
 			} else {
-				oldIndexes[indexInfo.Key_name] += " " + indexInfo.Column_name
+				oldIndexes[indexInfo.Key_name] += " " + column
 			}
 		}
 		// fmt.Println(u.JsonP(oldFieldList), 1)
@@ -370,7 +528,9 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 		prevFieldId := ""
 		for _, field := range oldFieldList {
 			if strings.HasPrefix(conn.Config.Type, "sqlite") {
-				field.Type = "numeric"
+				// sqlite3 不支持调整字段顺序
+			} else if isPgsql {
+				// pgsql 不支持调整字段顺序
 				// } else if conn.Config.Type == "mysql" {
 			} else {
 				field.After = prevFieldId
@@ -380,10 +540,55 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 		}
 		//fmt.Println(111, u.JsonP(oldFields), 111)
 
+		// 重命名的字段（<oldName）作为原字段继续比较，避免删除后重新创建导致数据丢失
+		renamedFields := map[string]string{}
+		for _, field := range table.Fields {
+			if field.OldName == "" || oldFields[field.Name] != nil || oldFields[field.OldName] == nil {
+				continue
+			}
+			renamedFields[field.Name] = field.OldName
+			oldFields[field.Name] = oldFields[field.OldName]
+			delete(oldFields, field.OldName)
+			oldComments[field.Name] = oldComments[field.OldName]
+			oldChecks[field.Name] = oldChecks[field.OldName]
+			oldOptions.Columns[field.Name] = oldOptions.Columns[field.OldName]
+			for _, oldField := range oldFields {
+				if oldField.After == field.OldName {
+					oldField.After = field.Name
+				}
+			}
+			// 重命名字段时数据库会同步修改索引中的字段
+			for keyId, columns := range oldIndexes {
+				a := strings.Split(columns, " ")
+				for i := range a {
+					if a[i] == field.OldName || strings.HasPrefix(a[i], field.OldName+"(") {
+						a[i] = field.Name + a[i][len(field.OldName):]
+					}
+				}
+				oldIndexes[keyId] = strings.Join(a, " ")
+			}
+		}
+
 		actions := make([]string, 0)
+		// sqlite3 不能修改的字段类型、NOT NULL、删除字段、主键、外键，通过重建表实现
+		needRebuild := false
 		for keyId := range oldIndexes {
-			if keyId != "PRIMARY" && strings.ToLower(keySetFields[keyId]) != strings.ToLower(oldIndexes[keyId]) {
-				if strings.HasPrefix(conn.Config.Type, "sqlite") {
+			if strings.HasPrefix(keyId, "sqlite_autoindex_") {
+				// 建表语句中的 UNIQUE、PRIMARY KEY 约束，不能单独删除
+				addDiff("index", "drop", keyId, oldIndexes[keyId], "")
+				needRebuild = true
+				continue
+			}
+			// mysql 会为外键自动创建同名索引，由外键管理
+			if keyId != "PRIMARY" && !strings.HasPrefix(keyId, "fk_") && strings.ToLower(keySetFields[keyId]) != strings.ToLower(oldIndexes[keyId]) {
+				diff := addDiff("index", u.StringIf(keySetFields[keyId] == "", "drop", "change"), keyId, oldIndexes[keyId], keySetFields[keyId])
+				for _, fields := range keySetFields {
+					if strings.ToLower(fields) == strings.ToLower(oldIndexes[keyId]) {
+						// 相同字段的索引只是改名（如字段重命名），会重新创建
+						diff.Level = "safe"
+					}
+				}
+				if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
 					actions = append(actions, "DROP INDEX "+conn.Quote(keyId))
 					// } else if conn.Config.Type == "mysql" {
 				} else {
@@ -392,8 +597,16 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 			}
 		}
 		//fmt.Println("  =>>>>>>>>", oldIndexes, pks)
+		if strings.ToLower(oldIndexes["PRIMARY"]) != strings.ToLower(strings.Join(pks, " ")) {
+			addDiff("primary", u.StringIf(oldIndexes["PRIMARY"] == "", "add", u.StringIf(len(pks) == 0, "drop", "change")), "PRIMARY", oldIndexes["PRIMARY"], strings.Join(pks, " "))
+			if strings.HasPrefix(conn.Config.Type, "sqlite") {
+				needRebuild = true
+			}
+		}
 		if oldIndexes["PRIMARY"] != "" && strings.ToLower(oldIndexes["PRIMARY"]) != strings.ToLower(strings.Join(pks, " ")) {
 			if strings.HasPrefix(conn.Config.Type, "sqlite") {
+			} else if isPgsql {
+				actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" DROP CONSTRAINT "+conn.Quote(oldPkName))
 				// } else if conn.Config.Type == "mysql" {
 			} else {
 				actions = append(actions, "DROP PRIMARY KEY")
@@ -407,14 +620,46 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 			oldField := oldFields[field.Name]
 			// 修复部分数据库的特殊性
 			if oldField == nil {
+				addDiff("field", "add", field.Name, "", field.Desc)
 				if strings.HasPrefix(conn.Config.Type, "sqlite") {
+					if (field.Null == "NOT NULL" && field.Default == "") || strings.Contains(field.Extra, "PRIMARY KEY") || strings.Contains(field.Default, "CURRENT_TIMESTAMP") {
+						// sqlite3 不能添加没有默认值的 NOT NULL 字段、主键和默认值不是常量的字段
+						needRebuild = true
+					}
 					actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" ADD COLUMN "+field.Desc)
+				} else if isPgsql {
+					actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" ADD COLUMN "+field.Desc)
+					if field.Comment != "" {
+						actions = append(actions, "COMMENT ON COLUMN "+conn.Quote(table.Name)+"."+conn.Quote(field.Name)+" IS "+pgsqlString(field.Comment))
+					}
 					// } else if conn.Config.Type == "mysql" {
 				} else {
 					actions = append(actions, "ADD COLUMN "+field.Desc)
 				}
 			} else {
+				oldName := renamedFields[field.Name]
+				changeFrom := field.Name
+				if oldName != "" {
+					addDiff("field", "rename", field.Name, oldName, field.Name)
+					if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
+						actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" RENAME COLUMN "+conn.Quote(oldName)+" TO "+conn.Quote(field.Name))
+						// } else if conn.Config.Type == "mysql" {
+					} else {
+						// mysql 使用 CHANGE 同时完成重命名和修改
+						changeFrom = oldName
+					}
+				}
 				oldField.Type = strings.TrimSpace(strings.ReplaceAll(oldField.Type, " (", "("))
+				oldType, newType := oldField.Type, field.Type
+				if strings.HasPrefix(conn.Config.Type, "sqlite") {
+					// sqlite 的 CHECK 约束作为类型的一部分比较，变化时重建表
+					if check := makeSqliteCheck(field.Name, oldChecks[field.Name]); check != "" {
+						oldType += " CHECK(" + check + ")"
+					}
+					if check := makeSqliteCheck(field.Name, field.DaoType); check != "" {
+						newType += " CHECK(" + check + ")"
+					}
+				}
 				fixedOldDefault := u.String(oldField.Default)
 				if fixedOldDefault == "CURRENT_TIMESTAMP" && strings.Contains(oldField.Extra, "on update CURRENT_TIMESTAMP") {
 					fixedOldDefault = "CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"
@@ -425,7 +670,34 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 				}
 				//fmt.Println("  ==", field.Type, "!=", oldField.Type, "||", field.Default, "!=", fixedOldDefault, "||", field.Null, "!=", fixedOldNull, "||", oldField.After, "!=", prevFieldId, "||", oldComments[field.Name], "!=", field.Comment)
 				//fmt.Println("  ==", strings.ToLower(field.Type) != strings.ToLower(oldField.Type), strings.ToLower(field.Default) != strings.ToLower(fixedOldDefault), strings.ToLower(field.Null) != strings.ToLower(fixedOldNull), strings.ToLower(oldField.After) != strings.ToLower(prevFieldId), strings.ToLower(oldComments[field.Name]) != strings.ToLower(field.Comment))
-				if strings.ToLower(field.Type) != strings.ToLower(oldField.Type) || strings.ToLower(field.Default) != strings.ToLower(fixedOldDefault) || strings.ToLower(field.Null) != strings.ToLower(fixedOldNull) || strings.ToLower(oldField.After) != strings.ToLower(prevFieldId) || strings.ToLower(oldComments[field.Name]) != strings.ToLower(field.Comment) {
+				if strings.HasPrefix(conn.Config.Type, "sqlite") && (oldField.Key == "PRI") != strings.Contains(field.Extra, "PRIMARY KEY") {
+					addDiff("primary", u.StringIf(oldField.Key == "PRI", "drop", "add"), "PRIMARY", u.StringIf(oldField.Key == "PRI", field.Name, ""), u.StringIf(oldField.Key == "PRI", "", field.Name))
+					needRebuild = true
+				}
+				oldCollate := ""
+				if isMysql && field.Collate != "" {
+					oldCollate = oldOptions.Columns[field.Name]
+				}
+				if strings.ToLower(newType) != strings.ToLower(oldType) || strings.ToLower(field.Default) != strings.ToLower(fixedOldDefault) || strings.ToLower(field.Null) != strings.ToLower(fixedOldNull) || strings.ToLower(oldField.After) != strings.ToLower(prevFieldId) || strings.ToLower(oldComments[field.Name]) != strings.ToLower(field.Comment) || strings.ToLower(oldCollate) != strings.ToLower(u.StringIf(isMysql, field.Collate, "")) || changeFrom != field.Name {
+					oldParts := make([]string, 0)
+					newParts := make([]string, 0)
+					for _, item := range [][3]string{
+						{"type", oldType, newType},
+						{"null", fixedOldNull, field.Null},
+						{"default", fixedOldDefault, field.Default},
+						{"after", oldField.After, prevFieldId},
+						{"comment", oldComments[field.Name], field.Comment},
+						{"collate", oldCollate, u.StringIf(isMysql, field.Collate, "")},
+					} {
+						if strings.ToLower(item[1]) != strings.ToLower(item[2]) {
+							oldParts = append(oldParts, item[0]+"="+item[1])
+							newParts = append(newParts, item[0]+"="+item[2])
+						}
+					}
+					if len(oldParts) > 0 {
+						diff := addDiff("field", "change", field.Name, strings.Join(oldParts, ", "), strings.Join(newParts, ", "))
+						diff.Level, diff.Rows = checkLossyChange(conn, table.Name, u.StringIf(oldName != "", oldName, field.Name), &field, oldField, fixedOldNull)
+					}
 					//fmt.Println("    > > > > ", u.JsonP(oldField), 1111)
 					// `t4f34` varchar(100) COLLATE utf8mb4_general_ci COMMENT ''
 					// f34, varchar(100), YES, , ,
@@ -480,17 +752,21 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 						//	actions = append(actions, redoIndex)
 						//}
 
-						// 方案三 不修改字段类型，Sqlite可以兼容
-
+						// 方案三（已放弃）不修改字段类型，Sqlite可以兼容
 						//actions = append(actions, "ALTER TABLE `"+table.Name+"` ADD COLUMN "+field.Desc)
+
+						// 方案四 按 sqlite 官方的步骤重建表
+						needRebuild = true
+					} else if isPgsql {
+						actions = append(actions, makePgsqlColumnChanges(conn, table.Name, &field, oldField, fixedOldDefault, fixedOldNull, oldComments[field.Name])...)
 						// } else if conn.Config.Type == "mysql" {
 					} else {
-						actions = append(actions, "CHANGE `"+field.Name+"` "+field.Desc+after)
+						actions = append(actions, "CHANGE `"+changeFrom+"` "+field.Desc+after)
 					}
 				}
 			}
 
-			if strings.HasPrefix(conn.Config.Type, "sqlite") {
+			if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
 				// } else if conn.Config.Type == "mysql" {
 			} else {
 				prevFieldId = field.Name
@@ -499,8 +775,12 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 
 		for oldFieldName := range oldFields {
 			if newFieldExists[oldFieldName] != true {
+				addDiff("field", "drop", oldFieldName, oldFields[oldFieldName].Type, "")
 				if strings.HasPrefix(conn.Config.Type, "sqlite") {
+					needRebuild = true
 					//actions = append(actions, "ALTER TABLE `"+table.Name+"` DROP COLUMN `"+oldFieldName+"`")
+				} else if isPgsql {
+					actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" DROP COLUMN "+conn.Quote(oldFieldName))
 					// } else if conn.Config.Type == "mysql" {
 				} else {
 					actions = append(actions, "DROP COLUMN "+conn.Quote(oldFieldName))
@@ -510,6 +790,10 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 
 		// sqlite3 不支持添加主键
 		if strings.HasPrefix(conn.Config.Type, "sqlite") {
+		} else if isPgsql {
+			if len(pks) > 0 && strings.ToLower(oldIndexes["PRIMARY"]) != strings.ToLower(strings.Join(pks, " ")) {
+				actions = append(actions, "ALTER TABLE "+conn.Quote(table.Name)+" ADD PRIMARY KEY ("+conn.Quotes(pks)+")")
+			}
 			// } else if conn.Config.Type == "mysql" {
 		} else {
 			if len(pks) > 0 && strings.ToLower(oldIndexes["PRIMARY"]) != strings.ToLower(strings.Join(pks, " ")) {
@@ -521,7 +805,10 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 		//fmt.Println(222, u.JsonP(keySetBy), 222 )
 		for keyId, keySet := range keySetBy {
 			if oldIndexes[keyId] == "" || strings.ToLower(oldIndexes[keyId]) != strings.ToLower(keySetFields[keyId]) {
-				if strings.HasPrefix(conn.Config.Type, "sqlite") {
+				if oldIndexes[keyId] == "" {
+					addDiff("index", "add", keyId, "", keySetFields[keyId])
+				}
+				if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
 					actions = append(actions, keySet)
 					// } else if conn.Config.Type == "mysql" {
 				} else {
@@ -532,56 +819,148 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 
 		//fmt.Println("	=>", table.Comment, "|", oldTableComment )
 		if strings.HasPrefix(conn.Config.Type, "sqlite") {
+		} else if isPgsql {
+			if table.Comment != oldTableComment {
+				addDiff("table", "change", table.Name, oldTableComment, table.Comment)
+				actions = append(actions, "COMMENT ON TABLE "+conn.Quote(table.Name)+" IS "+pgsqlString(table.Comment))
+			}
+
+			// pgsql 使用触发器实现 ON UPDATE CURRENT_TIMESTAMP
+			oldTriggers := map[string]bool{}
+			for _, triggerName := range conn.Query("SELECT tgname FROM pg_trigger WHERE tgrelid='" + conn.Quote(table.Name) + "'::regclass AND NOT tgisinternal").StringsOnC1() {
+				if strings.HasPrefix(triggerName, "tu_"+table.Name+"_") {
+					oldTriggers[triggerName] = true
+				}
+			}
+			newTriggers := map[string]bool{}
+			for _, field := range table.Fields {
+				if field.OnUpdate {
+					triggerName := fmt.Sprint("tu_", table.Name, "_", field.Name)
+					newTriggers[triggerName] = true
+					if !oldTriggers[triggerName] {
+						addDiff("trigger", "add", triggerName, "", field.Name)
+					}
+				}
+			}
+			for triggerName := range oldTriggers {
+				if !newTriggers[triggerName] {
+					addDiff("trigger", "drop", triggerName, "", "")
+				}
+			}
+			actions = append(actions, makePgsqlTriggers(conn, table, oldTriggers)...)
 			// } else if conn.Config.Type == "mysql" {
 		} else {
 			if table.Comment != oldTableComment {
+				addDiff("table", "change", table.Name, oldTableComment, table.Comment)
 				actions = append(actions, "COMMENT '"+table.Comment+"'")
 			}
-		}
 
-		if len(actions) == 0 {
-			// 不需要更新
-			return nil
+			// 表选项，修改排序规则只影响表的默认值，字符字段单独修改
+			engine := u.StringIf(table.Engine != "", table.Engine, "InnoDB")
+			if !strings.EqualFold(engine, oldOptions.Engine) {
+				addDiff("option", "change", "engine", oldOptions.Engine, engine)
+				actions = append(actions, "ENGINE="+engine)
+			}
+			if tableCollate != "" && !strings.EqualFold(tableCollate, oldOptions.Collation) {
+				addDiff("option", "change", "collate", oldOptions.Collation, tableCollate)
+				actions = append(actions, "DEFAULT CHARSET="+strings.SplitN(tableCollate, "_", 2)[0]+" COLLATE="+tableCollate)
+			}
+			if !strings.EqualFold(table.RowFormat, oldOptions.RowFormat) {
+				addDiff("option", u.StringIf(table.RowFormat == "", "drop", u.StringIf(oldOptions.RowFormat == "", "add", "change")), "rowFormat", oldOptions.RowFormat, table.RowFormat)
+				actions = append(actions, "ROW_FORMAT="+u.StringIf(table.RowFormat == "", "DEFAULT", table.RowFormat))
+			}
+			if table.AutoIncrement > oldOptions.AutoIncrement && oldOptions.AutoIncrement > 0 {
+				// 只能调大，小于已有数据时数据库会自动使用最大值+1
+				addDiff("option", "change", "autoIncrement", u.String(oldOptions.AutoIncrement), u.String(table.AutoIncrement))
+				actions = append(actions, "AUTO_INCREMENT="+u.String(table.AutoIncrement))
+			}
 		}
 
-		tx := conn.Begin()
-		defer tx.CheckFinished()
-		if strings.HasPrefix(conn.Config.Type, "sqlite") {
-			for _, action := range actions {
-				if logger != nil {
-					sqlLog = append(sqlLog, "\t"+strings.ReplaceAll(action, "\n", "\n\t"))
-				} else {
-					// fmt.Println(u.Dim("\t" + strings.ReplaceAll(action, "\n", "\n\t")))
+		// 外键需要在修改字段前删除，在字段和索引创建后添加
+		fkDrops := make([]string, 0)
+		fkAdds := make([]string, 0)
+		if !EnableForeignKey {
+		} else if strings.HasPrefix(conn.Config.Type, "sqlite") {
+			// sqlite3 只能在创建表时定义外键，外键变化时重建表
+			oldFkRefs := map[string]string{}
+			for _, ref := range getForeignKeys(conn, table.Name) {
+				oldFkRefs[ref.Name] = ref.Field + ">" + ref.RefTable + "." + ref.RefField
+				if fkRefs[ref.Name] != oldFkRefs[ref.Name] {
+					addDiff("foreignKey", u.StringIf(fkRefs[ref.Name] == "", "drop", "change"), ref.Name, oldFkRefs[ref.Name], fkRefs[ref.Name])
+					needRebuild = true
 				}
-				result = tx.Exec(action)
-				if result.Error != nil {
-					break
+			}
+			for _, keyName := range fkKeys {
+				if oldFkRefs[keyName] == "" {
+					addDiff("foreignKey", "add", keyName, "", fkRefs[keyName])
+					needRebuild = true
 				}
 			}
-			// } else if conn.Config.Type == "mysql" {
 		} else {
-			sql := "ALTER TABLE `" + table.Name + "` " + strings.Join(actions, "\n,") + ";"
-			if logger != nil {
-				sqlLog = append(sqlLog, "\t"+strings.ReplaceAll(sql, "\n", "\n\t"))
-			} else {
-				// fmt.Println(u.Dim("\t" + strings.ReplaceAll(sql, "\n", "\n\t")))
+			oldFkRefs := map[string]string{}
+			for _, ref := range getForeignKeys(conn, table.Name) {
+				oldFkRefs[ref.Name] = ref.Field + ">" + ref.RefTable + "." + ref.RefField
+				if fkRefs[ref.Name] != oldFkRefs[ref.Name] {
+					addDiff("foreignKey", u.StringIf(fkRefs[ref.Name] == "", "drop", "change"), ref.Name, oldFkRefs[ref.Name], fkRefs[ref.Name])
+					if isPgsql {
+						fkDrops = append(fkDrops, "ALTER TABLE "+conn.Quote(table.Name)+" DROP CONSTRAINT "+conn.Quote(ref.Name))
+						// } else if conn.Config.Type == "mysql" {
+					} else {
+						fkDrops = append(fkDrops, "ALTER TABLE "+conn.Quote(table.Name)+" DROP FOREIGN KEY "+conn.Quote(ref.Name))
+					}
+				}
+			}
+			for _, keyName := range fkKeys {
+				if fkRefs[keyName] != oldFkRefs[keyName] {
+					if oldFkRefs[keyName] == "" {
+						addDiff("foreignKey", "add", keyName, "", fkRefs[keyName])
+					}
+					fkAdds = append(fkAdds, "ALTER TABLE "+conn.Quote(table.Name)+" ADD "+fkSetBy[keyName])
+				}
 			}
-			result = tx.Exec(sql)
 		}
-		if result != nil && result.Error != nil {
-			_ = tx.Rollback()
-		} else {
-			_ = tx.Commit()
+
+		plan.Sqls = append(plan.Sqls, fkDrops...)
+		if needRebuild && strings.HasPrefix(conn.Config.Type, "sqlite") {
+			if len(pks) > 0 {
+				fieldSets = append(fieldSets, "PRIMARY KEY ("+conn.Quotes(pks)+")")
+			}
+			for _, keyName := range fkKeys {
+				fieldSets = append(fieldSets, fkSetBy[keyName])
+			}
+			plan.Rebuild = true
+			plan.Sqls = append(plan.Sqls, makeSqliteRebuild(conn, table, fieldSets, keySetBy, oldFields)...)
+		} else if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
+			plan.Sqls = append(plan.Sqls, actions...)
+			// } else if conn.Config.Type == "mysql" {
+		} else if len(actions) > 0 {
+			plan.Sqls = append(plan.Sqls, "ALTER TABLE `"+table.Name+"` "+strings.Join(actions, "\n,"))
+		}
+		if isMysql && !samePartition(table.Partition, oldOptions.Partition) {
+			// 分区不能和其他修改写在一起
+			if table.Partition == "" {
+				addDiff("option", "drop", "partition", oldOptions.Partition, "")
+				plan.Sqls = append(plan.Sqls, "ALTER TABLE "+conn.Quote(table.Name)+" REMOVE PARTITIONING")
+			} else {
+				addDiff("option", u.StringIf(oldOptions.Partition == "", "add", "change"), "partition", oldOptions.Partition, table.Partition)
+				plan.Sqls = append(plan.Sqls, "ALTER TABLE "+conn.Quote(table.Name)+" PARTITION BY "+table.Partition)
+			}
 		}
+		plan.Sqls = append(plan.Sqls, fkAdds...)
 	} else {
 		// 创建新表
+		plan.IsNew = true
+		addDiff("table", "add", table.Name, "", table.Comment)
 		if len(pks) > 0 {
 			// fieldSets = append(fieldSets, "PRIMARY KEY (`"+strings.Join(pks, "`,`")+"`)")
 			fieldSets = append(fieldSets, "PRIMARY KEY ("+conn.Quotes(pks)+")")
 		}
+		for _, keyName := range fkKeys {
+			fieldSets = append(fieldSets, fkSetBy[keyName])
+		}
 
 		indexSets := make([]string, 0) // sqlite3 额外创建索引的sql
-		if strings.HasPrefix(conn.Config.Type, "sqlite") {
+		if strings.HasPrefix(conn.Config.Type, "sqlite") || isPgsql {
 			for _, indexSql := range keySetBy {
 				indexSets = append(indexSets, indexSql)
 			}
@@ -595,64 +974,479 @@ func CheckTable(conn *db.DB, table *TableStruct, logger *log.Logger) error {
 		sql := ""
 
 		if strings.HasPrefix(conn.Config.Type, "sqlite") {
-			sql = fmt.Sprintf("CREATE TABLE \"%s\" (\n%s\n);", table.Name, strings.Join(fieldSets, ",\n"))
+			sql = fmt.Sprintf("CREATE TABLE \"%s\" (\n%s\n)", table.Name, strings.Join(fieldSets, ",\n"))
+		} else if isPgsql {
+			sql = fmt.Sprintf("CREATE TABLE \"%s\" (\n%s\n)", table.Name, strings.Join(fieldSets, ",\n"))
 			// } else if conn.Config.Type == "mysql" {
 		} else {
-			sql = fmt.Sprintf("CREATE TABLE "+conn.Quote("%s")+" (\n%s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='%s';", table.Name, strings.Join(fieldSets, ",\n"), table.Comment)
+			sql = fmt.Sprintf("CREATE TABLE "+conn.Quote("%s")+" (\n%s\n) %s COMMENT='%s'", table.Name, strings.Join(fieldSets, ",\n"), makeMysqlTableOptions(table, tableCollate), table.Comment)
+			if table.Partition != "" {
+				sql += "\nPARTITION BY " + table.Partition
+			}
+		}
+		plan.Sqls = append(plan.Sqls, sql)
+		if isPgsql {
+			if table.Comment != "" {
+				plan.Sqls = append(plan.Sqls, "COMMENT ON TABLE "+conn.Quote(table.Name)+" IS "+pgsqlString(table.Comment))
+			}
+			for _, field := range table.Fields {
+				if field.Comment != "" {
+					plan.Sqls = append(plan.Sqls, "COMMENT ON COLUMN "+conn.Quote(table.Name)+"."+conn.Quote(field.Name)+" IS "+pgsqlString(field.Comment))
+				}
+			}
+		}
+		plan.Sqls = append(plan.Sqls, indexSets...)
+		if isPgsql {
+			plan.Sqls = append(plan.Sqls, makePgsqlTriggers(conn, table, map[string]bool{})...)
+		}
+	}
+
+	return plan, nil
+}
+
+// RunTablePlan 在同一个事务中执行计划中的SQL，任意一条失败则全部回滚
+func RunTablePlan(conn *db.DB, plan *TablePlan, logger *log.Logger) error {
+	if len(plan.Sqls) == 0 {
+		// 不需要更新
+		return nil
+	}
+
+	if !AllowDrop && !plan.AllowDrop {
+		refused := plan.Refused()
+		if len(refused) > 0 {
+			a := make([]string, 0)
+			for _, diff := range refused {
+				a = append(a, diff.String())
+			}
+			err := errors.New("refused to change table " + plan.Name + ": " + strings.Join(a, ", ") + ", use --allow-drop or mark the table with allowDrop")
+			if logger != nil {
+				logger.Error(err.Error())
+			}
+			return err
 		}
-		tx := conn.Begin()
-		defer tx.CheckFinished()
+	}
+
+	if plan.Rebuild {
+		// PRAGMA foreign_keys 只对当前连接有效，并且在事务中无效
+		err := runSqlsOnConn(conn, plan.Sqls)
+		if logger != nil {
+			logger.Info("rebuild table", "tableName", plan.Name, "sql", strings.Join(plan.Sqls, ";\n"), "size", len(plan.Sqls))
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+		return err
+	}
+
+	sqlLog := make([]string, 0)
+	var result *db.ExecResult
+	tx := conn.Begin()
+	defer tx.CheckFinished()
+	for _, sql := range plan.Sqls {
 		if logger != nil {
 			sqlLog = append(sqlLog, "\t"+strings.ReplaceAll(sql, "\n", "\n\t"))
-		} else {
-			// fmt.Println(u.Dim("\t" + strings.ReplaceAll(sql, "\n", "\n\t")))
 		}
 		result = tx.Exec(sql)
+		if result.Error != nil {
+			break
+		}
+	}
+	if result.Error != nil {
+		_ = tx.Rollback()
+	} else {
+		_ = tx.Commit()
+	}
 
-		if result.Error == nil {
-			if strings.HasPrefix(conn.Config.Type, "sqlite") {
-				for _, indexSet := range indexSets {
-					//fmt.Println(indexSet)
-					if logger != nil {
-						sqlLog = append(sqlLog, "\t"+strings.ReplaceAll(indexSet, "\n", "\n\t"))
-					} else {
-						// fmt.Println(u.Dim("\t" + strings.ReplaceAll(indexSet, "\n", "\n\t")))
-					}
-					r := tx.Exec(indexSet)
-					if r.Error != nil {
-						if logger != nil {
-							logger.Error(r.Error.Error())
-						}
-						result = r
-					}
-				}
+	if logger != nil {
+		logger.Info("run sql", "sql", strings.Join(sqlLog, "\n"), "size", len(sqlLog))
+		if result.Error != nil {
+			logger.Error(result.Error.Error())
+		}
+	}
+	return result.Error
+}
+
+// MakePlanSql 将计划输出为可以手工执行的SQL脚本
+func MakePlanSql(plans []*TablePlan) string {
+	out := make([]string, 0)
+	for _, plan := range plans {
+		if len(plan.Sqls) == 0 {
+			continue
+		}
+		out = append(out, fmt.Sprint("-- ", plan.Name, u.StringIf(plan.Comment != "", " "+plan.Comment, ""), u.StringIf(plan.IsNew, " (new)", "")))
+		for _, diff := range plan.Refused() {
+			out = append(out, "-- !! "+diff.String())
+		}
+		for _, sql := range plan.Sqls {
+			out = append(out, sql+";")
+		}
+		out = append(out, "")
+	}
+	return strings.Join(out, "\n")
+}
+
+// makeSqliteRebuild 按 sqlite 官方的步骤重建表：关闭外键，在事务中创建新表、复制数据、删除旧表、改名、重建索引、检查外键
+func makeSqliteRebuild(conn *db.DB, table *TableStruct, fieldSets []string, keySetBy map[string]string, oldFields map[string]*TableFieldDesc) []string {
+	newTableName := "_new_" + table.Name
+	columns := make([]string, 0)
+	values := make([]string, 0)
+	for _, field := range table.Fields {
+		oldField := oldFields[field.Name]
+		zero := sqliteZeroValue(field.Type)
+		if strings.HasPrefix(field.DaoType, "enum(") {
+			// 与 mysql 一样使用第一个值，空值不能满足 CHECK 约束
+			zero = sqliteQuotedValueMatcher.FindString(field.DaoType)
+		} else if field.DaoType == "json" {
+			zero = "'null'"
+		}
+		if oldField == nil {
+			// 新增的 NOT NULL 字段没有默认值时使用空值
+			if field.Null == "NOT NULL" && field.Default == "" && !strings.Contains(field.Extra, "AUTOINCREMENT") {
+				columns = append(columns, conn.Quote(field.Name))
+				values = append(values, zero)
 			}
+			continue
 		}
-		if result.Error != nil {
-			_ = tx.Rollback()
+		columns = append(columns, conn.Quote(field.Name))
+		// 重命名的字段从原来的字段复制
+		value := conn.Quote(oldField.Field)
+		if field.Null == "NOT NULL" && oldField.Null == "YES" {
+			value = "COALESCE(" + value + ", " + zero + ")"
+		}
+		values = append(values, value)
+	}
+
+	sqls := []string{
+		"PRAGMA foreign_keys=OFF",
+		"BEGIN",
+		fmt.Sprintf("CREATE TABLE %s (\n%s\n)", conn.Quote(newTableName), strings.Join(fieldSets, ",\n")),
+		"INSERT INTO " + conn.Quote(newTableName) + " (" + strings.Join(columns, ", ") + ") SELECT " + strings.Join(values, ", ") + " FROM " + conn.Quote(table.Name),
+		"DROP TABLE " + conn.Quote(table.Name),
+		"ALTER TABLE " + conn.Quote(newTableName) + " RENAME TO " + conn.Quote(table.Name),
+	}
+	keyNames := make([]string, 0)
+	for keyName := range keySetBy {
+		keyNames = append(keyNames, keyName)
+	}
+	sort.Strings(keyNames)
+	for _, keyName := range keyNames {
+		sqls = append(sqls, keySetBy[keyName])
+	}
+	// 关闭外键时复制的数据不检查约束，提交前检查，存在不满足约束的数据时回滚
+	sqls = append(sqls, "PRAGMA foreign_key_check("+conn.Quote(table.Name)+")")
+	return append(sqls, "COMMIT")
+}
+
+func sqliteZeroValue(typ string) string {
+	typ = strings.ToUpper(typ)
+	if strings.Contains(typ, "BLOB") || strings.Contains(typ, "BINARY") || strings.HasPrefix(typ, "BIT") {
+		return "X''"
+	} else if strings.Contains(typ, "DATE") || strings.Contains(typ, "TIME") {
+		return "''"
+	} else if sqliteAffinity(typ) != "TEXT" {
+		return "0"
+	}
+	return "''"
+}
+
+// runSqlsOnConn 在同一个连接中执行SQL，Sqls 中包含 BEGIN 时由SQL控制事务，否则使用事务执行，执行后恢复 sqlite3 的 foreign_keys 设置
+func runSqlsOnConn(conn *db.DB, sqls []string) error {
+	ctx := context.Background()
+	c, err := conn.GetOriginDB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	hasBegin := false
+	for _, query := range sqls {
+		if strings.ToUpper(query) == "BEGIN" {
+			hasBegin = true
+		}
+	}
+	if strings.HasPrefix(conn.Config.Type, "sqlite") {
+		foreignKeys := 0
+		if err := c.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err == nil && foreignKeys == 1 {
+			defer c.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+		}
+	}
+	if !hasBegin {
+		sqls = append(append([]string{"BEGIN"}, sqls...), "COMMIT")
+	}
+
+	inTx := false
+	for _, query := range sqls {
+		upperQuery := strings.ToUpper(query)
+		switch upperQuery {
+		case "BEGIN":
+			inTx = true
+		case "COMMIT", "ROLLBACK":
+			inTx = false
+		}
+		if strings.HasPrefix(upperQuery, "PRAGMA FOREIGN_KEY_CHECK") {
+			err = checkSqliteForeignKeys(ctx, c, query)
 		} else {
-			_ = tx.Commit()
+			_, err = c.ExecContext(ctx, query)
+		}
+		if err != nil {
+			if inTx {
+				_, _ = c.ExecContext(ctx, "ROLLBACK")
+			}
+			return err
 		}
+	}
+	return nil
+}
 
+// checkSqliteForeignKeys 执行 PRAGMA foreign_key_check，返回了不满足外键约束的数据时返回错误
+func checkSqliteForeignKeys(ctx context.Context, c *sql.Conn, query string) error {
+	rows, err := c.QueryContext(ctx, query)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	if logger != nil {
-		logger.Info("run sql", "sql", strings.Join(sqlLog, "\n"), "size", len(sqlLog), "sss", u.JsonP(sqlLog))
+	n := 0
+	table, parent := "", ""
+	for rows.Next() {
+		var rowTable, rowParent string
+		var rowId sql.NullInt64
+		var fkId int
+		if err := rows.Scan(&rowTable, &rowId, &rowParent, &fkId); err != nil {
+			return err
+		}
+		if n == 0 {
+			table, parent = rowTable, rowParent
+		}
+		n++
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("foreign key check failed: %d rows in %s reference missing rows in %s", n, table, parent)
+	}
+	return nil
+}
 
-	if result == nil {
-		return nil
+// String 输出差异的简要说明，如 destructive field drop name
+func (diff TableDiff) String() string {
+	return fmt.Sprint(diff.levelText(), " ", diff.Type, " ", diff.Action, " ", diff.Name)
+}
+
+func (diff TableDiff) levelText() string {
+	if diff.Level == "lossy" {
+		if diff.Rows < 0 {
+			return "lossy unchecked"
+		}
+		return fmt.Sprint("lossy ", diff.Rows, " rows")
 	}
+	return diff.Level
+}
 
-	if result.Error != nil {
-		if logger != nil {
-			logger.Error(result.Error.Error())
+var lossyTypeMatcher = regexp.MustCompile(`^([a-z ]+?)\s*(?:\((\d+)(?:,\s*\d+)?\))?\s*(unsigned)?$`)
+
+// 整数类型可以保存的范围
+var intTypeRanges = map[string][2]string{
+	"tinyint":            {"-128", "127"},
+	"tinyint unsigned":   {"0", "255"},
+	"smallint":           {"-32768", "32767"},
+	"smallint unsigned":  {"0", "65535"},
+	"mediumint":          {"-8388608", "8388607"},
+	"mediumint unsigned": {"0", "16777215"},
+	"int":                {"-2147483648", "2147483647"},
+	"int unsigned":       {"0", "4294967295"},
+	"bigint":             {"-9223372036854775808", "9223372036854775807"},
+	"bigint unsigned":    {"0", "18446744073709551615"},
+}
+
+// parseLossyType 拆分类型名称和长度，整数忽略显示宽度
+func parseLossyType(typ string) (string, int) {
+	typ = strings.TrimSpace(strings.ReplaceAll(strings.ToLower(typ), " zerofill", ""))
+	m := lossyTypeMatcher.FindStringSubmatch(typ)
+	if m == nil {
+		return typ, 0
+	}
+	base := m[1]
+	switch base {
+	case "middleint":
+		base = "mediumint"
+	case "integer", "int4":
+		base = "int"
+	case "int2":
+		base = "smallint"
+	case "int8":
+		base = "bigint"
+	case "character varying":
+		base = "varchar"
+	case "character":
+		base = "char"
+	}
+	if m[3] != "" {
+		base += " unsigned"
+	}
+	return base, u.Int(m[2])
+}
+
+// checkLossyChange 检查修改字段是否可能丢失数据（类型变小、设置 NOT NULL），并统计受影响的行数
+func checkLossyChange(conn *db.DB, tableName, oldName string, field *TableField, oldField *TableFieldDesc, oldNull string) (string, int) {
+	conds := make([]string, 0)
+	unchecked := false
+	if strings.ToUpper(oldNull) == "NULL" && strings.ToUpper(field.Null) == "NOT NULL" {
+		conds = append(conds, conn.Quote(oldName)+" IS NULL")
+	}
+
+	oldType, oldLen := parseLossyType(oldField.Type)
+	newType, newLen := parseLossyType(field.Type)
+	if strings.HasPrefix(conn.Config.Type, "sqlite") {
+		// sqlite 不限制长度和范围，复制数据时只按亲和类型转换，新的 CHECK 约束不能满足时无法复制
+		oldAffinity, newAffinity := sqliteAffinity(oldField.Type), sqliteAffinity(field.Type)
+		isNumeric := func(affinity string) bool {
+			return affinity == "INTEGER" || affinity == "REAL" || affinity == "NUMERIC"
+		}
+		if newAffinity == "TEXT" && oldAffinity != "TEXT" {
+			conds = append(conds, "typeof("+conn.Quote(oldName)+") IN ('integer', 'real')")
+		} else if isNumeric(newAffinity) && !isNumeric(oldAffinity) {
+			// 可以转换为数字的字符串会保存为数字
+			conds = append(conds, "typeof("+conn.Quote(oldName)+")='text' AND CAST("+conn.Quote(oldName)+" AS NUMERIC)="+conn.Quote(oldName))
+		}
+		if check := makeSqliteCheck(oldName, field.DaoType); check != "" {
+			conds = append(conds, "NOT ("+check+")")
+		}
+	} else if oldType != newType || oldLen != newLen {
+		isString := func(typ string) bool {
+			return typ == "char" || typ == "varchar" || strings.HasSuffix(typ, "text")
+		}
+		if (newType == "char" || newType == "varchar") && newLen > 0 {
+			if !((oldType == "char" || oldType == "varchar") && oldLen > 0 && oldLen <= newLen) {
+				if fixDBType(conn.Config.Type) == "pgsql" {
+					conds = append(conds, "LENGTH(CAST("+conn.Quote(oldName)+" AS TEXT)) > "+u.String(newLen))
+					// } else if conn.Config.Type == "mysql" {
+				} else {
+					conds = append(conds, "CHAR_LENGTH("+conn.Quote(oldName)+") > "+u.String(newLen))
+				}
+			}
+		} else if strings.HasSuffix(newType, "text") && isString(oldType) {
+		} else if newRange, ok := intTypeRanges[newType]; ok {
+			if oldRange, ok := intTypeRanges[oldType]; ok {
+				if u.Int64(oldRange[0]) < u.Int64(newRange[0]) || u.Uint64(oldRange[1]) > u.Uint64(newRange[1]) {
+					conds = append(conds, conn.Quote(oldName)+" < "+newRange[0]+" OR "+conn.Quote(oldName)+" > "+newRange[1])
+				}
+			} else {
+				unchecked = true
+			}
+		} else if (newType == "double" || newType == "double precision") && (intTypeRanges[oldType][0] != "" || oldType == "float" || oldType == "real") {
 		} else {
-			// fmt.Println(result.Error.Error())
+			// 其他类型之间的转换无法检查
+			unchecked = true
 		}
 	}
 
-	return result.Error
+	if unchecked {
+		return "lossy", -1
+	}
+	if len(conds) == 0 {
+		return "safe", 0
+	}
+	r := conn.Query("SELECT COUNT(*) FROM " + conn.Quote(tableName) + " WHERE " + strings.Join(conds, " OR "))
+	if r.Error != nil {
+		// 检查失败时按无法检查处理，没有允许时拒绝执行
+		return "lossy", -1
+	}
+	return "lossy", int(r.IntOnR1C1())
+}
+
+// pgsql 使用触发器实现 ON UPDATE CURRENT_TIMESTAMP，字段名通过触发器参数传入
+var pgsqlOnUpdateFunction = `CREATE OR REPLACE FUNCTION "_dao_on_update"() RETURNS trigger AS $$
+BEGIN
+	NEW := jsonb_populate_record(NEW, jsonb_build_object(TG_ARGV[0], CURRENT_TIMESTAMP));
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`
+
+var pgsqlNumericMatcher = regexp.MustCompile(`numeric\((\d+),0\)`)
+var pgsqlDefaultMatcher = regexp.MustCompile(`^'(.*)'::[\w\s]+(\([\d,]+\))?$`)
+var pgsqlIndexColumnMatcher = regexp.MustCompile(`"?(\w+)"?\)?(::[\w\s]+)?\)*\s*$`)
+
+func pgsqlString(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+}
+
+// fixPgsqlType 将 format_type 的结果转换为与 typeMapping 一致的写法，例如 character varying(20) => varchar(20)
+func fixPgsqlType(typ string) string {
+	typ = strings.ToLower(typ)
+	typ = strings.ReplaceAll(typ, " without time zone", "")
+	typ = strings.ReplaceAll(typ, "character varying", "varchar")
+	typ = strings.ReplaceAll(typ, "character", "char")
+	return pgsqlNumericMatcher.ReplaceAllString(typ, "numeric($1)")
+}
+
+// fixPgsqlDefault 去掉默认值中的类型转换，例如 'abc'::character varying => abc
+func fixPgsqlDefault(dflt string) string {
+	if m := pgsqlDefaultMatcher.FindStringSubmatch(dflt); m != nil {
+		return strings.ReplaceAll(m[1], "''", "'")
+	}
+	return dflt
+}
+
+// fixPgsqlIndexColumn 从索引定义中取出字段名，例如 to_tsvector('simple'::regconfig, content) => content
+func fixPgsqlIndexColumn(column string) string {
+	if m := pgsqlIndexColumnMatcher.FindStringSubmatch(column); m != nil {
+		return m[1]
+	}
+	return column
+}
+
+// makePgsqlColumnChanges pgsql 不支持 CHANGE，需要分别修改类型、是否为空、默认值和注释
+func makePgsqlColumnChanges(conn *db.DB, tableName string, field *TableField, oldField *TableFieldDesc, oldDefault, oldNull, oldComment string) []string {
+	actions := make([]string, 0)
+	alterSql := "ALTER TABLE " + conn.Quote(tableName) + " ALTER COLUMN " + conn.Quote(field.Name)
+	if strings.ToLower(field.Type) != strings.ToLower(oldField.Type) {
+		actions = append(actions, alterSql+" TYPE "+field.Type+" USING "+conn.Quote(field.Name)+"::"+field.Type)
+	}
+	if strings.ToLower(field.Null) != strings.ToLower(oldNull) {
+		actions = append(actions, alterSql+u.StringIf(field.Null == "NOT NULL", " SET NOT NULL", " DROP NOT NULL"))
+	}
+	if strings.ToLower(field.Default) != strings.ToLower(oldDefault) {
+		if field.Default == "" {
+			actions = append(actions, alterSql+" DROP DEFAULT")
+		} else if strings.Contains(field.Default, "CURRENT_TIMESTAMP") || strings.Contains(field.Default, "()") {
+			actions = append(actions, alterSql+" SET DEFAULT "+field.Default)
+		} else {
+			actions = append(actions, alterSql+" SET DEFAULT "+pgsqlString(field.Default))
+		}
+	}
+	if field.Comment != oldComment {
+		actions = append(actions, "COMMENT ON COLUMN "+conn.Quote(tableName)+"."+conn.Quote(field.Name)+" IS "+pgsqlString(field.Comment))
+	}
+	return actions
+}
+
+// makePgsqlTriggers 为 ctu 字段创建触发器，删除已经不需要的触发器
+func makePgsqlTriggers(conn *db.DB, table *TableStruct, oldTriggers map[string]bool) []string {
+	actions := make([]string, 0)
+	newTriggers := map[string]bool{}
+	functionAdded := false
+	for _, field := range table.Fields {
+		if !field.OnUpdate {
+			continue
+		}
+		triggerName := fmt.Sprint("tu_", table.Name, "_", field.Name)
+		newTriggers[triggerName] = true
+		if !oldTriggers[triggerName] {
+			if !functionAdded {
+				actions = append(actions, pgsqlOnUpdateFunction)
+				functionAdded = true
+			}
+			actions = append(actions, "CREATE TRIGGER "+conn.Quote(triggerName)+" BEFORE UPDATE ON "+conn.Quote(table.Name)+" FOR EACH ROW EXECUTE PROCEDURE \"_dao_on_update\"("+pgsqlString(field.Name)+")")
+		}
+	}
+	for triggerName := range oldTriggers {
+		if !newTriggers[triggerName] {
+			actions = append(actions, "DROP TRIGGER "+conn.Quote(triggerName)+" ON "+conn.Quote(table.Name))
+		}
+	}
+	return actions
 }
 
 var fieldSpliter = regexp.MustCompile(`\s+`)
@@ -790,3 +1584,70 @@ var wnMatcher = regexp.MustCompile(`^([a-zA-Z]+)([0-9]+)$`)
 
 // 	return fields
 // }
+
+// sortIndexFields 按 I1:2、PK:2 指定的位置排序，未指定位置的字段按声明顺序从1开始填入其他字段没有使用的位置
+func sortIndexFields(fields []TableField) {
+	used := make(map[int]bool)
+	for _, field := range fields {
+		if field.IndexPos > 0 {
+			used[field.IndexPos] = true
+		}
+	}
+	positions := make(map[string]int, len(fields))
+	next := 1
+	for _, field := range fields {
+		pos := field.IndexPos
+		if pos == 0 {
+			for used[next] {
+				next++
+			}
+			pos = next
+			used[pos] = true
+		}
+		positions[field.Name] = pos
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return positions[fields[i].Name] < positions[fields[j].Name]
+	})
+}
+
+// makeIndexColumnName 索引中字段的比较格式，如 title(20)、time DESC
+func makeIndexColumnName(name string, length int, desc bool) string {
+	if length > 0 {
+		name += fmt.Sprintf("(%d)", length)
+	}
+	if desc {
+		name += " DESC"
+	}
+	return name
+}
+
+// getMysqlTableCollate 表的排序规则，只指定 charset 时使用字符集默认的排序规则
+func getMysqlTableCollate(conn *db.DB, table *TableStruct) string {
+	if table.Collate != "" {
+		return table.Collate
+	}
+	if table.Charset == "" || strings.ToLower(table.Charset) == "utf8mb4" {
+		return "utf8mb4_general_ci"
+	}
+	return conn.Query("SELECT DEFAULT_COLLATE_NAME FROM information_schema.CHARACTER_SETS WHERE CHARACTER_SET_NAME='" + table.Charset + "'").StringOnR1C1()
+}
+
+// makeMysqlTableOptions 创建表时的选项，默认为 ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci
+func makeMysqlTableOptions(table *TableStruct, collate string) string {
+	charset := table.Charset
+	if charset == "" {
+		charset = strings.SplitN(collate, "_", 2)[0]
+	}
+	options := "ENGINE=" + u.StringIf(table.Engine != "", table.Engine, "InnoDB") + " DEFAULT CHARSET=" + u.StringIf(charset != "", charset, "utf8mb4")
+	if collate != "" {
+		options += " COLLATE=" + collate
+	}
+	if table.RowFormat != "" {
+		options += " ROW_FORMAT=" + table.RowFormat
+	}
+	if table.AutoIncrement > 0 {
+		options += " AUTO_INCREMENT=" + u.String(table.AutoIncrement)
+	}
+	return options
+}
@@ -0,0 +1,156 @@
+package dao
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/u"
+)
+
+// pgsqlTestDriver ssgo/db 按 user:password@tcp(host)/db 生成 DSN，转换为 PostgreSQL 驱动使用的 URL
+type pgsqlTestDriver struct {
+	driver driver.Driver
+}
+
+var pgsqlTestDsnMatcher = regexp.MustCompile(`^([^:@]*):?([^@]*)@\w+\(([^)]*)\)/([^?]*)\??(.*)$`)
+
+func (d pgsqlTestDriver) Open(dsn string) (driver.Conn, error) {
+	m := pgsqlTestDsnMatcher.FindStringSubmatch(dsn)
+	if m == nil {
+		return nil, fmt.Errorf("bad dsn %s", dsn)
+	}
+	args := "sslmode=disable"
+	if m[5] != "" {
+		args += "&" + m[5]
+	}
+	return d.driver.Open(fmt.Sprintf("postgres://%s:%s@%s/%s?%s", m[1], m[2], m[3], m[4], args))
+}
+
+var registerPgsqlTestDriver sync.Once
+
+// newPgsqlTestDB 使用本机的 initdb、pg_ctl 在临时目录中启动 PostgreSQL，没有时跳过
+// 需要注册 pgx 驱动：go get github.com/jackc/pgx/v5 后使用 go test -tags pgsql 运行
+func newPgsqlTestDB(t *testing.T) *db.DB {
+	initdb, err1 := exec.LookPath("initdb")
+	pgCtl, err2 := exec.LookPath("pg_ctl")
+	if err1 != nil || err2 != nil {
+		t.Skip("initdb or pg_ctl not found")
+	}
+	if !u.StringIn(sql.Drivers(), "pgx") {
+		t.Skip("pgx driver not registered, run with -tags pgsql")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("initdb cannot be run as root")
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust").CombinedOutput(); err != nil {
+		t.Fatalf("initdb failed: %s\n%s", err, out)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput(); err != nil {
+		t.Fatalf("pg_ctl start failed: %s\n%s", err, out)
+	}
+	t.Cleanup(func() { _ = exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run() })
+
+	registerPgsqlTestDriver.Do(func() {
+		pool, _ := sql.Open("pgx", "")
+		sql.Register("pgsql", pgsqlTestDriver{driver: pool.Driver()})
+	})
+	conn := db.GetDB(fmt.Sprintf("pgsql://postgres@127.0.0.1:%d/postgres", port), nil)
+	t.Cleanup(func() { _ = conn.Destroy() })
+	if r := conn.Query("SELECT 1"); r.Error != nil {
+		t.Fatal("connect failed: ", r.Error)
+	}
+	return conn
+}
+
+const testPgsqlDesc = `Article                // 文章
+id ubi AI
+title v100 nn          // 标题
+userId ubi I1
+createTime dt ct I1:2-
+updateTime dt ctu
+slug v50 U
+`
+
+func TestMakeTablePlanPgsql(t *testing.T) {
+	conn := newPgsqlTestDB(t)
+	plan := makeTestPlan(t, conn, testPgsqlDesc)
+	sqls := strings.Join(plan.Sqls, ";\n")
+	// 注释、索引、ctu 的触发器都使用单独的语句
+	for _, expected := range []string{
+		`COMMENT ON TABLE "Article" IS '文章'`,
+		`COMMENT ON COLUMN "Article"."title" IS '标题'`,
+		`CREATE INDEX "ik_Article_1" ON "Article" ("userId", "createTime" DESC)`,
+		`CREATE UNIQUE INDEX "uk_Article_slug" ON "Article" ("slug")`,
+		`CREATE TRIGGER "tu_Article_updateTime" BEFORE UPDATE ON "Article"`,
+	} {
+		if !strings.Contains(sqls, expected) {
+			t.Fatalf("new table sqls:\n%s\nexpected: %s", sqls, expected)
+		}
+	}
+	if strings.Contains(sqls, "`") || strings.Contains(sqls, "ENGINE") {
+		t.Fatal("mysql syntax in pgsql plan: ", sqls)
+	}
+	if err := RunTablePlan(conn, plan, nil); err != nil {
+		t.Fatal(err)
+	}
+	if plan = makeTestPlan(t, conn, testPgsqlDesc); len(plan.Sqls) != 0 || len(plan.Diffs) != 0 {
+		t.Fatalf("unchanged table plan: %q %q", plan.Sqls, diffTexts(plan))
+	}
+
+	// ctu 的触发器在修改时更新时间
+	if r := conn.Exec(`INSERT INTO "Article" ("title", "userId", "slug", "updateTime") VALUES ('a', 1, 'a', '2000-01-01 00:00:00')`); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if r := conn.Exec(`UPDATE "Article" SET "title"='b'`); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if updateTime := conn.Query(`SELECT "updateTime" FROM "Article"`).StringOnR1C1(); strings.HasPrefix(updateTime, "2000") {
+		t.Fatal("updateTime not changed by trigger: ", updateTime)
+	}
+
+	// 修改类型和注释，增加字段，去掉 ctu 时删除触发器
+	desc := strings.Replace(testPgsqlDesc, "title v100 nn          // 标题", "title v200 nn // 文章标题", 1)
+	desc = strings.Replace(desc, "updateTime dt ctu", "updateTime dt", 1)
+	desc += "views i\n"
+	plan = makeTestPlan(t, conn, desc)
+	sqls = strings.Join(plan.Sqls, ";\n")
+	for _, expected := range []string{
+		`ALTER TABLE "Article" ALTER COLUMN "title" TYPE VARCHAR(200)`,
+		`COMMENT ON COLUMN "Article"."title" IS '文章标题'`,
+		`ALTER TABLE "Article" ADD COLUMN "views" INTEGER`,
+		`DROP TRIGGER "tu_Article_updateTime" ON "Article"`,
+	} {
+		if !strings.Contains(sqls, expected) {
+			t.Fatalf("change sqls:\n%s\nexpected: %s", sqls, expected)
+		}
+	}
+	if err := RunTablePlan(conn, plan, nil); err != nil {
+		t.Fatal(err)
+	}
+	if plan = makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 || len(plan.Diffs) != 0 {
+		t.Fatalf("changed table plan: %q %q", plan.Sqls, diffTexts(plan))
+	}
+	if title := conn.Query(`SELECT "title" FROM "Article"`).StringOnR1C1(); title != "b" {
+		t.Fatal("data lost: ", title)
+	}
+}
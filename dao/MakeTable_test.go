@@ -0,0 +1,396 @@
+package dao
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ssgo/db"
+)
+
+const testUserDesc = `User
+id ubi AI
+phone v20 U
+name v50
+age i
+memo t
+`
+
+func newTestDB(t *testing.T) *db.DB {
+	conn := db.GetDB("sqlite://"+filepath.Join(t.TempDir(), "test.db"), nil)
+	t.Cleanup(func() { _ = conn.Destroy() })
+	return conn
+}
+
+// makeTestPlan 使用描述中的第一张表生成计划
+func makeTestPlan(t *testing.T, conn *db.DB, desc string) *TablePlan {
+	groups := MakeERFromDesc(conn.Config.Type, desc)
+	if len(groups) == 0 || len(groups[0].Tables) == 0 {
+		t.Fatal("failed to parse desc: ", desc)
+	}
+	plan, err := MakeTablePlan(conn, groups[0].Tables[0])
+	if err != nil {
+		t.Fatal("make table plan failed: ", err)
+	}
+	return plan
+}
+
+func runTestPlan(t *testing.T, conn *db.DB, desc string) {
+	if err := RunTablePlan(conn, makeTestPlan(t, conn, desc), nil); err != nil {
+		t.Fatal("run table plan failed: ", err)
+	}
+}
+
+func diffTexts(plan *TablePlan) []string {
+	a := make([]string, 0)
+	for _, diff := range plan.Diffs {
+		a = append(a, diff.String())
+	}
+	return a
+}
+
+func checkDiffs(t *testing.T, plan *TablePlan, expected ...string) {
+	t.Helper()
+	if strings.Join(diffTexts(plan), ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("diffs: %q, expected: %q", diffTexts(plan), expected)
+	}
+}
+
+func TestMakeTablePlanSqlite(t *testing.T) {
+	conn := newTestDB(t)
+	plan := makeTestPlan(t, conn, testUserDesc)
+	if !plan.IsNew || plan.Rebuild || len(plan.Sqls) == 0 {
+		t.Fatalf("new table plan: %+v", plan)
+	}
+	if err := RunTablePlan(conn, plan, nil); err != nil {
+		t.Fatal(err)
+	}
+	conn.Exec("INSERT INTO \"User\" (phone, name, age) VALUES ('1', 'a', 3), ('2', NULL, 4)")
+
+	// 结构一致时没有变更
+	plan = makeTestPlan(t, conn, testUserDesc)
+	if len(plan.Sqls) != 0 || len(plan.Diffs) != 0 {
+		t.Fatalf("unchanged table plan: %+v", plan)
+	}
+
+	// 新增字段和索引不需要重建
+	desc := testUserDesc + "email v100 I\n"
+	plan = makeTestPlan(t, conn, desc)
+	checkDiffs(t, plan, "safe field add email", "safe index add ik_User_email")
+	if plan.Rebuild || len(plan.Refused()) != 0 {
+		t.Fatalf("add field plan: %+v", plan)
+	}
+	runTestPlan(t, conn, desc)
+
+	// 重命名保留数据
+	desc = strings.Replace(desc, "phone v20 U", "phoneNumber v20 U <phone", 1)
+	plan = makeTestPlan(t, conn, desc)
+	checkDiffs(t, plan, "safe index drop uk_User_phone", "safe field rename phoneNumber", "safe index add uk_User_phoneNumber")
+	runTestPlan(t, conn, desc)
+	if phones := conn.Query("SELECT phoneNumber FROM \"User\" ORDER BY id").StringsOnC1(); strings.Join(phones, ",") != "1,2" {
+		t.Fatal("renamed field lost data: ", phones)
+	}
+	// 重命名完成后 <phone 不再产生变更
+	if plan = makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 {
+		t.Fatalf("renamed table plan: %+v", plan)
+	}
+
+	// 删除索引需要 allowDrop
+	desc = strings.Replace(desc, "phoneNumber v20 U <phone", "phoneNumber v20", 1)
+	plan = makeTestPlan(t, conn, desc)
+	checkDiffs(t, plan, "destructive index drop uk_User_phoneNumber")
+	if err := RunTablePlan(conn, plan, nil); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatal("drop index not refused: ", err)
+	}
+	runTestPlan(t, conn, strings.Replace(desc, "User\n", "User allowDrop\n", 1))
+	if plan = makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 {
+		t.Fatalf("dropped index plan: %+v", plan)
+	}
+}
+
+func TestSqliteRebuild(t *testing.T) {
+	conn := newTestDB(t)
+	runTestPlan(t, conn, testUserDesc)
+	conn.Exec("INSERT INTO \"User\" (phone, name, age, memo) VALUES ('1', 'a', 3, 'm1'), ('2', NULL, 4, 'm2')")
+
+	// 设置 NOT NULL 时统计受影响的行数，存在数据时拒绝执行，整张表不修改
+	desc := strings.Replace(testUserDesc, "name v50", "name v50 nn", 1)
+	plan := makeTestPlan(t, conn, desc)
+	if !plan.Rebuild || len(plan.Diffs) != 1 || plan.Diffs[0].Level != "lossy" || plan.Diffs[0].Rows != 1 {
+		t.Fatalf("not null plan: %+v", plan)
+	}
+	if err := RunTablePlan(conn, plan, nil); err == nil {
+		t.Fatal("lossy change not refused")
+	}
+	if n := conn.Query("SELECT COUNT(*) FROM \"User\" WHERE name IS NULL").IntOnR1C1(); n != 1 {
+		t.Fatal("refused plan changed data: ", n)
+	}
+
+	// allowDrop 时重建表，NULL 使用空字符串，数据和索引保留
+	runTestPlan(t, conn, strings.Replace(desc, "User\n", "User allowDrop\n", 1))
+	if names := conn.Query("SELECT name FROM \"User\" ORDER BY id").StringsOnC1(); strings.Join(names, ",") != "a," {
+		t.Fatal("rebuilt table data: ", names)
+	}
+	if plan = makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 {
+		t.Fatalf("rebuilt table plan: %+v", plan)
+	}
+	if r := conn.Exec("INSERT INTO \"User\" (phone, name) VALUES ('1', 'b')"); r.Error == nil {
+		t.Fatal("unique index lost after rebuild")
+	}
+	if r := conn.Exec("INSERT INTO \"User\" (phone) VALUES ('3')"); r.Error == nil {
+		t.Fatal("not null lost after rebuild")
+	}
+
+	// 删除字段为 destructive
+	dropDesc := strings.Replace(desc, "memo t\n", "", 1)
+	plan = makeTestPlan(t, conn, dropDesc)
+	checkDiffs(t, plan, "destructive field drop memo")
+	if !plan.Rebuild {
+		t.Fatalf("drop field plan: %+v", plan)
+	}
+	if err := RunTablePlan(conn, plan, nil); err == nil {
+		t.Fatal("drop field not refused")
+	}
+	runTestPlan(t, conn, strings.Replace(dropDesc, "User\n", "User allowDrop\n", 1))
+	if columns := getSqliteColumns(conn, "User"); len(columns) != 4 {
+		t.Fatalf("columns after drop: %+v", columns)
+	}
+	if n := conn.Query("SELECT COUNT(*) FROM \"User\"").IntOnR1C1(); n != 2 {
+		t.Fatal("rows after drop: ", n)
+	}
+
+	// 重建后恢复连接的 foreign_keys 设置
+	if n := conn.Query("PRAGMA foreign_keys").IntOnR1C1(); n != 0 {
+		t.Fatal("foreign_keys changed: ", n)
+	}
+}
+
+// TestCheckLossyChange 检查的查询失败时不能当作没有受影响的数据
+func TestCheckLossyChange(t *testing.T) {
+	conn := newTestDB(t)
+	conn.Exec("CREATE TABLE \"T\" (\"a\" INTEGER NULL)")
+	conn.Exec("INSERT INTO \"T\" (\"a\") VALUES (1), (NULL)")
+	field := &TableField{Name: "a", Type: "INTEGER", Null: "NOT NULL"}
+	oldField := &TableFieldDesc{Field: "a", Type: "INTEGER", Null: "YES"}
+	if level, rows := checkLossyChange(conn, "T", "a", field, oldField, "NULL"); level != "lossy" || rows != 1 {
+		t.Fatal("lossy change: ", level, rows)
+	}
+
+	level, rows := checkLossyChange(conn, "Missing", "a", field, oldField, "NULL")
+	if level != "lossy" || rows != -1 {
+		t.Fatal("failed check: ", level, rows)
+	}
+	plan := &TablePlan{Name: "T", Diffs: []TableDiff{{Type: "field", Action: "change", Name: "a", Level: level, Rows: rows}}}
+	if len(plan.Refused()) != 1 {
+		t.Fatal("failed check not refused")
+	}
+}
+
+func TestGetTableSchemaSqlite(t *testing.T) {
+	EnableForeignKey = true
+	defer func() { EnableForeignKey = false }()
+
+	conn := newTestDB(t)
+	if err := MakeDBFromDesc(conn, `User
+id c12 PK
+name v50 nn
+createTime dt ct
+
+Device
+id ubi AI
+userId c12 I1:2 >User.id
+sn v32 I1:1
+createTime dt
+`, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := GetTables(conn)
+	if err != nil || strings.Join(tables, ",") != "Device,User" {
+		t.Fatal("tables: ", tables, err)
+	}
+
+	schema, err := GetTableSchema(conn, "Device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make([]string, 0)
+	for _, field := range schema.Fields {
+		fields = append(fields, field.Field+" "+field.Type+" "+field.Null+" "+field.Key+" "+field.Extra)
+	}
+	if strings.Join(fields, ", ") != "id bigint unsigned NO PRI auto_increment, userId char(12) YES  , sn varchar(32) YES  , createTime datetime YES  " {
+		t.Fatal("fields: ", fields)
+	}
+	indexes := make([]string, 0)
+	for _, index := range schema.Indexes {
+		indexes = append(indexes, index.Key_name+":"+index.Column_name)
+	}
+	if !strings.Contains(strings.Join(indexes, ","), "ik_Device_1:sn,ik_Device_1:userId") {
+		t.Fatal("indexes: ", indexes)
+	}
+	if len(schema.Refs) != 1 || schema.Refs[0].Field != "userId" || schema.Refs[0].RefTable != "User" || schema.Refs[0].RefField != "id" {
+		t.Fatalf("refs: %+v", schema.Refs)
+	}
+
+	schema, err = GetTableSchema(conn, "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Fields) != 3 || schema.Fields[1].Null != "NO" || schema.Fields[2].Default == nil || *schema.Fields[2].Default != "CURRENT_TIMESTAMP" || schema.Fields[2].Extra != "DEFAULT_GENERATED" {
+		t.Fatalf("user fields: %+v", schema.Fields)
+	}
+	// 主键在 sqlite 中为 uk_User_99 唯一索引，读取时还原为主键
+	if schema.Fields[0].Null != "NO" || len(schema.Indexes) != 1 || schema.Indexes[0].Key_name != "PRIMARY" || schema.Indexes[0].Column_name != "id" {
+		t.Fatalf("user primary key: %+v %+v", schema.Fields[0], schema.Indexes)
+	}
+}
+
+// 增加外键时重建表，存在引用不到的数据时回滚
+func TestSqliteRebuildForeignKeyCheck(t *testing.T) {
+	conn := newTestDB(t)
+	desc := `User
+id ubi AI
+name v50
+
+Device
+id ubi AI
+userId ubi I >User.id
+`
+	if err := MakeDBFromDesc(conn, desc, nil); err != nil {
+		t.Fatal(err)
+	}
+	conn.Exec("INSERT INTO \"User\" (id, name) VALUES (1, 'a')")
+	conn.Exec("INSERT INTO \"Device\" (userId) VALUES (1), (2)")
+
+	EnableForeignKey = true
+	defer func() { EnableForeignKey = false }()
+	plans, err := MakePlanFromDesc(conn, desc, nil)
+	if err != nil || len(plans) != 1 || !plans[0].Rebuild || !strings.Contains(strings.Join(plans[0].Sqls, ";"), "PRAGMA foreign_key_check(\"Device\");COMMIT") {
+		t.Fatalf("foreign key plan: %+v %v", plans, err)
+	}
+	if err := RunTablePlan(conn, plans[0], nil); err == nil || !strings.Contains(err.Error(), "foreign key check failed: 1 rows in Device") {
+		t.Fatal("foreign key check not failed: ", err)
+	}
+	if schema, _ := GetTableSchema(conn, "Device"); len(schema.Refs) != 0 {
+		t.Fatalf("rebuild not rolled back: %+v", schema.Refs)
+	}
+	if tables, _ := GetTables(conn); strings.Join(tables, ",") != "Device,User" {
+		t.Fatal("tables after rollback: ", tables)
+	}
+
+	conn.Exec("DELETE FROM \"Device\" WHERE userId=2")
+	if err := MakeDBFromDesc(conn, desc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if schema, _ := GetTableSchema(conn, "Device"); len(schema.Refs) != 1 {
+		t.Fatalf("foreign key not added: %+v", schema.Refs)
+	}
+}
+
+func TestSortIndexFields(t *testing.T) {
+	for _, c := range []struct {
+		positions []int
+		expected  string
+	}{
+		{[]int{0, 0, 0}, "a,b,c"},
+		{[]int{2, 0}, "b,a"},
+		{[]int{0, 1}, "b,a"},
+		{[]int{0, 3, 0}, "a,c,b"},
+		{[]int{2, 1, 3}, "b,a,c"},
+		{[]int{5, 0}, "b,a"},
+	} {
+		fields := make([]TableField, 0)
+		for i, pos := range c.positions {
+			fields = append(fields, TableField{Name: string(rune('a' + i)), IndexPos: pos})
+		}
+		sortIndexFields(fields)
+		names := make([]string, 0)
+		for _, field := range fields {
+			names = append(names, field.Name)
+		}
+		if strings.Join(names, ",") != c.expected {
+			t.Errorf("%v: %v, expected: %s", c.positions, names, c.expected)
+		}
+	}
+
+	// 生成代码时的主键顺序，决定 Get 等方法的参数顺序
+	table := MakeERFromDesc("mysql", "Member\nuserId ubi PK:2\ngroupId ubi PK\nname v50\n")[0].Tables[0]
+	pks := make([]string, 0)
+	for _, index := range makeSchemaFromDesc("mysql", table).Indexes {
+		if index.Key_name == "PRIMARY" {
+			pks = append(pks, index.Column_name)
+		}
+	}
+	if strings.Join(pks, ",") != "groupId,userId" {
+		t.Fatal("primary key order: ", pks)
+	}
+}
+
+// TestSqliteCheck json、enum、set 字段使用 CHECK 约束检查内容，取值变化时重建表，不满足新约束的数据拒绝执行
+func TestSqliteCheck(t *testing.T) {
+	conn := newTestDB(t)
+	desc := "Item\nid ubi AI\nstatus e(on,off) nn\ntags s(a,b)\nattrs j\n"
+	runTestPlan(t, conn, desc)
+	if plan := makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 {
+		t.Fatal("unchanged table plan: ", plan.Sqls)
+	}
+
+	for _, values := range []string{"'on', 'a,b', '{\"a\":1}'", "'off', '', NULL", "'on', 'b,a,b', '[]'"} {
+		if r := conn.Exec(`INSERT INTO "Item" ("status", "tags", "attrs") VALUES (` + values + `)`); r.Error != nil {
+			t.Fatal(values, r.Error)
+		}
+	}
+	for _, values := range []string{"'x', NULL, NULL", "'on', 'a,c', NULL", "'on', ',', NULL", "'on', NULL, '{'"} {
+		if r := conn.Exec(`INSERT INTO "Item" ("status", "tags", "attrs") VALUES (` + values + `)`); r.Error == nil {
+			t.Fatal("invalid values inserted: ", values)
+		}
+	}
+
+	plan := makeTestPlan(t, conn, strings.Replace(desc, "e(on,off)", "e(on)", 1))
+	if !plan.Rebuild || len(plan.Diffs) != 1 || plan.Diffs[0].Level != "lossy" || plan.Diffs[0].Rows != 1 {
+		t.Fatalf("enum change: %q %+v", diffTexts(plan), plan.Diffs)
+	}
+	if err := RunTablePlan(conn, plan, nil); err == nil {
+		t.Fatal("lossy enum change not refused")
+	}
+	runTestPlan(t, conn, strings.Replace(desc, "e(on,off)", "e(on,off,auto)", 1))
+	if r := conn.Exec(`INSERT INTO "Item" ("status") VALUES ('auto')`); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if n := conn.Query(`SELECT COUNT(*) FROM "Item"`).IntOnR1C1(); n != 4 {
+		t.Fatal("rows after rebuild: ", n)
+	}
+}
+
+// TestSqliteDeclaredTypeUpgrade 旧版本创建的 INTEGER、TEXT 字段改为新的类型名称时重建表，数据不变
+func TestSqliteDeclaredTypeUpgrade(t *testing.T) {
+	conn := newTestDB(t)
+	for _, sql := range []string{
+		`CREATE TABLE "Log" ("id" integer PRIMARY KEY AUTOINCREMENT NOT NULL, "userId" INTEGER NULL, "time" TEXT NULL, "code" TEXT(8) NULL)`,
+		`INSERT INTO "Log" ("userId", "time", "code") VALUES (1, '2024-01-02 03:04:05', '0012')`,
+	} {
+		if r := conn.Exec(sql); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+	desc := "Log\nid ubi AI\nuserId ubi\ntime dt\ncode c8\n"
+	plan := makeTestPlan(t, conn, desc)
+	if !plan.Rebuild || len(plan.Refused()) != 0 {
+		t.Fatalf("upgrade plan: %q", diffTexts(plan))
+	}
+	if err := RunTablePlan(conn, plan, nil); err != nil {
+		t.Fatal(err)
+	}
+	if plan = makeTestPlan(t, conn, desc); len(plan.Sqls) != 0 {
+		t.Fatal("plan after upgrade: ", plan.Sqls)
+	}
+	if row := conn.Query(`SELECT "userId", "time", "code" FROM "Log"`).StringMapOnR1(); row["userId"] != "1" || row["time"] != "2024-01-02 03:04:05" || row["code"] != "0012" {
+		t.Fatal("data changed: ", row)
+	}
+
+	// 可以转换为数字的字符串在修改为数字类型时会改变
+	conn.Exec(`INSERT INTO "Log" ("code") VALUES ('abc')`)
+	if level, rows := checkLossyChange(conn, "Log", "code", &TableField{Name: "code", Type: "INT"}, &TableFieldDesc{Field: "code", Type: "CHAR(8)"}, "NULL"); level != "lossy" || rows != 1 {
+		t.Fatal("text to int: ", level, rows)
+	}
+}
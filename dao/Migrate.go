@@ -0,0 +1,310 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/log"
+	"github.com/ssgo/u"
+)
+
+// MigrationTable 记录迁移历史的表，以 _ 开头不会生成DAO对象
+var MigrationTable = "_dao_migrations"
+
+// DefaultMigrationDir 默认保存迁移文件的目录
+var DefaultMigrationDir = "migrations"
+
+var migrationTableDesc = `
+%s
+id ubi AI
+name v100 I
+hash v64
+status v20
+upSqls t
+downSqls t
+message t
+time dt
+`
+
+// Migration 一条迁移记录，每次执行都会新增一条，同一个迁移以最后一次 up 或 down 的状态为准
+type Migration struct {
+	Id       int64
+	Name     string // 编号_时间，如 0001_20240101120000，对应 0001_20240101120000.up.sql 和 .down.sql
+	Hash     string // 执行时描述文件的 sha256
+	Status   string // up、down、failed，只存在文件未执行的为 pending
+	UpSqls   string
+	DownSqls string
+	Message  string
+	Time     string
+}
+
+func checkMigrationTable(conn *db.DB, logger *log.Logger) error {
+	tablesByGroup := MakeERFromDesc(conn.Config.Type, fmt.Sprintf(migrationTableDesc, MigrationTable))
+	if len(tablesByGroup) == 0 || len(tablesByGroup[0].Tables) == 0 {
+		return errors.New("failed to parse migration table desc")
+	}
+	return CheckTable(conn, tablesByGroup[0].Tables[0], logger)
+}
+
+// fixPlaceholders pgsql 使用 $1 形式的参数
+func fixPlaceholders(conn *db.DB, sql string) string {
+	if fixDBType(conn.Config.Type) != "pgsql" {
+		return sql
+	}
+	a := strings.Split(sql, "?")
+	for i := 1; i < len(a); i++ {
+		a[i] = fmt.Sprint("$", i, a[i])
+	}
+	return strings.Join(a, "")
+}
+
+func addMigration(conn *db.DB, m *Migration) error {
+	m.Time = time.Now().Format("2006-01-02 15:04:05")
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?,?,?,?,?,?,?)", conn.Quote(MigrationTable), conn.Quotes([]string{"name", "hash", "status", "upSqls", "downSqls", "message", "time"}))
+	r := conn.Exec(fixPlaceholders(conn, sql), m.Name, m.Hash, m.Status, m.UpSqls, m.DownSqls, m.Message, m.Time)
+	return r.Error
+}
+
+// SplitSqlScript 将SQL脚本拆分为单独的语句，忽略注释行，pgsql 的 $$ 函数体作为一个整体
+func SplitSqlScript(script string) []string {
+	sqls := make([]string, 0)
+	lines := make([]string, 0)
+	inBody := false
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(lines) == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.Count(line, "$$")%2 == 1 {
+			inBody = !inBody
+		}
+		if !inBody && strings.HasSuffix(trimmed, ";") {
+			sql := strings.TrimSpace(strings.Join(lines, "\n"))
+			sqls = append(sqls, strings.TrimSuffix(sql, ";"))
+			lines = make([]string, 0)
+		}
+	}
+	if len(lines) > 0 {
+		sqls = append(sqls, strings.TrimSpace(strings.Join(lines, "\n")))
+	}
+	return sqls
+}
+
+func runMigrationSqls(conn *db.DB, sqls []string, logger *log.Logger) error {
+	// sqlite3 重建表的SQL中包含 BEGIN、COMMIT，在同一个连接中执行
+	err := runSqlsOnConn(conn, sqls)
+	if logger != nil {
+		logger.Info("run migration sql", "sql", strings.Join(sqls, ";\n"), "size", len(sqls))
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	return err
+}
+
+// getMigrationFiles 返回目录中所有迁移的名称（按编号排序）
+func getMigrationFiles(dir string) []string {
+	names := make([]string, 0)
+	for _, file := range u.ReadDirN(dir) {
+		if !file.IsDir && strings.HasSuffix(file.Name, ".up.sql") {
+			names = append(names, strings.TrimSuffix(file.Name, ".up.sql"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetMigrations 返回迁移历史（按执行顺序），以及目录中还未执行的迁移（Status 为 pending）
+func GetMigrations(conn *db.DB, dir string, logger *log.Logger) ([]*Migration, error) {
+	if err := checkMigrationTable(conn, logger); err != nil {
+		return nil, err
+	}
+	history := make([]*Migration, 0)
+	r := conn.Query("SELECT * FROM " + conn.Quote(MigrationTable) + " ORDER BY " + conn.Quote("id"))
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	_ = r.To(&history)
+
+	states := getMigrationStates(history)
+	for _, name := range getMigrationFiles(dir) {
+		if states[name] != "up" {
+			history = append(history, &Migration{Name: name, Status: "pending"})
+		}
+	}
+	return history, nil
+}
+
+// getMigrationStates 每个迁移当前的状态，失败的执行不改变状态
+func getMigrationStates(history []*Migration) map[string]string {
+	states := map[string]string{}
+	for _, m := range history {
+		if m.Status == "up" || m.Status == "down" {
+			states[m.Name] = m.Status
+		}
+	}
+	return states
+}
+
+// MigrateUp 先执行目录中未执行的迁移文件，再对比描述文件生成新的迁移（写入 up/down 文件）并执行，返回本次执行的迁移
+func MigrateUp(conn *db.DB, dir, desc string, logger *log.Logger) ([]*Migration, error) {
+	history, err := GetMigrations(conn, dir, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]*Migration, 0)
+	lastNum := 0
+	for _, m := range history {
+		if num := u.Int(strings.SplitN(m.Name, "_", 2)[0]); num > lastNum {
+			lastNum = num
+		}
+	}
+	for _, m := range history {
+		if m.Status != "pending" {
+			continue
+		}
+		m.UpSqls = u.ReadFileN(filepath.Join(dir, m.Name+".up.sql"))
+		m.DownSqls = u.ReadFileN(filepath.Join(dir, m.Name+".down.sql"))
+		m.Status = "up"
+		if err := runMigrationSqls(conn, SplitSqlScript(m.UpSqls), logger); err != nil {
+			m.Status = "failed"
+			m.Message = err.Error()
+		}
+		if err2 := addMigration(conn, m); err2 != nil {
+			return applied, err2
+		}
+		applied = append(applied, m)
+		if m.Status == "failed" {
+			return applied, errors.New("failed to migrate " + m.Name + ": " + m.Message)
+		}
+	}
+
+	if desc == "" {
+		return applied, nil
+	}
+	plans, err := MakePlanFromDesc(conn, desc, logger)
+	if err != nil || len(plans) == 0 {
+		return applied, err
+	}
+	if !AllowDrop {
+		for _, plan := range plans {
+			if !plan.AllowDrop && len(plan.Refused()) > 0 {
+				return applied, errors.New("refused to change table " + plan.Name + ": " + plan.Refused()[0].String() + ", use --allow-drop or mark the table with allowDrop")
+			}
+		}
+	}
+
+	// 执行前读取将要修改的表的实际结构，回滚时只恢复这些表，新创建的表直接删除
+	messages := make([]string, 0)
+	oldTables := make([]*TableStruct, 0)
+	for _, plan := range plans {
+		if plan.IsNew {
+			continue
+		}
+		table, err := getTableStruct(conn, plan.Name)
+		if err != nil {
+			messages = append(messages, "failed to make down sql for "+plan.Name+": "+err.Error())
+			continue
+		}
+		addRenameHints(table, plan)
+		oldTables = append(oldTables, table)
+	}
+
+	m := &Migration{
+		Name:   fmt.Sprintf("%04d_%s", lastNum+1, time.Now().Format("20060102150405")),
+		Hash:   u.Sha256String(desc),
+		Status: "up",
+		UpSqls: MakePlanSql(plans),
+	}
+	sqls := make([]string, 0)
+	for _, plan := range plans {
+		sqls = append(sqls, plan.Sqls...)
+	}
+	if err := runMigrationSqls(conn, sqls, logger); err != nil {
+		m.Status = "failed"
+		m.Message = err.Error()
+		_ = addMigration(conn, m)
+		return append(applied, m), err
+	}
+
+	downPlans := make([]*TablePlan, 0)
+	for _, table := range oldTables {
+		plan, err := MakeTablePlan(conn, table)
+		if err != nil {
+			messages = append(messages, "failed to make down sql for "+table.Name+": "+err.Error())
+			continue
+		}
+		downPlans = append(downPlans, plan)
+	}
+	m.Message = strings.Join(messages, "\n")
+	m.DownSqls = MakePlanSql(downPlans)
+	// 新创建的表在回滚时删除，按创建的相反顺序
+	for i := len(plans) - 1; i >= 0; i-- {
+		if plans[i].IsNew {
+			m.DownSqls += fmt.Sprint("-- ", plans[i].Name, " (drop)\nDROP TABLE ", conn.Quote(plans[i].Name), ";\n\n")
+		}
+	}
+
+	if err := u.WriteFile(filepath.Join(dir, m.Name+".up.sql"), m.UpSqls); err != nil {
+		m.Message = err.Error()
+	}
+	if err := u.WriteFile(filepath.Join(dir, m.Name+".down.sql"), m.DownSqls); err != nil {
+		m.Message = err.Error()
+	}
+	if err := addMigration(conn, m); err != nil {
+		return append(applied, m), err
+	}
+	return append(applied, m), nil
+}
+
+// addRenameHints 为回滚的表结构加上反向的重命名，回滚时字段改回原来的名字而不是删除后重新创建
+func addRenameHints(table *TableStruct, plan *TablePlan) {
+	for _, diff := range plan.Diffs {
+		if diff.Type != "field" || diff.Action != "rename" {
+			continue
+		}
+		for i := range table.Fields {
+			if table.Fields[i].Name == diff.Old {
+				table.Fields[i].OldName = diff.New
+			}
+		}
+	}
+}
+
+// MigrateDown 回滚最后一个已执行的迁移，优先使用目录中的 down 文件
+func MigrateDown(conn *db.DB, dir string, logger *log.Logger) (*Migration, error) {
+	history, err := GetMigrations(conn, dir, logger)
+	if err != nil {
+		return nil, err
+	}
+	states := getMigrationStates(history)
+	var last *Migration
+	for _, m := range history {
+		if m.Status == "up" && states[m.Name] == "up" {
+			last = m
+		}
+	}
+	if last == nil {
+		return nil, errors.New("no migration to rollback")
+	}
+
+	m := &Migration{Name: last.Name, Hash: last.Hash, Status: "down", UpSqls: last.UpSqls, DownSqls: last.DownSqls}
+	downFile := filepath.Join(dir, m.Name+".down.sql")
+	if u.FileExists(downFile) {
+		m.DownSqls = u.ReadFileN(downFile)
+	}
+	if err := runMigrationSqls(conn, SplitSqlScript(m.DownSqls), logger); err != nil {
+		m.Status = "failed"
+		m.Message = err.Error()
+		_ = addMigration(conn, m)
+		return m, err
+	}
+	return m, addMigration(conn, m)
+}
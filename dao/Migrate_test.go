@@ -0,0 +1,184 @@
+package dao
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/u"
+)
+
+const testLegacyTable = `CREATE TABLE legacy (id INTEGER PRIMARY KEY, x TEXT DEFAULT 'a', y REAL NOT NULL DEFAULT 1.5)`
+
+// dumpSqliteTable 字段和索引的实际结构，默认值去掉引号比较，索引按名称排序
+func dumpSqliteTable(conn *db.DB, table string) string {
+	out := make([]string, 0)
+	for _, c := range getSqliteColumns(conn, table) {
+		out = append(out, fmt.Sprint(c.Name, " ", strings.ToUpper(c.Type), " ", c.Notnull, " ", strings.Trim(u.String(c.Dflt_value), "'"), " ", c.Pk))
+	}
+	indexes := make([]string, 0)
+	seq := map[string]int{}
+	for _, i := range getSqliteIndexes(conn, table) {
+		seq[i.Key_name]++
+		indexes = append(indexes, fmt.Sprint(i.Key_name, " ", seq[i.Key_name], " ", i.Column_name, " ", i.Unique, " ", i.Desc))
+	}
+	sort.Strings(indexes)
+	return strings.Join(append(out, indexes...), "\n")
+}
+
+func checkMigrationStates(t *testing.T, conn *db.DB, dir string, expected ...string) {
+	t.Helper()
+	migrations, err := GetMigrations(conn, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make([]string, 0)
+	for _, m := range migrations {
+		states = append(states, m.Name[0:4]+" "+m.Status)
+	}
+	if strings.Join(states, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("migrations: %q, expected: %q", states, expected)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	conn := newTestDB(t)
+	dir := t.TempDir()
+	for _, sql := range []string{
+		testLegacyTable,
+		`CREATE TABLE "User" ("id" INTEGER PRIMARY KEY, "name" TEXT DEFAULT 'a', "score" REAL NOT NULL DEFAULT 1.5)`,
+		`CREATE INDEX "ik_User_name" ON "User" ("name")`,
+		`INSERT INTO "User" ("id", "name") VALUES (1, 'Tom')`,
+	} {
+		if r := conn.Exec(sql); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+	oldUser := dumpSqliteTable(conn, "User")
+	oldLegacy := conn.Query(`SELECT "sql" FROM "sqlite_master" WHERE "name"='legacy'`).StringOnR1C1()
+
+	desc := "User\nid ubi AI\nfullName v50 <name I\nscore ff nn\nage i\n\nDevice\nid ubi AI\nuserId ubi I\n"
+	applied, err := MigrateUp(conn, dir, desc, nil)
+	if err != nil {
+		t.Fatal("migrate up failed: ", err)
+	}
+	if len(applied) != 1 || applied[0].Status != "up" || applied[0].Message != "" {
+		t.Fatalf("applied: %+v", applied)
+	}
+	m := applied[0]
+	if !u.FileExists(filepath.Join(dir, m.Name+".up.sql")) || u.ReadFileN(filepath.Join(dir, m.Name+".down.sql")) != m.DownSqls {
+		t.Fatal("migration files not written: ", m.Name)
+	}
+	// 回滚只涉及本次修改的表
+	if strings.Contains(m.DownSqls, "legacy") || !strings.Contains(m.DownSqls, `DROP TABLE "Device"`) {
+		t.Fatal("down sql: ", m.DownSqls)
+	}
+	if conn.Query(`SELECT "fullName" FROM "User" WHERE "id"=1`).StringOnR1C1() != "Tom" {
+		t.Fatal("renamed field lost data")
+	}
+	checkMigrationStates(t, conn, dir, "0001 up")
+
+	// 再次执行没有变化
+	applied, err = MigrateUp(conn, dir, desc, nil)
+	if err != nil || len(applied) != 0 {
+		t.Fatal("migrate up again: ", applied, err)
+	}
+
+	m, err = MigrateDown(conn, dir, nil)
+	if err != nil || m.Status != "down" {
+		t.Fatal("migrate down failed: ", m, err)
+	}
+	if dump := dumpSqliteTable(conn, "User"); dump != oldUser {
+		t.Fatalf("User after down:\n%s\nexpected:\n%s", dump, oldUser)
+	}
+	if conn.Query(`SELECT "sql" FROM "sqlite_master" WHERE "name"='legacy'`).StringOnR1C1() != oldLegacy {
+		t.Fatal("legacy table changed")
+	}
+	if tables, _ := GetTables(conn); u.StringIn(tables, "Device") {
+		t.Fatal("new table not dropped: ", tables)
+	}
+	if conn.Query(`SELECT "name" FROM "User" WHERE "id"=1`).StringOnR1C1() != "Tom" {
+		t.Fatal("rollback lost data")
+	}
+	// 默认值恢复后可以只插入 id
+	for _, table := range []string{"User", "legacy"} {
+		if r := conn.Exec(`INSERT INTO "` + table + `" ("id") VALUES (2)`); r.Error != nil {
+			t.Fatal(table, r.Error)
+		}
+	}
+	if conn.Query(`SELECT "score" FROM "User" WHERE "id"=2`).FloatOnR1C1() != 1.5 {
+		t.Fatal("default value not restored")
+	}
+	checkMigrationStates(t, conn, dir, "0001 up", "0001 down", "0001 pending")
+
+	// 回滚后再次执行使用目录中的迁移文件
+	applied, err = MigrateUp(conn, dir, "", nil)
+	if err != nil || len(applied) != 1 || applied[0].Name != m.Name || applied[0].Status != "up" {
+		t.Fatal("migrate up from file: ", applied, err)
+	}
+	checkMigrationStates(t, conn, dir, "0001 up", "0001 down", "0001 up")
+
+	if _, err = MigrateDown(conn, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MigrateDown(conn, dir, nil); err == nil {
+		t.Fatal("rollback without migration")
+	}
+}
+
+func TestMigrateFailed(t *testing.T) {
+	conn := newTestDB(t)
+	dir := t.TempDir()
+	_ = u.WriteFile(filepath.Join(dir, "0001_20240101000000.up.sql"), "CREATE TABLE \"Ok\" (\"id\" INTEGER);\n")
+	_ = u.WriteFile(filepath.Join(dir, "0002_20240101000000.up.sql"), "CREATE TABLE \"Broken\" (;\n")
+	checkMigrationStates(t, conn, dir, "0001 pending", "0002 pending")
+
+	applied, err := MigrateUp(conn, dir, "", nil)
+	if err == nil || len(applied) != 2 || applied[1].Status != "failed" || applied[1].Message == "" {
+		t.Fatal("failed migration: ", applied, err)
+	}
+	// 失败的执行不改变状态，修复后可以再次执行
+	checkMigrationStates(t, conn, dir, "0001 up", "0002 failed", "0002 pending")
+	_ = u.WriteFile(filepath.Join(dir, "0002_20240101000000.up.sql"), "CREATE TABLE \"Broken\" (\"id\" INTEGER);\n")
+	if _, err = MigrateUp(conn, dir, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrationStates(t, conn, dir, "0001 up", "0002 failed", "0002 up")
+}
+
+func TestSplitSqlScript(t *testing.T) {
+	sqls := SplitSqlScript("-- User\nCREATE TABLE a (id int);\n\nCREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  NEW.x = 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\nDROP TABLE b")
+	if len(sqls) != 3 || !strings.HasSuffix(sqls[1], "LANGUAGE plpgsql") || sqls[2] != "DROP TABLE b" {
+		t.Fatalf("split: %q", sqls)
+	}
+}
+
+// TestMigrateDownSqliteDesc 回滚时恢复描述文件创建的表：自增字段和主键的唯一索引、CHECK 约束
+func TestMigrateDownSqliteDesc(t *testing.T) {
+	conn := newTestDB(t)
+	dir := t.TempDir()
+	if err := MakeDBFromDesc(conn, "Item\nid ubi AI\nstatus e(on,off) nn\nname v20\n\nCode\ncode c8 PK\nname v20\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	oldItem, oldCode := dumpSqliteTable(conn, "Item"), dumpSqliteTable(conn, "Code")
+	oldChecks := getSqliteChecks(conn.Query(`SELECT "sql" FROM "sqlite_master" WHERE "name"='Item'`).StringOnR1C1())
+
+	if _, err := MigrateUp(conn, dir, "Item\nid ubi AI\nstatus e(on,off,auto) nn\nname v50\n\nCode\ncode c8 PK\nname v50\n", nil); err != nil {
+		t.Fatal("migrate up failed: ", err)
+	}
+	if _, err := MigrateDown(conn, dir, nil); err != nil {
+		t.Fatal("migrate down failed: ", err)
+	}
+	if dump := dumpSqliteTable(conn, "Item"); dump != oldItem {
+		t.Fatalf("Item after down:\n%s\nexpected:\n%s", dump, oldItem)
+	}
+	if dump := dumpSqliteTable(conn, "Code"); dump != oldCode {
+		t.Fatalf("Code after down:\n%s\nexpected:\n%s", dump, oldCode)
+	}
+	if checks := getSqliteChecks(conn.Query(`SELECT "sql" FROM "sqlite_master" WHERE "name"='Item'`).StringOnR1C1()); checks["status"] != oldChecks["status"] {
+		t.Fatal("check after down: ", checks)
+	}
+}
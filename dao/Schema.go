@@ -0,0 +1,620 @@
+package dao
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/u"
+)
+
+// TableSchema 从数据库中读取的表结构，字段类型统一转换为 mysql 的写法，用于生成代码
+type TableSchema struct {
+	Name    string
+	Comment string
+	Fields  []TableDesc
+	Indexes []TableIndex
+	Refs    []TableRef
+	Options TableOptions
+}
+
+// TableOptions mysql 的表选项，Collation 为表的默认排序规则
+type TableOptions struct {
+	Engine        string
+	Collation     string
+	RowFormat     string // 创建时指定的行格式，未指定时为空
+	Partition     string // PARTITION BY 之后的部分，如 HASH (userId) PARTITIONS 8
+	AutoIncrement int
+	Columns       map[string]string // 字段的排序规则
+}
+
+// TableRef 外键引用，Name 为约束名称
+type TableRef struct {
+	Name     string
+	Field    string
+	RefTable string
+	RefField string
+}
+
+type sqliteColumn struct {
+	Cid        int
+	Name       string
+	Type       string
+	Notnull    bool
+	Dflt_value any
+	Pk         int
+}
+
+type sqliteIndex struct {
+	Key_name    string
+	Column_name string
+	Unique      bool
+	Origin      string
+	Desc        bool
+}
+
+type pgsqlColumn struct {
+	Field    string
+	Type     string
+	Nullable bool
+	Dflt     *string
+	Identity string
+	Comment  *string
+}
+
+type pgsqlIndex struct {
+	Key_name    string
+	Column_name string
+	Is_primary  bool
+	Is_unique   bool
+	Is_desc     bool
+}
+
+func getSqliteColumns(conn *db.DB, table string) []sqliteColumn {
+	columns := make([]sqliteColumn, 0)
+	_ = conn.Query("PRAGMA table_info(" + conn.Quote(table) + ")").To(&columns)
+	return columns
+}
+
+// getSqliteIndexes 读取索引信息，复合索引每个字段一条记录
+func getSqliteIndexes(conn *db.DB, table string) []sqliteIndex {
+	tmpIndexes := []struct {
+		Name   string
+		Unique bool
+		Origin string
+	}{}
+	_ = conn.Query("PRAGMA index_list(" + conn.Quote(table) + ")").To(&tmpIndexes)
+
+	indexes := make([]sqliteIndex, 0)
+	// PRAGMA index_list 按创建时间倒序返回
+	for i := len(tmpIndexes) - 1; i >= 0; i-- {
+		tmpIndexInfo := []struct {
+			Seqno int
+			Cid   int
+			Name  string
+			Desc  bool
+			Key   bool
+		}{}
+		// index_xinfo 包含排序方向，key 为 false 的是索引附带的 rowid
+		_ = conn.Query("PRAGMA index_xinfo(" + conn.Quote(tmpIndexes[i].Name) + ")").To(&tmpIndexInfo)
+		for _, info := range tmpIndexInfo {
+			if !info.Key {
+				continue
+			}
+			indexes = append(indexes, sqliteIndex{
+				Key_name:    tmpIndexes[i].Name,
+				Column_name: info.Name,
+				Unique:      tmpIndexes[i].Unique,
+				Origin:      tmpIndexes[i].Origin,
+				Desc:        info.Desc,
+			})
+		}
+	}
+	return indexes
+}
+
+func getPgsqlColumns(conn *db.DB, table string) []pgsqlColumn {
+	columns := make([]pgsqlColumn, 0)
+	_ = conn.Query("SELECT a.attname AS field, format_type(a.atttypid, a.atttypmod) AS type, NOT a.attnotnull AS nullable, pg_get_expr(d.adbin, d.adrelid) AS dflt, a.attidentity::text AS identity, col_description(a.attrelid, a.attnum) AS comment FROM pg_attribute a LEFT JOIN pg_attrdef d ON d.adrelid=a.attrelid AND d.adnum=a.attnum WHERE a.attrelid='" + conn.Quote(table) + "'::regclass AND a.attnum>0 AND NOT a.attisdropped ORDER BY a.attnum").To(&columns)
+	return columns
+}
+
+// getPgsqlIndexes 读取索引信息，复合索引每个字段一条记录，表达式索引（如全文索引）取出其中的字段名
+func getPgsqlIndexes(conn *db.DB, table string) []pgsqlIndex {
+	indexes := make([]pgsqlIndex, 0)
+	_ = conn.Query("SELECT i.relname AS key_name, pg_get_indexdef(ix.indexrelid, k.n::int, true) AS column_name, ix.indisprimary AS is_primary, ix.indisunique AS is_unique, (ix.indoption[k.n::int-1]::int & 1)=1 AS is_desc FROM pg_index ix JOIN pg_class i ON i.oid=ix.indexrelid, unnest(ix.indkey) WITH ORDINALITY AS k(attnum, n) WHERE ix.indrelid='" + conn.Quote(table) + "'::regclass ORDER BY i.relname, k.n").To(&indexes)
+	for i := range indexes {
+		indexes[i].Column_name = fixPgsqlIndexColumn(indexes[i].Column_name)
+	}
+	return indexes
+}
+
+var rowFormatMatcher = regexp.MustCompile(`(?i)row_format=(\w+)`)
+var partitionEngineMatcher = regexp.MustCompile(`(?i)\s*(STORAGE\s+)?ENGINE\s*=\s*\w+`)
+var spacesMatcher = regexp.MustCompile(`\s+`)
+
+// getMysqlTableOptions 读取表的引擎、排序规则、行格式、分区和字段的排序规则
+func getMysqlTableOptions(conn *db.DB, table string) TableOptions {
+	tableInfo := struct {
+		Engine        string
+		Collation     string
+		CreateOptions string
+		AutoIncrement int
+	}{}
+	_ = conn.Query("SELECT ENGINE engine, TABLE_COLLATION collation, CREATE_OPTIONS createOptions, AUTO_INCREMENT autoIncrement FROM information_schema.TABLES WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table + "'").To(&tableInfo)
+	options := TableOptions{
+		Engine:        tableInfo.Engine,
+		Collation:     tableInfo.Collation,
+		AutoIncrement: tableInfo.AutoIncrement,
+		Columns:       map[string]string{},
+	}
+	if m := rowFormatMatcher.FindStringSubmatch(tableInfo.CreateOptions); m != nil {
+		options.RowFormat = strings.ToUpper(m[1])
+	}
+	if strings.Contains(strings.ToLower(tableInfo.CreateOptions), "partitioned") {
+		rows := conn.Query("SHOW CREATE TABLE " + conn.Quote(table)).StringSliceResults()
+		if len(rows) > 0 && len(rows[0]) > 1 {
+			ddl := rows[0][1]
+			if pos := strings.Index(ddl, "PARTITION BY "); pos >= 0 {
+				options.Partition = fixMysqlPartition(ddl[pos+13:])
+			}
+		}
+	}
+	_ = conn.Query("SELECT column_name, collation_name FROM information_schema.columns WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table + "' AND collation_name IS NOT NULL").ToKV(&options.Columns)
+	return options
+}
+
+// fixMysqlPartition 去掉 SHOW CREATE TABLE 中分区定义的版本注释、引号和引擎
+func fixMysqlPartition(partition string) string {
+	partition = strings.NewReplacer("/*!50100 ", "", "/*!50500 ", "", "*/", "", "`", "").Replace(partition)
+	partition = partitionEngineMatcher.ReplaceAllString(partition, "")
+	return strings.TrimSpace(spacesMatcher.ReplaceAllString(partition, " "))
+}
+
+// samePartition 忽略大小写和空白比较分区定义
+func samePartition(a, b string) bool {
+	return strings.ToLower(spacesMatcher.ReplaceAllString(fixMysqlPartition(a), "")) == strings.ToLower(spacesMatcher.ReplaceAllString(fixMysqlPartition(b), ""))
+}
+
+// getForeignKeys 读取表的外键，只支持单字段外键
+func getForeignKeys(conn *db.DB, table string) []TableRef {
+	refs := make([]TableRef, 0)
+	switch fixDBType(conn.Config.Type) {
+	case "sqlite":
+		tmpRefs := []struct {
+			Table string
+			From  string
+			To    string
+		}{}
+		_ = conn.Query("PRAGMA foreign_key_list(" + conn.Quote(table) + ")").To(&tmpRefs)
+		for _, ref := range tmpRefs {
+			// sqlite 中的外键没有名称
+			refs = append(refs, TableRef{Name: "fk_" + table + "_" + ref.From, Field: ref.From, RefTable: ref.Table, RefField: ref.To})
+		}
+	case "pgsql":
+		_ = conn.Query("SELECT c.conname AS \"name\", a.attname AS \"field\", rt.relname AS \"refTable\", ra.attname AS \"refField\" FROM pg_constraint c JOIN pg_attribute a ON a.attrelid=c.conrelid AND a.attnum=c.conkey[1] JOIN pg_class rt ON rt.oid=c.confrelid JOIN pg_attribute ra ON ra.attrelid=c.confrelid AND ra.attnum=c.confkey[1] WHERE c.conrelid='" + conn.Quote(table) + "'::regclass AND c.contype='f' ORDER BY c.conname").To(&refs)
+	default:
+		_ = conn.Query("SELECT CONSTRAINT_NAME AS name, COLUMN_NAME AS field, REFERENCED_TABLE_NAME AS refTable, REFERENCED_COLUMN_NAME AS refField FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table + "' AND REFERENCED_TABLE_NAME IS NOT NULL ORDER BY CONSTRAINT_NAME").To(&refs)
+	}
+	return refs
+}
+
+// GetTables 读取数据库中所有的表名
+func GetTables(conn *db.DB) ([]string, error) {
+	var r *db.QueryResult
+	switch fixDBType(conn.Config.Type) {
+	case "sqlite":
+		r = conn.Query("SELECT \"name\" FROM \"sqlite_master\" WHERE \"type\"='table' AND \"name\" NOT LIKE 'sqlite_%' ORDER BY \"name\"")
+	case "pgsql":
+		r = conn.Query("SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid=c.relnamespace WHERE n.nspname=current_schema() AND c.relkind IN ('r','p') ORDER BY c.relname")
+	default:
+		r = conn.Query("SHOW TABLES")
+	}
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	return r.StringsOnC1(), nil
+}
+
+// GetTableSchema 读取表的字段和索引信息
+func GetTableSchema(conn *db.DB, table string) (*TableSchema, error) {
+	schema := &TableSchema{
+		Name:    table,
+		Fields:  make([]TableDesc, 0),
+		Indexes: make([]TableIndex, 0),
+	}
+
+	switch fixDBType(conn.Config.Type) {
+	case "sqlite":
+		pks := make([]TableIndex, 0)
+		checks := getSqliteChecks(conn.Query("SELECT \"sql\" FROM \"sqlite_master\" WHERE \"type\"='table' AND \"name\"='" + table + "'").StringOnR1C1())
+		for _, column := range getSqliteColumns(conn, table) {
+			desc := TableDesc{
+				Field: column.Name,
+				Type:  u.StringIf(checks[column.Name] != "", checks[column.Name], fixSqliteType(column.Type)),
+				Null:  u.StringIf(column.Notnull || column.Pk > 0, "NO", "YES"),
+				Key:   u.StringIf(column.Pk > 0, "PRI", ""),
+			}
+			if column.Dflt_value != nil {
+				dflt := strings.Trim(u.String(column.Dflt_value), "'")
+				desc.Default = &dflt
+				if strings.Contains(strings.ToUpper(dflt), "CURRENT_TIMESTAMP") {
+					desc.Extra = "DEFAULT_GENERATED"
+				}
+			}
+			if column.Pk > 0 {
+				pks = append(pks, TableIndex{Key_name: "PRIMARY", Seq_in_index: column.Pk, Column_name: column.Name})
+			}
+			schema.Fields = append(schema.Fields, desc)
+		}
+		// INTEGER PRIMARY KEY 为 rowid 的别名，自动增长
+		autoIdField := ""
+		if len(pks) == 1 {
+			for i := range schema.Fields {
+				if schema.Fields[i].Field == pks[0].Column_name && strings.Contains(schema.Fields[i].Type, "int") {
+					schema.Fields[i].Extra = "auto_increment"
+					autoIdField = schema.Fields[i].Field
+					if schema.Fields[i].Type == "bigint" {
+						// 自增字段只能声明为 INTEGER，按描述文件中的 ubi AI 读取
+						schema.Fields[i].Type = "bigint unsigned"
+					}
+				}
+			}
+		}
+		for i := 1; i <= len(pks); i++ {
+			for _, pk := range pks {
+				if pk.Seq_in_index == i {
+					schema.Indexes = append(schema.Indexes, pk)
+				}
+			}
+		}
+		seq := map[string]int{}
+		for _, index := range getSqliteIndexes(conn, table) {
+			if index.Origin == "pk" || (autoIdField != "" && index.Key_name == "uk_"+table+"_"+autoIdField) {
+				// 主键已经通过 table_info 读取，描述文件中的自增字段还会创建一个相同的唯一索引
+				continue
+			}
+			keyName := index.Key_name
+			if keyName == "uk_"+table+"_"+sqlitePrimaryGroup {
+				// 描述文件中的主键（PK）在 sqlite 中为唯一索引
+				keyName = "PRIMARY"
+			}
+			seq[keyName]++
+			nonUnique := 1
+			if index.Unique {
+				nonUnique = 0
+			}
+			schema.Indexes = append(schema.Indexes, TableIndex{
+				Non_unique:   nonUnique,
+				Key_name:     keyName,
+				Seq_in_index: seq[keyName],
+				Column_name:  index.Column_name,
+				Collation:    u.StringIf(index.Desc, "D", "A"),
+			})
+		}
+
+	case "pgsql":
+		schema.Comment = conn.Query("SELECT obj_description('" + conn.Quote(table) + "'::regclass, 'pg_class')").StringOnR1C1()
+		onUpdateFields := map[string]bool{}
+		for _, triggerName := range conn.Query("SELECT tgname FROM pg_trigger WHERE tgrelid='" + conn.Quote(table) + "'::regclass AND NOT tgisinternal").StringsOnC1() {
+			if strings.HasPrefix(triggerName, "tu_"+table+"_") {
+				onUpdateFields[triggerName[len(table)+4:]] = true
+			}
+		}
+		for _, column := range getPgsqlColumns(conn, table) {
+			desc := TableDesc{
+				Field: column.Field,
+				Type:  fixPgsqlTypeForDao(fixPgsqlType(column.Type)),
+				Null:  u.StringIf(column.Nullable, "YES", "NO"),
+			}
+			if column.Comment != nil {
+				desc.Comment = *column.Comment
+			}
+			if column.Identity != "" {
+				desc.Extra = "auto_increment"
+			}
+			if column.Dflt != nil {
+				dflt := fixPgsqlDefault(*column.Dflt)
+				desc.Default = &dflt
+				if strings.Contains(strings.ToUpper(dflt), "CURRENT_TIMESTAMP") || strings.HasPrefix(dflt, "now()") {
+					desc.Extra = "DEFAULT_GENERATED" + u.StringIf(onUpdateFields[column.Field], " on update CURRENT_TIMESTAMP", "")
+				}
+			}
+			schema.Fields = append(schema.Fields, desc)
+		}
+		seq := map[string]int{}
+		for _, index := range getPgsqlIndexes(conn, table) {
+			keyName := index.Key_name
+			if index.Is_primary {
+				keyName = "PRIMARY"
+			}
+			seq[keyName]++
+			nonUnique := 1
+			if index.Is_unique {
+				nonUnique = 0
+			}
+			schema.Indexes = append(schema.Indexes, TableIndex{
+				Non_unique:   nonUnique,
+				Key_name:     keyName,
+				Seq_in_index: seq[keyName],
+				Column_name:  index.Column_name,
+				Collation:    u.StringIf(index.Is_desc, "D", "A"),
+			})
+		}
+
+	default:
+		schema.Comment = conn.Query("SELECT TABLE_COMMENT FROM information_schema.TABLES WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table + "'").StringOnR1C1()
+		r := conn.Query("DESC " + conn.Quote(table))
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		_ = r.To(&schema.Fields)
+		_ = conn.Query("SHOW INDEX FROM " + conn.Quote(table)).To(&schema.Indexes)
+		comments := map[string]string{}
+		_ = conn.Query("SELECT column_name, column_comment FROM information_schema.columns WHERE TABLE_SCHEMA='" + conn.Config.DB + "' AND TABLE_NAME='" + table + "'").ToKV(&comments)
+		for i := range schema.Fields {
+			schema.Fields[i].Comment = comments[schema.Fields[i].Field]
+		}
+		schema.Options = getMysqlTableOptions(conn, table)
+	}
+
+	if len(schema.Fields) == 0 {
+		return nil, errors.New("table not exists: " + table)
+	}
+	schema.Refs = getForeignKeys(conn, table)
+	return schema, nil
+}
+
+// fixSqliteType sqlite 中按声明的类型保存，只需要转换 sqlite 特有的类型名称
+func fixSqliteType(typ string) string {
+	typ = strings.ToLower(typ)
+	switch typ {
+	case "integer":
+		return "bigint"
+	case "real":
+		return "double"
+	}
+	return typ
+}
+
+var sqliteJsonCheckMatcher = regexp.MustCompile(`CHECK\(json_valid\("([^"]+)"\)\)`)
+var sqliteEnumCheckMatcher = regexp.MustCompile(`CHECK\("([^"]+)" IN (\((?:'(?:[^']|'')*',?)+\))\)`)
+var sqliteSetCheckMatcher = regexp.MustCompile(`CHECK\("([^"]+)" = '' OR (?:replace\()+','\|\|replace\("[^"]+", ',', ',,'\)\|\|','((?:, ',(?:[^']|'')*,', ''\))+) = ''\)`)
+var sqliteSetValueMatcher = regexp.MustCompile(`',((?:[^']|'')*),'`)
+var sqliteQuotedValueMatcher = regexp.MustCompile(`'((?:[^']|'')*)'`)
+
+// makeSqliteCheck sqlite 中 json、enum、set 字段的 CHECK 约束，检查内容的同时保存生成代码使用的类型
+// set 的每个值前后都加上逗号后逐个删除，剩余空字符串时所有值都有效
+func makeSqliteCheck(name, daoType string) string {
+	column := "\"" + name + "\""
+	switch {
+	case daoType == "json":
+		return "json_valid(" + column + ")"
+	case strings.HasPrefix(daoType, "enum("):
+		return column + " IN " + daoType[4:]
+	case strings.HasPrefix(daoType, "set("):
+		expr := "','||replace(" + column + ", ',', ',,')||','"
+		for _, m := range sqliteQuotedValueMatcher.FindAllStringSubmatch(daoType[3:], -1) {
+			expr = "replace(" + expr + ", '," + m[1] + ",', '')"
+		}
+		return column + " = '' OR " + expr + " = ''"
+	}
+	return ""
+}
+
+// getSqliteChecks 从建表语句中读取 makeSqliteCheck 生成的约束，返回字段对应的类型
+func getSqliteChecks(ddl string) map[string]string {
+	checks := map[string]string{}
+	for _, m := range sqliteJsonCheckMatcher.FindAllStringSubmatch(ddl, -1) {
+		checks[m[1]] = "json"
+	}
+	for _, m := range sqliteEnumCheckMatcher.FindAllStringSubmatch(ddl, -1) {
+		checks[m[1]] = "enum" + m[2]
+	}
+	for _, m := range sqliteSetCheckMatcher.FindAllStringSubmatch(ddl, -1) {
+		values := make([]string, 0)
+		for _, vm := range sqliteSetValueMatcher.FindAllStringSubmatch(m[2], -1) {
+			values = append(values, "'"+vm[1]+"'")
+		}
+		checks[m[1]] = "set(" + strings.Join(values, ",") + ")"
+	}
+	return checks
+}
+
+// sqliteAffinity 按 sqlite 的规则由声明的类型得到亲和类型，重建表复制数据时按亲和类型转换
+func sqliteAffinity(typ string) string {
+	typ = strings.ToUpper(typ)
+	switch {
+	case strings.Contains(typ, "INT"):
+		return "INTEGER"
+	case strings.Contains(typ, "CHAR") || strings.Contains(typ, "CLOB") || strings.Contains(typ, "TEXT"):
+		return "TEXT"
+	case strings.Contains(typ, "BLOB") || typ == "":
+		return "BLOB"
+	case strings.Contains(typ, "REAL") || strings.Contains(typ, "FLOA") || strings.Contains(typ, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
+// fixPgsqlTypeForDao 将 pgsql 的类型转换为 mysql 的写法，与 typeMapping["pgsql"] 对应
+func fixPgsqlTypeForDao(typ string) string {
+	switch {
+	case strings.HasPrefix(typ, "timestamp"):
+		return "datetime"
+	case strings.HasPrefix(typ, "time"):
+		return "time"
+	case typ == "integer":
+		return "int"
+	case typ == "numeric(20)":
+		return "bigint unsigned"
+	case typ == "real":
+		return "float"
+	case typ == "double precision":
+		return "double"
+	case typ == "boolean":
+		return "tinyint unsigned"
+	case typ == "bytea":
+		return "blob"
+	case typ == "jsonb":
+		return "json"
+	case strings.HasPrefix(typ, "numeric("):
+		return "decimal" + typ[7:]
+	}
+	return typ
+}
+
+// getTableStruct 读取表的实际结构，字段使用数据库中原始的类型和默认值，用于生成恢复到当前结构的计划（迁移的回滚）
+// 与描述文件一样每个字段只能属于一个索引，不符合 uk_表名_、ik_表名_ 命名的索引使用原名称作为分组
+func getTableStruct(conn *db.DB, tableName string) (*TableStruct, error) {
+	schema, err := GetTableSchema(conn, tableName)
+	if err != nil {
+		return nil, err
+	}
+	dbType := fixDBType(conn.Config.Type)
+	table := &TableStruct{
+		Name:    tableName,
+		Comment: schema.Comment,
+		Fields:  make([]TableField, 0),
+	}
+
+	switch dbType {
+	case "sqlite":
+		ddl := conn.Query("SELECT \"sql\" FROM \"sqlite_master\" WHERE \"type\"='table' AND \"name\"='" + tableName + "'").StringOnR1C1()
+		checks := getSqliteChecks(ddl)
+		indexNames := map[string]bool{}
+		for _, index := range getSqliteIndexes(conn, tableName) {
+			indexNames[index.Key_name] = true
+		}
+		columns := getSqliteColumns(conn, tableName)
+		pkNum := 0
+		for _, column := range columns {
+			if column.Pk > 0 {
+				pkNum++
+			}
+		}
+		for _, column := range columns {
+			field := TableField{
+				Name:    column.Name,
+				Type:    column.Type,
+				Null:    u.StringIf(column.Notnull, "NOT NULL", "NULL"),
+				DaoType: checks[column.Name],
+			}
+			if column.Dflt_value != nil && strings.ToUpper(u.String(column.Dflt_value)) != "NULL" {
+				field.Default = strings.Trim(u.String(column.Dflt_value), "'")
+			}
+			if column.Pk > 0 {
+				if pkNum == 1 && strings.EqualFold(column.Type, "integer") {
+					// INTEGER PRIMARY KEY 为 rowid 的别名，只有声明了 AUTOINCREMENT 时才不复用删除的 id
+					field.Extra = u.StringIf(strings.Contains(strings.ToUpper(ddl), "AUTOINCREMENT"), "AUTOINCREMENT", "PRIMARY KEY")
+					if indexNames["uk_"+tableName+"_"+column.Name] {
+						// 描述文件中的自增字段同时创建的唯一索引（GetTableSchema 中已忽略）
+						field.Index = typeMapping[dbType]["PK"]
+					}
+				} else {
+					field.Index = "PRIMARY KEY"
+					field.IndexPos = column.Pk
+					field.Null = "NOT NULL"
+				}
+			}
+			table.Fields = append(table.Fields, field)
+		}
+
+	case "pgsql":
+		onUpdateFields := map[string]bool{}
+		for _, triggerName := range conn.Query("SELECT tgname FROM pg_trigger WHERE tgrelid='" + conn.Quote(tableName) + "'::regclass AND NOT tgisinternal").StringsOnC1() {
+			if strings.HasPrefix(triggerName, "tu_"+tableName+"_") {
+				onUpdateFields[triggerName[len(tableName)+4:]] = true
+			}
+		}
+		for _, column := range getPgsqlColumns(conn, tableName) {
+			field := TableField{
+				Name:     column.Field,
+				Type:     fixPgsqlType(column.Type),
+				Null:     u.StringIf(column.Nullable, "NULL", "NOT NULL"),
+				OnUpdate: onUpdateFields[column.Field],
+			}
+			if column.Identity != "" {
+				field.Extra = typeMapping["pgsql"]["AI"]
+			}
+			if column.Dflt != nil {
+				field.Default = fixPgsqlDefault(*column.Dflt)
+			}
+			if column.Comment != nil {
+				field.Comment = *column.Comment
+			}
+			table.Fields = append(table.Fields, field)
+		}
+
+	default:
+		table.Engine = schema.Options.Engine
+		table.Collate = schema.Options.Collation
+		table.RowFormat = schema.Options.RowFormat
+		table.Partition = schema.Options.Partition
+		for _, desc := range schema.Fields {
+			field := TableField{
+				Name:    desc.Field,
+				Type:    desc.Type,
+				Null:    u.StringIf(desc.Null == "YES", "NULL", "NOT NULL"),
+				Comment: desc.Comment,
+				Collate: schema.Options.Columns[desc.Field],
+			}
+			if desc.Default != nil {
+				field.Default = *desc.Default
+			}
+			if strings.Contains(desc.Extra, "auto_increment") {
+				field.Extra = typeMapping["mysql"]["AI"]
+			}
+			if pos := strings.Index(desc.Extra, "on update "); pos >= 0 {
+				field.Default += " ON UPDATE " + strings.ToUpper(desc.Extra[pos+10:])
+				field.OnUpdate = true
+			}
+			table.Fields = append(table.Fields, field)
+		}
+	}
+
+	fieldIndexes := map[string]int{}
+	for i, field := range table.Fields {
+		fieldIndexes[field.Name] = i
+	}
+	for _, index := range schema.Indexes {
+		i, ok := fieldIndexes[index.Column_name]
+		if !ok {
+			continue
+		}
+		field := &table.Fields[i]
+		if index.Key_name == "PRIMARY" {
+			// sqlite 的主键已经通过 table_info 读取，描述文件中的主键为 uk_表名_99 唯一索引
+			if dbType != "sqlite" {
+				field.Index = typeMapping[dbType]["PK"]
+				field.IndexPos = index.Seq_in_index
+			} else if field.Index == "" && field.Extra == "" {
+				field.Index = typeMapping[dbType]["PK"]
+				field.IndexGroup = sqlitePrimaryGroup
+				field.IndexPos = index.Seq_in_index
+			}
+			continue
+		}
+		if field.Index != "" {
+			return nil, errors.New("field " + field.Name + " of " + tableName + " has multiple indexes")
+		}
+		if strings.HasPrefix(index.Key_name, "tk_") || index.Index_type == "FULLTEXT" {
+			field.Index = typeMapping[dbType]["TI"]
+			continue
+		}
+		prefix := u.StringIf(index.Non_unique == 0, "uk_", "ik_") + tableName + "_"
+		field.Index = typeMapping[dbType][u.StringIf(index.Non_unique == 0, "U", "I")]
+		field.IndexGroup = strings.TrimPrefix(strings.TrimPrefix(index.Key_name, prefix), "sqlite_autoindex_"+tableName+"_")
+		field.IndexPos = index.Seq_in_index
+		field.IndexDesc = index.Collation == "D"
+		field.IndexLen = index.Sub_part
+	}
+	for _, ref := range schema.Refs {
+		if i, ok := fieldIndexes[ref.Field]; ok {
+			table.Fields[i].RefTable = ref.RefTable
+			table.Fields[i].RefField = ref.RefField
+		}
+	}
+	return table, nil
+}
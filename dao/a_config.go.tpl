@@ -1,17 +1,30 @@
 package {{.DBName}}
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/ssgo/db"
 	"github.com/ssgo/log"
 	"github.com/ssgo/redis"
 	"github.com/ssgo/s"
+	"github.com/ssgo/u"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unsafe"
 )
 
 
 type Serve struct {
-	conn *db.DB
-	rd   *redis.Redis
+	conn     *db.DB
+	rd       *redis.Redis
+	versions VersionAllocator
 }
 
 func New(dbConn *db.DB, redisConn *redis.Redis) *Serve {
@@ -19,12 +32,22 @@ func New(dbConn *db.DB, redisConn *redis.Redis) *Serve {
 		conn: dbConn,
 		rd:   redisConn,
 	}
+	if redisConn != nil {
+		serve.versions = NewRedisVersionAllocator(redisConn)
+	} else {
+		serve.versions = NewDBVersionAllocator(dbConn)
+	}
 	return &serve
 }
 
+// SetVersionAllocator 替换分配版本号的方式，需要在 GetXxxDao 之前设置
+func (serve *Serve) SetVersionAllocator(versions VersionAllocator) {
+	serve.versions = versions
+}
+
 func (serve *Serve) SetInject() {
 	{{range .FixedTables}}
-	s.SetInject(&{{.}}Dao{conn: serve.conn, rd: serve.rd}){{end}}
+	s.SetInject(&{{.}}Dao{conn: serve.conn, rd: serve.rd, versions: serve.versions}){{end}}
 }
 
 func (serve *Serve) NewTransaction(logger *log.Logger) *db.Tx {
@@ -34,10 +57,598 @@ func (serve *Serve) NewTransaction(logger *log.Logger) *db.Tx {
 	return serve.conn.Begin()
 }
 
+// 写入方法返回的错误，可以使用 errors.Is 判断，bool 模式下 ErrVersionConflict 保存在 LastError 中
+var (
+	ErrNotFound        = errors.New("not found")         // Update、Delete 等没有修改任何数据
+	ErrDuplicateKey    = errors.New("duplicate key")     // 主键或唯一索引重复
+	ErrVersionConflict = errors.New("version conflict")  // 乐观锁检查版本失败
+	ErrNoDao           = errors.New("item without dao") // Item 不是通过 Dao 创建或读取的
+)
+
+// makeError 把数据库返回的主键、唯一索引重复转换为 ErrDuplicateKey，checkChanges 时没有修改数据返回 ErrNotFound
+func makeError(r *execResult, checkChanges bool) error {
+	if r.Error != nil {
+		msg := r.Error.Error()
+		// MySQL: Duplicate entry、SQLite: UNIQUE constraint failed、PostgreSQL: duplicate key value、SQL Server: duplicate key、Oracle: ORA-00001
+		if strings.Contains(msg, "Duplicate entry") || strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "duplicate key") || strings.Contains(msg, "ORA-00001") {
+			return fmt.Errorf("%w: %w", ErrDuplicateKey, r.Error)
+		}
+		return r.Error
+	}
+	if checkChanges && r.Changes() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// VersionAllocator 为有版本字段的表分配版本号，默认配置了 redis 时使用 RedisVersionAllocator，否则使用 DBVersionAllocator
+// ctx 为 Dao 的 WithContext 设置的 context（没有设置时为 nil），Dao 在事务中时 tx 为当前事务，不需要时可以忽略
+type VersionAllocator interface {
+	// Next 分配新的版本号，maxVersion 读取表中已有的最大版本，用于第一次使用或数据丢失时初始化
+	Next(ctx context.Context, tx *db.Tx, table string, maxVersion func() uint64) (uint64, error)
+	// NextRange 分配连续的 n 个版本号，返回第一个，写入完成后使用第一个版本调用 Commit
+	NextRange(ctx context.Context, tx *db.Tx, table string, n uint64, maxVersion func() uint64) (uint64, error)
+	// Commit 版本对应的写入已经完成（无论成功与否），ctx 已取消时也会调用，需要清除正在写入的标记
+	Commit(ctx context.Context, table string, version uint64)
+	// Max 已经完成的最大版本，QueryByVersion 只读取到这个版本，返回 0 时使用表中的最大版本
+	Max(table string) uint64
+}
+
+// RedisVersionAllocator 使用 redis 的 INCR 分配版本号，使用 _DATA_VERSION_DOING_ 标记正在写入的版本
+// ssgo/redis 不支持 context，每个命令前检查 ctx，已取消或超时时不再执行后面的命令
+type RedisVersionAllocator struct {
+	rd *redis.Redis
+}
+
+func NewRedisVersionAllocator(rd *redis.Redis) *RedisVersionAllocator {
+	return &RedisVersionAllocator{rd: rd}
+}
+
+func (a *RedisVersionAllocator) Next(ctx context.Context, tx *db.Tx, table string, maxVersion func() uint64) (uint64, error) {
+	if err := contextErr(ctx); err != nil {
+		return 0, err
+	}
+	version := uint64(a.rd.INCR("_DATA_VERSION_" + table))
+	if err := contextErr(ctx); err != nil {
+		// 已经分配的版本没有正在写入的标记，Commit 时会直接跳过
+		return 0, err
+	}
+	if version > 1 {
+		// 设置使用中的标记
+		a.rd.SETEX("_DATA_VERSION_DOING_"+table+"_"+strconv.FormatUint(version, 10), 10, true)
+		return version, nil
+	}
+
+	// 不存在redis数据时，使用数据库中的版本重建
+	a.rd.DEL("_DATA_VERSION_" + table)
+	version = maxVersion() + 1
+	a.rd.MSET("_DATA_VERSION_"+table, version, "_DATA_MAX_VERSION_"+table, version)
+	// 设置使用中的标记
+	a.rd.SETEX("_DATA_VERSION_DOING_"+table+"_"+strconv.FormatUint(version, 10), 10, true)
+	return version, nil
+}
+
+// NextRange 只标记第一个版本正在写入，Commit 遇到这个标记时不会更新之后的版本
+func (a *RedisVersionAllocator) NextRange(ctx context.Context, tx *db.Tx, table string, n uint64, maxVersion func() uint64) (uint64, error) {
+	if n <= 1 {
+		return a.Next(ctx, tx, table, maxVersion)
+	}
+	if err := contextErr(ctx); err != nil {
+		return 0, err
+	}
+	var version uint64
+	last := a.rd.Do("INCRBY", "_DATA_VERSION_"+table, n).Uint64()
+	if err := contextErr(ctx); err != nil {
+		return 0, err
+	}
+	if last > n {
+		version = last - n + 1
+	} else {
+		// 不存在redis数据时，使用数据库中的版本重建
+		a.rd.DEL("_DATA_VERSION_" + table)
+		version = maxVersion() + 1
+		a.rd.MSET("_DATA_VERSION_"+table, version+n-1, "_DATA_MAX_VERSION_"+table, version)
+	}
+	a.rd.SETEX("_DATA_VERSION_DOING_"+table+"_"+strconv.FormatUint(version, 10), 10, true)
+	return version, nil
+}
+
+// Commit 总是清除正在写入的标记，ctx 已取消或超时时不再更新 MAX_VERSION，留给之后的 Commit
+func (a *RedisVersionAllocator) Commit(ctx context.Context, table string, version uint64) {
+	// 先存储当前版本完成标记，然后检查所有新版本是否完成以设置MAX_VERSION
+	a.rd.DEL("_DATA_VERSION_DOING_" + table + "_" + strconv.FormatUint(version, 10))
+	if contextErr(ctx) != nil {
+		return
+	}
+	seqVersion := a.rd.GET("_DATA_VERSION_" + table).Uint64()
+	currentMaxVersion := a.rd.GET("_DATA_MAX_VERSION_" + table).Uint64()
+	for i := currentMaxVersion; i <= seqVersion; i++ {
+		if contextErr(ctx) != nil {
+			break
+		}
+		if a.rd.EXISTS("_DATA_VERSION_DOING_" + table + "_" + strconv.FormatUint(i, 10)) {
+			// 遇到仍在处理的版本，跳过更新MAX_VERSION，确保用户获取数据是有序的
+			break
+		} else {
+			// 更新MAX_VERSION，用户可以使用该版本
+			a.rd.SET("_DATA_MAX_VERSION_"+table, i)
+		}
+	}
+}
+
+func (a *RedisVersionAllocator) Max(table string) uint64 {
+	return a.rd.GET("_DATA_MAX_VERSION_" + table).Uint64()
+}
+
+// MaxPlaceholders 每条 SQL 最多使用的参数个数，InsertMany、GetMany、DeleteMany 按这个数量分批执行，key 为数据库类型的前缀
+var MaxPlaceholders = map[string]int{"mysql": 65535, "postgres": 65535, "sqlserver": 2100, "sqlite": 999}
+
+func maxPlaceholders(conn *db.DB) int {
+	if conn != nil {
+		for dbType, n := range MaxPlaceholders {
+			if strings.HasPrefix(conn.Config.Type, dbType) {
+				return n
+			}
+		}
+	}
+	return 999
+}
+
+// EachPageSize 在事务中使用 Each、All 时每次读取的行数，事务只有一个连接，逐行读取时无法在回调中继续使用事务，所以改为分页读取
+var EachPageSize uint = 1000
+
+var errEachStopped = errors.New("each stopped")
+
+// makeRowData 将逐行读取到的值转换为 u.Convert 可以写入 Item 的数据，与 db.QueryResult 一样 NULL 不写入、日期时间转换为字符串
+func makeRowData(colTypes []*sql.ColumnType, values []any) map[string]any {
+	data := make(map[string]any, len(values))
+	for i, col := range colTypes {
+		typ := strings.ToUpper(col.DatabaseTypeName())
+		switch v := values[i].(type) {
+		case nil:
+		case []byte:
+			data[col.Name()] = fixTimeString(typ, string(v))
+		case string:
+			data[col.Name()] = fixTimeString(typ, v)
+		case time.Time:
+			if typ == "DATE" {
+				data[col.Name()] = v.Format("2006-01-02")
+			} else if typ == "TIME" {
+				data[col.Name()] = v.Format("15:04:05")
+			} else {
+				data[col.Name()] = v.Format("2006-01-02 15:04:05")
+			}
+		default:
+			data[col.Name()] = v
+		}
+	}
+	return data
+}
+
+// fixTimeString SQLite 等返回的 2006-01-02T15:04:05Z 转换为 2006-01-02 15:04:05
+func fixTimeString(typ, str string) string {
+	if (typ == "DATETIME" || typ == "TIMESTAMP") && len(str) >= 19 && str[10] == 'T' {
+		return str[:10] + " " + strings.TrimRight(str[11:], "Z")
+	}
+	return str
+}
+
+func contextErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// dbRunner 在连接或事务中执行 Dao 的语句
+// ctx 为 nil 时使用 db.DB、db.Tx 执行，与 db 一样记录错误和慢查询日志，查询可以使用只读连接
+// 设置了 ctx 时使用 database/sql 的 ExecContext、QueryContext、BeginTx，ctx 取消或超时时由驱动中断正在执行的语句
+type dbRunner struct {
+	ctx   context.Context
+	conn  *db.DB
+	tx    *db.Tx
+	sqlTx *sql.Tx // Begin 使用 BeginTx 开始的事务
+}
+
+// originTx db.Tx 没有提供 *sql.Tx，设置了 ctx 时从 db.Tx 中读取，db 的结构改变或 Begin 失败时返回 nil，使用 db.Tx 执行
+func (r dbRunner) originTx() *sql.Tx {
+	if r.sqlTx != nil || r.tx == nil {
+		return r.sqlTx
+	}
+	field := reflect.ValueOf(r.tx).Elem().FieldByName("conn")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(r.sqlTx) {
+		return nil
+	}
+	return *(**sql.Tx)(unsafe.Pointer(field.UnsafeAddr()))
+}
+
+// useContext 使用 database/sql 执行，事务无法读取到 *sql.Tx 时只在执行前检查 ctx
+func (r dbRunner) useContext() bool {
+	if r.ctx == nil {
+		return false
+	}
+	if r.tx != nil {
+		return r.originTx() != nil
+	}
+	return r.sqlTx != nil || (r.conn != nil && r.conn.GetOriginDB() != nil)
+}
+
+func (r dbRunner) logError(err error, requestSql string, args []interface{}) {
+	if err != nil && r.conn != nil && r.conn.GetLogger() != nil {
+		r.conn.GetLogger().Error(err.Error(), "sql", requestSql, "args", args)
+	}
+}
+
+func (r dbRunner) Exec(requestSql string, args ...interface{}) *execResult {
+	if !r.useContext() {
+		if err := contextErr(r.ctx); err != nil {
+			return &execResult{Sql: &requestSql, Args: args, Error: err}
+		}
+		if r.tx != nil {
+			return newExecResult(r.tx.Exec(requestSql, args...))
+		}
+		return newExecResult(r.conn.Exec(requestSql, args...))
+	}
+	args = flatArgs(args)
+	result := &execResult{Sql: &requestSql, Args: args}
+	if tx := r.originTx(); tx != nil {
+		result.result, result.Error = tx.ExecContext(r.ctx, requestSql, args...)
+	} else {
+		result.result, result.Error = r.conn.GetOriginDB().ExecContext(r.ctx, requestSql, args...)
+	}
+	r.logError(result.Error, requestSql, args)
+	return result
+}
+
+func (r dbRunner) Query(requestSql string, args ...interface{}) *queryResult {
+	if !r.useContext() {
+		if err := contextErr(r.ctx); err != nil {
+			return &queryResult{Sql: &requestSql, Args: args, Error: err}
+		}
+		if r.tx != nil {
+			return newQueryResult(r.tx.Query(requestSql, args...))
+		}
+		return newQueryResult(r.conn.Query(requestSql, args...))
+	}
+	args = flatArgs(args)
+	result := &queryResult{Sql: &requestSql, Args: args}
+	var rows *sql.Rows
+	if tx := r.originTx(); tx != nil {
+		rows, result.Error = tx.QueryContext(r.ctx, requestSql, args...)
+	} else {
+		rows, result.Error = r.conn.GetOriginDB().QueryContext(r.ctx, requestSql, args...)
+	}
+	if result.Error == nil {
+		result.columns, result.rows, result.Error = readRows(rows)
+	}
+	r.logError(result.Error, requestSql, args)
+	return result
+}
+
+// Begin 开始新的事务，设置了 ctx 时使用 BeginTx，ctx 取消或超时时 database/sql 会回滚事务
+func (r dbRunner) Begin() (dbRunner, error) {
+	if err := contextErr(r.ctx); err != nil {
+		return r, err
+	}
+	if r.ctx != nil && r.conn.GetOriginDB() != nil {
+		sqlTx, err := r.conn.GetOriginDB().BeginTx(r.ctx, nil)
+		return dbRunner{ctx: r.ctx, conn: r.conn, sqlTx: sqlTx}, err
+	}
+	tx := r.conn.Begin()
+	return dbRunner{ctx: r.ctx, conn: r.conn, tx: tx}, tx.Error
+}
+
+func (r dbRunner) Commit() error {
+	if r.sqlTx != nil {
+		return r.sqlTx.Commit()
+	}
+	return r.tx.Commit()
+}
+
+func (r dbRunner) Rollback() error {
+	if r.sqlTx != nil {
+		return r.sqlTx.Rollback()
+	}
+	return r.tx.Rollback()
+}
+
+// flatArgs 与 db 相同，map、struct 和 slice（[]byte 除外）类型的参数转换为 JSON
+func flatArgs(args []interface{}) []interface{} {
+	for i, arg := range args {
+		v := reflect.ValueOf(arg)
+		if v.Kind() == reflect.Map || v.Kind() == reflect.Struct || (v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8) {
+			args[i] = u.Json(arg)
+		}
+	}
+	return args
+}
+
+// readRows 读取所有行并关闭 rows，每行使用 makeRowData 转换
+func readRows(rows *sql.Rows) ([]string, []map[string]any, error) {
+	defer rows.Close()
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, len(colTypes))
+	values := make([]any, len(colTypes))
+	scanArgs := make([]any, len(colTypes))
+	for i := range values {
+		columns[i] = colTypes[i].Name()
+		scanArgs[i] = &values[i]
+	}
+	list := make([]map[string]any, 0)
+	for rows.Next() {
+		if err = rows.Scan(scanArgs...); err != nil {
+			return columns, list, err
+		}
+		list = append(list, makeRowData(colTypes, values))
+	}
+	return columns, list, rows.Err()
+}
+
+// execResult 与 db.ExecResult 的用法相同，ctx 为 nil 时 r 为 db 的执行结果
+type execResult struct {
+	Sql    *string
+	Args   []interface{}
+	Error  error
+	r      *db.ExecResult
+	result sql.Result
+}
+
+func newExecResult(r *db.ExecResult) *execResult {
+	return &execResult{Sql: r.Sql, Args: r.Args, Error: r.Error, r: r}
+}
+
+func (r *execResult) Changes() int64 {
+	if r.r != nil {
+		return r.r.Changes()
+	}
+	if r.result == nil {
+		return 0
+	}
+	n, _ := r.result.RowsAffected()
+	return n
+}
+
+func (r *execResult) Id() int64 {
+	if r.r != nil {
+		return r.r.Id()
+	}
+	if r.result == nil {
+		return 0
+	}
+	id, _ := r.result.LastInsertId()
+	return id
+}
+
+// queryResult 与 db.QueryResult 的用法相同，ctx 为 nil 时 r 为 db 的查询结果，否则已经读取了所有行
+type queryResult struct {
+	Sql     *string
+	Args    []interface{}
+	Error   error
+	r       *db.QueryResult
+	columns []string
+	rows    []map[string]any
+}
+
+func newQueryResult(r *db.QueryResult) *queryResult {
+	return &queryResult{Sql: r.Sql, Args: r.Args, Error: r.Error, r: r}
+}
+
+// To 与 db.QueryResult 相同，out 为 slice 的指针时写入所有行，否则写入第一行
+func (r *queryResult) To(out interface{}) error {
+	if r.r != nil {
+		return r.r.To(out)
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if v := reflect.ValueOf(out); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		u.Convert(r.rows, out)
+	} else if len(r.rows) > 0 {
+		u.Convert(r.rows[0], out)
+	}
+	return nil
+}
+
+func (r *queryResult) IntOnR1C1() int64 {
+	if r.r != nil {
+		return r.r.IntOnR1C1()
+	}
+	if len(r.rows) == 0 || len(r.columns) == 0 {
+		return 0
+	}
+	return u.Int64(r.rows[0][r.columns[0]])
+}
+
+func (r *queryResult) Complete() {
+	if r.r != nil {
+		r.r.Complete()
+	}
+}
+
+// SequenceTable DBVersionAllocator 使用的表，以 _ 开头不会生成DAO对象
+var SequenceTable = "_dao_sequence"
+
+// DBVersionAllocator 使用数据库中的 _dao_sequence 表分配版本号，MySQL 使用 LAST_INSERT_ID(expr)，其他数据库在事务中先 UPDATE 锁定记录
+// Dao 在事务中时在这个事务中分配（SQLite 的外层事务持有写锁，新的事务会失败），_dao_sequence 中的记录锁定到外层事务结束
+// 正在写入的版本只记录在当前进程中，多个进程时 QueryByVersion 不能保证读取到的版本是连续的
+type DBVersionAllocator struct {
+	conn   *db.DB
+	lock   sync.Mutex
+	inited map[string]bool
+	doing  map[string]map[uint64]bool
+}
+
+func NewDBVersionAllocator(conn *db.DB) *DBVersionAllocator {
+	return &DBVersionAllocator{conn: conn, inited: map[string]bool{}, doing: map[string]map[uint64]bool{}}
+}
+
+func (a *DBVersionAllocator) init(ctx context.Context, tx *db.Tx, table string, maxVersion func() uint64) error {
+	a.lock.Lock()
+	inited := a.inited[table]
+	a.lock.Unlock()
+	if inited {
+		return nil
+	}
+
+	isMysql := strings.HasPrefix(a.conn.Config.Type, "mysql")
+	exec := dbRunner{ctx: ctx, conn: a.conn, tx: tx}
+	createSql := "CREATE TABLE IF NOT EXISTS " + SequenceTable + " (name VARCHAR(100) NOT NULL PRIMARY KEY, version BIGINT NOT NULL)"
+	var r *execResult
+	if isMysql {
+		// MySQL 中的 DDL 会隐式提交当前事务
+		r = dbRunner{ctx: ctx, conn: a.conn}.Exec(createSql)
+	} else {
+		r = exec.Exec(createSql)
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if exec.Query("SELECT COUNT(*) FROM "+SequenceTable+" WHERE name=?", table).IntOnR1C1() == 0 {
+		// 第一次使用时从表中已有的最大版本开始，多个进程同时插入时其他进程会因为主键重复失败，忽略错误
+		_ = exec.Exec("INSERT INTO "+SequenceTable+" (name, version) VALUES (?, ?)", table, maxVersion())
+	}
+
+	// 在事务中创建的记录可能被回滚，下次仍然需要检查
+	if tx == nil {
+		a.lock.Lock()
+		a.inited[table] = true
+		a.lock.Unlock()
+	}
+	return nil
+}
+
+func (a *DBVersionAllocator) Next(ctx context.Context, tx *db.Tx, table string, maxVersion func() uint64) (uint64, error) {
+	return a.NextRange(ctx, tx, table, 1, maxVersion)
+}
+
+func (a *DBVersionAllocator) NextRange(ctx context.Context, tx *db.Tx, table string, n uint64, maxVersion func() uint64) (uint64, error) {
+	if err := contextErr(ctx); err != nil {
+		return 0, err
+	}
+	if err := a.init(ctx, tx, table, maxVersion); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	var version uint64
+	exec := dbRunner{ctx: ctx, conn: a.conn, tx: tx}
+	if strings.HasPrefix(a.conn.Config.Type, "mysql") {
+		// LAST_INSERT_ID(expr) 的值在 UPDATE 的结果中返回，不需要事务
+		r := exec.Exec("UPDATE "+SequenceTable+" SET version=LAST_INSERT_ID(version+?) WHERE name=?", n, table)
+		if r.Error != nil {
+			return 0, r.Error
+		}
+		version = uint64(r.Id())
+	} else if tx != nil {
+		// 在调用方的事务中 UPDATE，记录锁定到事务结束
+		r := exec.Exec("UPDATE "+SequenceTable+" SET version=version+? WHERE name=?", n, table)
+		if r.Error != nil {
+			return 0, r.Error
+		}
+		version = uint64(exec.Query("SELECT version FROM "+SequenceTable+" WHERE name=?", table).IntOnR1C1())
+	} else {
+		// UPDATE 锁定记录（SQLite 锁定数据库）直到事务结束
+		tx, err := exec.Begin()
+		if err != nil {
+			return 0, err
+		}
+		r := tx.Exec("UPDATE "+SequenceTable+" SET version=version+? WHERE name=?", n, table)
+		if r.Error != nil {
+			_ = tx.Rollback()
+			return 0, r.Error
+		}
+		q := tx.Query("SELECT version FROM "+SequenceTable+" WHERE name=?", table)
+		if q.Error != nil {
+			_ = tx.Rollback()
+			return 0, q.Error
+		}
+		version = uint64(q.IntOnR1C1())
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	if version < n {
+		return 0, fmt.Errorf("failed to allocate version for %s", table)
+	}
+	// 返回这一段中的第一个版本
+	version = version - n + 1
+
+	a.lock.Lock()
+	if a.doing[table] == nil {
+		a.doing[table] = map[uint64]bool{}
+	}
+	a.doing[table][version] = true
+	a.lock.Unlock()
+	return version, nil
+}
+
+func (a *DBVersionAllocator) Commit(ctx context.Context, table string, version uint64) {
+	a.lock.Lock()
+	delete(a.doing[table], version)
+	a.lock.Unlock()
+}
+
+func (a *DBVersionAllocator) Max(table string) uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// 当前进程中有正在写入的版本时只读取到最小的正在写入的版本之前
+	var minDoing uint64 = 0
+	for version := range a.doing[table] {
+		if minDoing == 0 || version < minDoing {
+			minDoing = version
+		}
+	}
+	if minDoing > 0 {
+		return minDoing - 1
+	}
+	return 0
+}
+
 type Datetime string
 type Time string
 type Date string
 
+// Decimal 定点数使用字符串保存，避免精度丢失，db 只能通过指针读取命名的字符串类型，NOT NULL 时也使用指针
+type Decimal string
+
+// Json 原始的JSON内容，写入时作为字符串，输出JSON时不再转义，使用 JsonByValue 生成、To 解析
+type Json json.RawMessage
+
+func DecimalByFloat(f float64, scale int) Decimal {
+	return Decimal(strconv.FormatFloat(f, 'f', scale, 64))
+}
+func DecimalFloat(d Decimal) float64 {
+	f, _ := strconv.ParseFloat(string(d), 64)
+	return f
+}
+
+func JsonByValue(v any) Json {
+	j, _ := json.Marshal(v)
+	return j
+}
+func (j Json) To(v any) error {
+	return json.Unmarshal(j, v)
+}
+
+// Value 写入时使用字符串，MySQL 的 JSON 字段不接受二进制字符串，nil 为 NULL
+func (j Json) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return string(j), nil
+}
+func (j Json) MarshalJSON() ([]byte, error) {
+	return json.RawMessage(j).MarshalJSON()
+}
+func (j *Json) UnmarshalJSON(data []byte) error {
+	return (*json.RawMessage)(j).UnmarshalJSON(data)
+}
+
 func DatetimeByString(s string) Datetime {
 	return Datetime(s)
 }
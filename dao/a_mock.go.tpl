@@ -0,0 +1,112 @@
+package {{.DBName}}
+
+import (
+	"sync"
+{{- range .Imports }}
+	{{ . }}
+{{- end }}
+)
+
+// {{.FixedTableName}}DaoInterface {{.FixedTableName}}Dao 的所有导出方法，Serve 返回这个接口，测试时可以替换为 {{.FixedTableName}}DaoMock
+type {{.FixedTableName}}DaoInterface interface {
+{{- range .Methods }}
+	{{.Name}}({{.Params}}) {{.Returns}}
+{{- end }}
+}
+
+var _ {{.FixedTableName}}DaoInterface = (*{{.FixedTableName}}Dao)(nil)
+var _ {{.FixedTableName}}DaoInterface = (*{{.FixedTableName}}DaoMock)(nil)
+
+type {{.FixedTableName}}DaoMockCall struct {
+	Method string
+	Args   []any
+}
+
+// {{.FixedTableName}}DaoMock 记录所有调用，设置了 XxxFunc 时返回 XxxFunc 的结果，否则返回零值
+// New 返回属于 Mock 的 Item（Save 等会调用 Mock），WithContext 等返回 Mock 本身，NewQuery 返回的查询使用 Rows、RowsFunc 作为结果
+type {{.FixedTableName}}DaoMock struct {
+{{- range .Methods }}
+	{{.Name}}Func func({{.Params}}) {{.Returns}}
+{{- end }}
+
+	// Rows NewQuery 返回的查询的结果，List、First、Each 返回属于 Mock 的 Item，Count 为行数
+	Rows []{{.FixedTableName}}Item
+	// RowsFunc 按查询的 SQL 返回结果，设置后不使用 Rows
+	RowsFunc func(sql string, args []any) []{{.FixedTableName}}Item
+
+	calls []{{.FixedTableName}}DaoMockCall
+	lock  sync.Mutex
+}
+
+func New{{.FixedTableName}}DaoMock() *{{.FixedTableName}}DaoMock {
+	return &{{.FixedTableName}}DaoMock{}
+}
+
+func (mock *{{.FixedTableName}}DaoMock) record(method string, args ...any) {
+	mock.lock.Lock()
+	mock.calls = append(mock.calls, {{.FixedTableName}}DaoMockCall{Method: method, Args: args})
+	mock.lock.Unlock()
+}
+
+// Calls 返回所有调用，按调用的顺序
+func (mock *{{.FixedTableName}}DaoMock) Calls() []{{.FixedTableName}}DaoMockCall {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	return append([]{{.FixedTableName}}DaoMockCall{}, mock.calls...)
+}
+
+// CallsOf 返回 method 每次调用的参数
+func (mock *{{.FixedTableName}}DaoMock) CallsOf(method string) [][]any {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	out := make([][]any, 0)
+	for _, call := range mock.calls {
+		if call.Method == method {
+			out = append(out, call.Args)
+		}
+	}
+	return out
+}
+
+// queryRows NewQuery 返回的查询执行时调用，记录为 Query
+func (mock *{{.FixedTableName}}DaoMock) queryRows(sql string, args []any) []{{.FixedTableName}}Item {
+	mock.record("Query", sql, args)
+	rows := mock.Rows
+	if mock.RowsFunc != nil {
+		rows = mock.RowsFunc(sql, args)
+	}
+	if rows == nil {
+		// 不为 nil 表示已经执行过查询
+		rows = []{{.FixedTableName}}Item{}
+	}
+	return rows
+}
+
+func (mock *{{.FixedTableName}}DaoMock) Reset() {
+	mock.lock.Lock()
+	mock.calls = nil
+	mock.lock.Unlock()
+}
+{{ range .Methods }}
+func (mock *{{$.FixedTableName}}DaoMock) {{.Name}}({{.Params}}) {{.Results}} {
+	mock.record("{{.Name}}"{{ if .RecordArgs }}, {{.RecordArgs}}{{ end }})
+	if mock.{{.Name}}Func != nil {
+		{{ if .FirstResult }}return {{ end }}mock.{{.Name}}Func({{.Args}})
+		{{- if not .FirstResult }}
+		return
+		{{- end }}
+	}
+{{- if eq .FirstType (print $.FixedTableName "DaoInterface") }}
+	{{.FirstResult}} = mock
+{{- else if eq .Name "New" }}
+	{{.FirstResult}} = &{{$.FixedTableName}}Item{dao: mock, isNew: true, changes: map[string]any{}}
+{{- else if eq .Name "NewQuery" }}
+	{{.FirstResult}} = (&{{$.FixedTableName}}Dao{}).NewQuery()
+	{{.FirstResult}}.mock = mock
+{{- else if eq .Name "Attach" }}
+	item.dao = mock
+	item.changes = map[string]any{}
+{{- end }}
+	return
+}
+{{ end }}
@@ -1,12 +1,15 @@
 package {{.DBName}}
 
 import (
+	"context"
 	"fmt"
 	"github.com/ssgo/db"
 	"github.com/ssgo/log"
 	"github.com/ssgo/redis"
 	"github.com/ssgo/u"
+	"iter"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -14,11 +17,30 @@ type {{.FixedTableName}}Dao struct {
 	conn *db.DB
 	tx *db.Tx
 	rd *redis.Redis
+	versions VersionAllocator
 	logger *log.Logger
 	lastError error
+	ctx context.Context
 }
 
-func (serve *Serve) Get{{.FixedTableName}}Dao(logger *log.Logger) *{{.FixedTableName}}Dao {
+// Get{{.FixedTableName}}Dao 返回接口，测试时可以替换为 {{.FixedTableName}}DaoMock
+func (serve *Serve) Get{{.FixedTableName}}Dao(logger *log.Logger) {{.FixedTableName}}DaoInterface {
+	if dao := serve.new{{.FixedTableName}}Dao(logger); dao != nil {
+		return dao
+	}
+	return nil
+}
+
+func (serve *Serve) Get{{.FixedTableName}}DaoByTransaction(tx *db.Tx, logger *log.Logger) {{.FixedTableName}}DaoInterface {
+	dao := serve.new{{.FixedTableName}}Dao(logger)
+	if dao == nil {
+		return nil
+	}
+	dao.tx = tx
+	return dao
+}
+
+func (serve *Serve) new{{.FixedTableName}}Dao(logger *log.Logger) *{{.FixedTableName}}Dao {
 	if serve.conn == nil {
 		log.DefaultLogger.Error("no db configured", "dao", "{{.DBName}}", "table", "{{.TableName}}")
 		return nil
@@ -37,16 +59,15 @@ func (serve *Serve) Get{{.FixedTableName}}Dao(logger *log.Logger) *{{.FixedTable
 		conn: conn,
 		tx: nil,
 		rd: rd,
+		versions: serve.versions,
 	}
 }
 
-func (serve *Serve) Get{{.FixedTableName}}DaoByTransaction(tx *db.Tx, logger *log.Logger) *{{.FixedTableName}}Dao {
-	dao := serve.Get{{.FixedTableName}}Dao(logger)
-	dao.tx = tx
-	return dao
+func (dao *{{.FixedTableName}}Dao) CopyByLogger(logger *log.Logger) {{.FixedTableName}}DaoInterface {
+	return dao.copyByLogger(logger)
 }
 
-func (dao *{{.FixedTableName}}Dao) CopyByLogger(logger *log.Logger) *{{.FixedTableName}}Dao {
+func (dao *{{.FixedTableName}}Dao) copyByLogger(logger *log.Logger) *{{.FixedTableName}}Dao {
 	newDao := new({{.FixedTableName}}Dao)
 	if logger == nil {
 		logger = log.DefaultLogger
@@ -61,11 +82,49 @@ func (dao *{{.FixedTableName}}Dao) CopyByLogger(logger *log.Logger) *{{.FixedTab
 	if dao.rd != nil {
 		newDao.rd = dao.rd.CopyByLogger(logger)
 	}
+	newDao.versions = dao.versions
+	newDao.ctx = dao.ctx
 	return newDao
 }
 
-func (dao *{{.FixedTableName}}Dao) NewTransaction() (*{{.FixedTableName}}Dao, *db.Tx) {
-	newDao := dao.CopyByLogger(dao.logger)
+// WithContext 返回使用 ctx 的 Dao，语句使用 database/sql 的 ExecContext、QueryContext 执行，ctx 取消或超时时由驱动中断正在执行的语句，返回失败，LastError 为 ctx 的错误
+// InsertMany、DeleteMany 和 DBVersionAllocator 自己开始的事务使用 BeginTx；ssgo/redis 不支持 context，RedisVersionAllocator 在每个命令前检查 ctx
+func (dao *{{.FixedTableName}}Dao) WithContext(ctx context.Context) {{.FixedTableName}}DaoInterface {
+	return dao.withContext(ctx)
+}
+
+func (dao *{{.FixedTableName}}Dao) withContext(ctx context.Context) *{{.FixedTableName}}Dao {
+	newDao := *dao
+	newDao.ctx = ctx
+	newDao.lastError = nil
+	return &newDao
+}
+
+func (dao *{{.FixedTableName}}Dao) Context() context.Context {
+	if dao.ctx == nil {
+		return context.Background()
+	}
+	return dao.ctx
+}
+
+func (dao *{{.FixedTableName}}Dao) contextDone() bool {
+	if dao.ctx != nil {
+		if err := dao.ctx.Err(); err != nil {
+			dao.lastError = err
+			return true
+		}
+	}
+	return false
+}
+
+// runner 设置了 ctx 时语句使用 ctx 执行
+func (dao *{{.FixedTableName}}Dao) runner() dbRunner {
+	return dbRunner{ctx: dao.ctx, conn: dao.conn, tx: dao.tx}
+}
+
+// NewTransaction 返回 *db.Tx，使用 db 的 Begin 开始，设置了 ctx 时事务中的语句使用 ctx 执行，ctx 取消后语句返回错误，需要调用方回滚
+func (dao *{{.FixedTableName}}Dao) NewTransaction() ({{.FixedTableName}}DaoInterface, *db.Tx) {
+	newDao := dao.copyByLogger(dao.logger)
 	newDao.tx = newDao.conn.Begin()
 	return newDao, newDao.tx
 }
@@ -87,14 +146,94 @@ func (dao *{{.FixedTableName}}Dao) Attach(item *{{.FixedTableName}}Item) {
 	item.changes = map[string]any{}
 }
 
+{{range .RelatedTables}}
+func (dao *{{$.FixedTableName}}Dao) get{{.}}Dao() *{{.}}Dao {
+	return &{{.}}Dao{conn: dao.conn, tx: dao.tx, rd: dao.rd, versions: dao.versions, logger: dao.logger, ctx: dao.ctx}
+}
+{{ end }}
+
+{{range .BelongsTo}}
+// {{.Name}}Of 批量读取 items 中 {{.Column}} 引用的 {{.RefTableName}}，只执行一次 IN 查询
+func (dao *{{$.FixedTableName}}Dao) {{.Name}}Of(items []{{$.FixedTableName}}Item) map[{{.Type}}]*{{.RefTable}}Item {
+	out := make(map[{{.Type}}]*{{.RefTable}}Item)
+	values := make([]interface{}, 0, len(items))
+	exists := make(map[{{.Type}}]bool)
+	for _, item := range items {
+{{ if .IsPoint }}
+		if item.{{.Field}} != nil && !exists[*item.{{.Field}}] {
+			exists[*item.{{.Field}}] = true
+			values = append(values, *item.{{.Field}})
+		}
+{{ else }}
+		if !exists[item.{{.Field}}] {
+			exists[item.{{.Field}}] = true
+			values = append(values, item.{{.Field}})
+		}
+{{ end }}
+	}
+	if len(values) == 0 {
+		return out
+	}
+
+	list := dao.get{{.RefTable}}Dao().NewQuery().In("{{.RefColumn}}", values...).List()
+	for i := range list {
+{{ if .RefIsPoint }}
+		if list[i].{{.RefField}} != nil {
+			out[{{.Type}}(*list[i].{{.RefField}})] = &list[i]
+		}
+{{ else }}
+		out[{{.Type}}(list[i].{{.RefField}})] = &list[i]
+{{ end }}
+	}
+	return out
+}
+{{ end }}
+
+{{range .HasMany}}
+// {{.Name}}Of 批量读取引用 items 的 {{.RefTableName}}，按 {{.Column}} 分组，只执行一次 IN 查询
+func (dao *{{$.FixedTableName}}Dao) {{.Name}}Of(items []{{$.FixedTableName}}Item) map[{{.Type}}][]{{.RefTable}}Item {
+	out := make(map[{{.Type}}][]{{.RefTable}}Item)
+	values := make([]interface{}, 0, len(items))
+	exists := make(map[{{.Type}}]bool)
+	for _, item := range items {
+{{ if .IsPoint }}
+		if item.{{.Field}} != nil && !exists[*item.{{.Field}}] {
+			exists[*item.{{.Field}}] = true
+			values = append(values, *item.{{.Field}})
+		}
+{{ else }}
+		if !exists[item.{{.Field}}] {
+			exists[item.{{.Field}}] = true
+			values = append(values, item.{{.Field}})
+		}
+{{ end }}
+	}
+	if len(values) == 0 {
+		return out
+	}
+
+	for _, refItem := range dao.get{{.RefTable}}Dao().NewQuery().In("{{.RefColumn}}", values...).List() {
+{{ if .RefIsPoint }}
+		if refItem.{{.RefField}} != nil {
+			key := {{.Type}}(*refItem.{{.RefField}})
+			out[key] = append(out[key], refItem)
+		}
+{{ else }}
+		key := {{.Type}}(refItem.{{.RefField}})
+		out[key] = append(out[key], refItem)
+{{ end }}
+	}
+	return out
+}
+{{ end }}
+
 {{range .UniqueKeys}}
 func (dao *{{$.FixedTableName}}Dao) GetBy{{.Name}}({{.Params}}) *{{$.FixedTableName}}Item {
-	result := make([]{{$.FixedTableName}}Item, 0)
-	if dao.tx != nil {
-		_ = dao.tx.Query("SELECT {{$.SelectFields}} FROM `{{$.TableName}}` WHERE {{.Where}}{{$.ValidWhere}}", {{.Args}}).To(&result)
-	} else {
-		_ = dao.conn.Query("SELECT {{$.SelectFields}} FROM `{{$.TableName}}` WHERE {{.Where}}{{$.ValidWhere}}", {{.Args}}).To(&result)
+	if dao.contextDone() {
+		return nil
 	}
+	result := make([]{{$.FixedTableName}}Item, 0)
+	_ = dao.runner().Query("SELECT {{$.SelectFields}} FROM `{{$.TableName}}` WHERE {{.Where}}{{$.ValidWhere}}", {{.Args}}).To(&result)
 	if len(result) > 0 {
 		result[0].dao = dao
 		result[0].changes = map[string]any{}
@@ -106,12 +245,11 @@ func (dao *{{$.FixedTableName}}Dao) GetBy{{.Name}}({{.Params}}) *{{$.FixedTableN
 
 {{ if .PrimaryKey }}
 func (dao *{{.FixedTableName}}Dao) Get({{.PrimaryKey.Params}}) *{{.FixedTableName}}Item {
-	result := make([]{{.FixedTableName}}Item, 0)
-	if dao.tx != nil {
-		_ = dao.tx.Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
-	} else {
-		_ = dao.conn.Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
+	if dao.contextDone() {
+		return nil
 	}
+	result := make([]{{.FixedTableName}}Item, 0)
+	_ = dao.runner().Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
 	if len(result) > 0 {
 		result[0].dao = dao
 		result[0].changes = map[string]any{}
@@ -120,13 +258,43 @@ func (dao *{{.FixedTableName}}Dao) Get({{.PrimaryKey.Params}}) *{{.FixedTableNam
 	return nil
 }
 
+{{ if .PrimaryKey.Column }}
+// GetMany 按主键批量读取，按 MaxPlaceholders 分批使用 IN 查询，不存在的数据不在结果中
+func (dao *{{.FixedTableName}}Dao) GetMany(ids []{{.PrimaryKey.Type}}) map[{{.PrimaryKey.Type}}]*{{.FixedTableName}}Item {
+	out := make(map[{{.PrimaryKey.Type}}]*{{.FixedTableName}}Item, len(ids))
+	limit := maxPlaceholders(dao.conn)
+	for start := 0; start < len(ids); start += limit {
+		if dao.contextDone() {
+			return nil
+		}
+		end := start + limit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+		}
+		sql := "SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE `{{.PrimaryKey.Column}}` IN " + dao.conn.InKeys(len(args)) + "{{.ValidWhere}}"
+		result := make([]{{.FixedTableName}}Item, 0)
+		_ = dao.runner().Query(sql, args...).To(&result)
+		for i := range result {
+			item := &result[i]
+			item.dao = dao
+			item.changes = map[string]any{}
+			out[{{.PrimaryKey.ItemArgs}}] = item
+		}
+	}
+	return out
+}
+{{ end }}
+
 func (dao *{{.FixedTableName}}Dao) GetWithFields({{.PrimaryKey.Params}}, fields string) *{{.FixedTableName}}Item {
-	result := make([]{{.FixedTableName}}Item, 0)
-	if dao.tx != nil {
-		_ = dao.tx.Query("SELECT "+fields+" FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
-	} else {
-		_ = dao.conn.Query("SELECT "+fields+" FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
+	if dao.contextDone() {
+		return nil
 	}
+	result := make([]{{.FixedTableName}}Item, 0)
+	_ = dao.runner().Query("SELECT "+fields+" FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}{{.ValidWhere}}", {{.PrimaryKey.Args}}).To(&result)
 	if len(result) > 0 {
 		result[0].dao = dao
 		result[0].changes = map[string]any{}
@@ -137,12 +305,11 @@ func (dao *{{.FixedTableName}}Dao) GetWithFields({{.PrimaryKey.Params}}, fields
 
 {{ if .ValidSet }}
 func (dao *{{.FixedTableName}}Dao) GetWithInvalid({{.PrimaryKey.Params}}) *{{.FixedTableName}}Item {
-	result := make([]{{.FixedTableName}}Item, 0)
-	if dao.tx != nil {
-		_ = dao.tx.Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}}).To(&result)
-	} else {
-		_ = dao.conn.Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}}).To(&result)
+	if dao.contextDone() {
+		return nil
 	}
+	result := make([]{{.FixedTableName}}Item, 0)
+	_ = dao.runner().Query("SELECT {{.SelectFields}} FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}}).To(&result)
 	if len(result) > 0 {
 		result[0].dao = dao
 		result[0].changes = map[string]any{}
@@ -154,19 +321,11 @@ func (dao *{{.FixedTableName}}Dao) GetWithInvalid({{.PrimaryKey.Params}}) *{{.Fi
 
 {{ end }}
 
-{{ if .HasVersion }}
-    {{ if .IsAutoId }}
-func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) (int64, bool, uint64) {
-    {{ else }}
-func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) (bool, uint64) {
-    {{ end }}
-{{ else }}
-    {{ if .IsAutoId }}
-func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) (int64, bool) {
-    {{ else }}
-func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) bool {
-    {{ end }}
-{{ end }}
+func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) ({{ if .IsAutoId }}id int64, {{ end }}{{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
     data := make(map[string]interface{})
     u.Convert(item, data)
 
@@ -179,41 +338,225 @@ func (dao *{{.FixedTableName}}Dao) Insert(item *{{.FixedTableName}}Item) bool {
     delete(data, "{{$field}}")
 {{ end }}
 
+{{ if .EnumFields }}
+	if enumErr := dao.checkEnums(data); enumErr != nil {
+		dao.lastError = enumErr
+		{{ if .ReturnError }}err = enumErr{{ end }}
+		return
+	}
+{{ end }}
 {{ if .HasVersion }}
-	version := dao.getVersion()
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
 	data["{{.VersionField}}"] = version
 {{ end }}
 
-	var r *db.ExecResult
-	if dao.tx != nil {
-		r = dao.tx.Insert("{{.TableName}}", data)
-	} else {
-		r = dao.conn.Insert("{{.TableName}}", data)
-	}
+	sql, values := dao.conn.MakeInsertSql("{{.TableName}}", data, false)
+	r := dao.runner().Exec(sql, values...)
 	dao.lastError = r.Error
 
 {{ if .HasVersion }}
 	dao.commitVersion(version)
-    {{ if .IsAutoId }}
-	return r.Id(), r.Error == nil && r.Changes() > 0, version
-    {{ else }}
-	return r.Error == nil && r.Changes() > 0, version
-    {{ end }}
+{{ end }}
+{{ if .IsAutoId }}
+	id = r.Id()
+{{ end }}
+{{ if .ReturnError }}
+	err = makeError(r, false)
 {{ else }}
-    {{ if .IsAutoId }}
-	return r.Id(), r.Error == nil && r.Changes() > 0
-    {{ else }}
-	return r.Error == nil && r.Changes() > 0
-    {{ end }}
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
-
+	return
 }
 
+// InsertMany 批量插入，字段相同的数据使用多行 VALUES 按 MaxPlaceholders 分批执行，不在事务中时使用新的事务，全部成功或全部失败
+// 成功后{{ if .HasVersion }}将一次分配的连续版本号{{ if .IsAutoId }}以及{{ end }}{{ end }}{{ if .IsAutoId }}自增ID（只支持 MySQL 和 SQLite）{{ end }}写入 items{{ if not (or .HasVersion .IsAutoId) }}，不修改 items{{ end }}
+func (dao *{{.FixedTableName}}Dao) InsertMany(items []*{{.FixedTableName}}Item) ({{ if .ReturnError }}err error{{ else }}ok bool{{ end }}) {
+	if len(items) == 0 {
+		{{ if not .ReturnError }}ok = true{{ end }}
+		return
+	}
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+
+	datas := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		data := make(map[string]interface{})
+		u.Convert(item, data)
+{{ range $index, $field := .AutoGenerated }}
+		if data["{{$field}}"] == nil {
+			delete(data, "{{$field}}")
+		}
+{{ end }}
+{{ range $index, $field := .AutoGeneratedOnUpdate }}
+		delete(data, "{{$field}}")
+{{ end }}
+{{ if .EnumFields }}
+		if enumErr := dao.checkEnums(data); enumErr != nil {
+			dao.lastError = enumErr
+			{{ if .ReturnError }}err = enumErr{{ end }}
+			return
+		}
+{{ end }}
+		datas[i] = data
+	}
+
 {{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) Replace(item *{{.FixedTableName}}Item) (bool, uint64) {
-{{ else }}
-func (dao *{{.FixedTableName}}Dao) Replace(item *{{.FixedTableName}}Item) bool {
+	version, versionErr := dao.getVersionRange(uint64(len(items)))
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
+	defer dao.commitVersion(version)
+	for i, data := range datas {
+		data["{{.VersionField}}"] = version + uint64(i)
+	}
 {{ end }}
+
+	// 按字段分组，每组使用相同的 INSERT 语句
+	type insertGroup struct {
+		keys    []string
+		rows    []string
+		values  [][]interface{}
+		indexes []int
+	}
+	groups := make([]*insertGroup, 0)
+	groupsByKeys := map[string]*insertGroup{}
+	for i, data := range datas {
+		keys, vars, values := db.MakeKeysVarsValues(data)
+		rowVars := make(map[string]string, len(keys))
+		rowValues := make(map[string]interface{}, len(keys))
+		valueIndex := 0
+		for j, k := range keys {
+			rowVars[k] = vars[j]
+			if vars[j] == "?" {
+				rowValues[k] = values[valueIndex]
+				valueIndex++
+			}
+		}
+		sort.Strings(keys)
+
+		group := groupsByKeys[strings.Join(keys, ",")]
+		if group == nil {
+			group = &insertGroup{keys: keys}
+			groupsByKeys[strings.Join(keys, ",")] = group
+			groups = append(groups, group)
+		}
+		row := make([]string, len(keys))
+		rowArgs := make([]interface{}, 0, len(keys))
+		for j, k := range keys {
+			row[j] = rowVars[k]
+			if rowVars[k] == "?" {
+				rowArgs = append(rowArgs, rowValues[k])
+			}
+		}
+		group.rows = append(group.rows, "("+strings.Join(row, ",")+")")
+		group.values = append(group.values, rowArgs)
+		group.indexes = append(group.indexes, i)
+	}
+
+	tx := dao.runner()
+	if dao.tx == nil {
+		var beginErr error
+		if tx, beginErr = tx.Begin(); beginErr != nil {
+			dao.lastError = beginErr
+			{{ if .ReturnError }}err = beginErr{{ end }}
+			return
+		}
+	}
+	limit := maxPlaceholders(dao.conn)
+{{ if .IsAutoId }}
+	ids := make(map[int]{{.AutoIdFieldType}})
+	isMysql := strings.HasPrefix(dao.conn.Config.Type, "mysql")
+	isSqlite := strings.HasPrefix(dao.conn.Config.Type, "sqlite")
+{{ end }}
+	var r *execResult
+	var failed error
+	for _, group := range groups {
+		prefix := "INSERT INTO `{{.TableName}}` (`" + strings.Join(group.keys, "`,`") + "`) VALUES "
+		for start := 0; start < len(group.rows) && failed == nil; {
+			if dao.contextDone() {
+				failed = dao.ctx.Err()
+				break
+			}
+			end := start
+			args := make([]interface{}, 0)
+			for end < len(group.rows) && (end == start || len(args)+len(group.values[end]) <= limit) {
+				args = append(args, group.values[end]...)
+				end++
+			}
+			r = tx.Exec(prefix+strings.Join(group.rows[start:end], ","), args...)
+			if r.Error != nil {
+				failed = r.Error
+				break
+			}
+{{ if .IsAutoId }}
+			if (isMysql || isSqlite) && !u.StringIn(group.keys, "{{.AutoIdColumn}}") {
+				// MySQL 返回第一行的自增ID，SQLite 返回最后一行的自增ID，同一条语句中的自增ID是连续的
+				firstId := r.Id()
+				if isSqlite {
+					firstId -= int64(end - start - 1)
+				}
+				for j := start; j < end; j++ {
+					ids[group.indexes[j]] = {{.AutoIdFieldType}}(firstId + int64(j-start))
+				}
+			}
+{{ end }}
+			start = end
+		}
+		if failed != nil {
+			break
+		}
+	}
+
+	if dao.tx == nil {
+		if failed != nil {
+			_ = tx.Rollback()
+		} else {
+			failed = tx.Commit()
+		}
+	}
+	dao.lastError = failed
+	if failed != nil {
+		{{ if .ReturnError }}
+		if r != nil && r.Error == failed {
+			err = makeError(r, false)
+		} else {
+			err = failed
+		}
+		{{ end }}
+		return
+	}
+{{ if or .HasVersion .IsAutoId }}
+	for i, item := range items {
+{{ if .HasVersion }}
+		itemVersion := version + uint64(i)
+		item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}itemVersion
+{{ end }}
+{{ if .IsAutoId }}
+		if id, exists := ids[i]; exists {
+			item.{{.AutoIdField}} = &id
+		}
+{{ end }}
+	}
+{{ end }}
+	{{ if not .ReturnError }}ok = true{{ end }}
+	return
+}
+
+func (dao *{{.FixedTableName}}Dao) Replace(item *{{.FixedTableName}}Item) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
     data := make(map[string]interface{})
     u.Convert(item, data)
 
@@ -226,35 +569,170 @@ func (dao *{{.FixedTableName}}Dao) Replace(item *{{.FixedTableName}}Item) bool {
     delete(data, "{{$field}}")
 {{ end }}
 
+{{ if .EnumFields }}
+	if enumErr := dao.checkEnums(data); enumErr != nil {
+		dao.lastError = enumErr
+		{{ if .ReturnError }}err = enumErr{{ end }}
+		return
+	}
+{{ end }}
 {{ if .HasVersion }}
-	version := dao.getVersion()
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
 	data["{{.VersionField}}"] = version
 {{ end }}
 
-	var r *db.ExecResult
-	if dao.tx != nil {
-		r = dao.tx.Replace("{{.TableName}}", data)
-	} else {
-		r = dao.conn.Replace("{{.TableName}}", data)
-	}
+	sql, values := dao.conn.MakeInsertSql("{{.TableName}}", data, true)
+	r := dao.runner().Exec(sql, values...)
+	dao.lastError = r.Error
 
 {{ if .HasVersion }}
 	dao.commitVersion(version)
-	return r.Error == nil && r.Changes() > 0, version
+{{ end }}
+{{ if .ReturnError }}
+	err = makeError(r, false)
 {{ else }}
-	return r.Error == nil && r.Changes() > 0
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
+	return
 }
 
 {{ if .PrimaryKey }}
+// Upsert 插入数据，主键已存在时修改 updateColumns 中的字段，不像 Replace 一样删除原来的数据（自增ID、ct 字段不变）
+// updateColumns 为空时修改主键和自动生成的字段以外的所有字段{{ if .HasVersion }}，总是会分配新的版本{{ end }}
+// MySQL 使用 ON DUPLICATE KEY UPDATE（任何唯一索引冲突都会修改），SQLite、PostgreSQL 使用 ON CONFLICT
+func (dao *{{.FixedTableName}}Dao) Upsert(item *{{.FixedTableName}}Item, updateColumns ...string) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	return dao.upsert(item, []string{ {{.PrimaryKey.Columns}} }, updateColumns)
+}
+{{ end }}
+
+{{ range .UniqueKeys }}
+// UpsertBy{{.Name}} 与 Upsert 相同，SQLite、PostgreSQL 使用唯一索引 {{.Columns}} 判断冲突
+func (dao *{{$.FixedTableName}}Dao) UpsertBy{{.Name}}(item *{{$.FixedTableName}}Item, updateColumns ...string) ({{ if $.ReturnError }}{{ if $.HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if $.HasVersion }}, version uint64{{ end }}{{ end }}) {
+	return dao.upsert(item, []string{ {{.Columns}} }, updateColumns)
+}
+{{ end }}
+
+func (dao *{{.FixedTableName}}Dao) upsert(item *{{.FixedTableName}}Item, conflictColumns []string, updateColumns []string) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	isMysql := strings.HasPrefix(dao.conn.Config.Type, "mysql")
+	if !isMysql && !strings.HasPrefix(dao.conn.Config.Type, "sqlite") && !strings.HasPrefix(dao.conn.Config.Type, "postgres") {
+		dao.lastError = fmt.Errorf("upsert is not supported by %s", dao.conn.Config.Type)
+		{{ if .ReturnError }}err = dao.lastError{{ end }}
+		return
+	}
+	columns := []string{ {{ range .Fields }}"{{.Column}}", {{ end }} }
+	for _, column := range updateColumns {
+		if !u.StringIn(columns, column) {
+			dao.lastError = fmt.Errorf("unknown column %s in {{.TableName}}", column)
+			{{ if .ReturnError }}err = dao.lastError{{ end }}
+			return
+		}
+	}
+
+    data := make(map[string]interface{})
+    u.Convert(item, data)
+{{ range $index, $field := .AutoGenerated }}
+    if data["{{$field}}"] == nil {
+        delete(data, "{{$field}}")
+    }
+{{ end }}
+{{ range $index, $field := .AutoGeneratedOnUpdate }}
+    delete(data, "{{$field}}")
+{{ end }}
+{{ if .EnumFields }}
+	if enumErr := dao.checkEnums(data); enumErr != nil {
+		dao.lastError = enumErr
+		{{ if .ReturnError }}err = enumErr{{ end }}
+		return
+	}
+{{ end }}
+{{ if .HasVersion }}
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
+	data["{{.VersionField}}"] = version
+{{ end }}
+
+	keys, vars, values := db.MakeKeysVarsValues(data)
+	if len(updateColumns) == 0 {
+		// 默认修改插入的所有字段，冲突的字段和自动生成的字段除外
+		skip := map[string]bool{ {{ range .AutoGenerated }}"{{.}}": true, {{ end }} }
+		for _, column := range conflictColumns {
+			skip[column] = true
+		}
+		for _, key := range keys {
+			if !skip[key] {
+				updateColumns = append(updateColumns, key)
+			}
+		}
+	}
+{{ if .HasVersion }}
+	if !u.StringIn(updateColumns, "{{.VersionField}}") {
+		updateColumns = append(updateColumns, "{{.VersionField}}")
+	}
+{{ end }}
+	q := dao.conn.QuoteTag
+	sets := make([]string, len(updateColumns))
+	for i, column := range updateColumns {
+		if isMysql {
+			sets[i] = q + column + q + "=VALUES(" + q + column + q + ")"
+		} else {
+			sets[i] = q + column + q + "=excluded." + q + column + q
+		}
+	}
+
+	sql := "INSERT INTO " + q + "{{.TableName}}" + q + " (" + q + strings.Join(keys, q+","+q) + q + ") VALUES (" + strings.Join(vars, ",") + ")"
+	if isMysql {
+		if len(sets) == 0 {
+			// 没有要修改的字段时保持原数据不变
+			sets = append(sets, q+conflictColumns[0]+q+"="+q+conflictColumns[0]+q)
+		}
+		sql += " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+	} else {
+		sql += " ON CONFLICT (" + q + strings.Join(conflictColumns, q+","+q) + q + ")"
+		if len(sets) == 0 {
+			sql += " DO NOTHING"
+		} else {
+			sql += " DO UPDATE SET " + strings.Join(sets, ",")
+		}
+	}
+
+	r := dao.runner().Exec(sql, values...)
+	dao.lastError = r.Error
 
 {{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) Update(data interface{}, {{.PrimaryKey.Params}}) (bool, uint64) {
+	dao.commitVersion(version)
+{{ end }}
+{{ if .ReturnError }}
+	err = makeError(r, false)
 {{ else }}
-func (dao *{{.FixedTableName}}Dao) Update(data interface{}, {{.PrimaryKey.Params}}) bool {
+	ok = r.Error == nil
 {{ end }}
-	updateData, ok := data.(map[string]interface{})
-	if !ok {
+	return
+}
+
+{{ if .PrimaryKey }}
+
+func (dao *{{.FixedTableName}}Dao) Update(data interface{}, {{.PrimaryKey.Params}}) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	updateData, isMap := data.(map[string]interface{})
+	if !isMap {
 		updateData = make(map[string]interface{})
 		u.Convert(data, updateData)
 	}
@@ -267,180 +745,368 @@ func (dao *{{.FixedTableName}}Dao) Update(data interface{}, {{.PrimaryKey.Params
 {{ range $index, $field := .AutoGeneratedOnUpdate }}
     delete(updateData, "{{$field}}")
 {{ end }}
-
+{{ if .EnumFields }}
+	if enumErr := dao.checkEnums(updateData); enumErr != nil {
+		dao.lastError = enumErr
+		{{ if .ReturnError }}err = enumErr{{ end }}
+		return
+	}
+{{ end }}
 {{ if .HasVersion }}
-	version := dao.getVersion()
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
 	updateData["{{.VersionField}}"] = version
 {{ end }}
-	var r *db.ExecResult
-	if dao.tx != nil {
-		r = dao.tx.Update("{{.TableName}}", updateData, "{{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Update("{{.TableName}}", updateData, "{{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	}
+	sql, values := dao.conn.MakeUpdateSql("{{.TableName}}", updateData, "{{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
+	r := dao.runner().Exec(sql, values...)
 	dao.lastError = r.Error
 
 {{ if .HasVersion }}
 	dao.commitVersion(version)
-	return r.Error == nil && r.Changes() > 0, version
+{{ end }}
+{{ if .ReturnError }}
+	err = makeError(r, true)
 {{ else }}
-	return r.Error == nil && r.Changes() > 0
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
+	return
 }
 
-{{ if .InvalidSet }}
 {{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) Enable({{.PrimaryKey.Params}}) (bool, uint64) {
+// UpdateWithVersion 乐观锁，只在数据库中的 {{.VersionField}} 与 oldVersion 相同时修改，没有修改任何数据时为版本冲突 ErrVersionConflict
+func (dao *{{.FixedTableName}}Dao) UpdateWithVersion(data interface{}, {{.PrimaryKey.Params}}, oldVersion uint64) ({{ if .ReturnError }}version uint64, err error{{ else }}ok bool, version uint64{{ end }}) {
+	updateData := make(map[string]interface{})
+	u.Convert(data, updateData)
+{{ range $index, $field := .AutoGenerated }}
+    if updateData["{{$field}}"] == nil {
+        delete(updateData, "{{$field}}")
+    }
+{{ end }}
+{{ range $index, $field := .AutoGeneratedOnUpdate }}
+    delete(updateData, "{{$field}}")
+{{ end }}
+	delete(updateData, "{{.VersionField}}")
+{{ if .ReturnError }}
+	version, err = dao.UpdateBy(updateData, "{{.PrimaryKey.Where}} AND `{{.VersionField}}`=?", {{.PrimaryKey.Args}}, oldVersion)
+	if err == ErrNotFound {
+		err = ErrVersionConflict
+		dao.lastError = err
+	}
 {{ else }}
-func (dao *{{.FixedTableName}}Dao) Enable({{.PrimaryKey.Params}}) bool {
+	ok, version = dao.UpdateBy(updateData, "{{.PrimaryKey.Where}} AND `{{.VersionField}}`=?", {{.PrimaryKey.Args}}, oldVersion)
+	if !ok && dao.lastError == nil {
+		dao.lastError = ErrVersionConflict
+	}
 {{ end }}
-	var r *db.ExecResult
+	return
+}
+{{ end }}
+
+{{ if .InvalidSet }}
+func (dao *{{.FixedTableName}}Dao) Enable({{.PrimaryKey.Params}}) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	var r *execResult
 {{ if .HasVersion }}
-	version := dao.getVersion()
-	if dao.tx != nil {
-		r = dao.tx.Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
 	}
+	r = dao.runner().Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
 	dao.commitVersion(version)
 {{ else }}
-	if dao.tx != nil {
-		r = dao.tx.Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	}
+	r = dao.runner().Exec("UPDATE `{{.TableName}}` SET {{.ValidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
 {{ end }}
 	dao.lastError = r.Error
-{{ if .HasVersion }}
-	return r.Error == nil && r.Changes() > 0, version
+{{ if .ReturnError }}
+	err = makeError(r, true)
 {{ else }}
-	return r.Error == nil && r.Changes() > 0
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
+	return
 }
 
+func (dao *{{.FixedTableName}}Dao) Disable({{.PrimaryKey.Params}}) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	var r *execResult
 {{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) Disable({{.PrimaryKey.Params}}) (bool, uint64) {
-{{ else }}
-func (dao *{{.FixedTableName}}Dao) Disable({{.PrimaryKey.Params}}) bool {
-{{ end }}
-	var r *db.ExecResult
-{{ if .HasVersion }}
-	version := dao.getVersion()
-	if dao.tx != nil {
-		r = dao.tx.Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
 	}
+	r = dao.runner().Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}}, `{{.VersionField}}`=? WHERE {{.PrimaryKey.Where}}", version, {{.PrimaryKey.Args}})
 	dao.commitVersion(version)
 {{ else }}
-	if dao.tx != nil {
-		r = dao.tx.Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	}
+	r = dao.runner().Exec("UPDATE `{{.TableName}}` SET {{.InvalidSet}} WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
 {{ end }}
 	dao.lastError = r.Error
-{{ if .HasVersion }}
-	return r.Error == nil && r.Changes() > 0, version
+{{ if .ReturnError }}
+	err = makeError(r, true)
 {{ else }}
-	return r.Error == nil && r.Changes() > 0
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
+	return
 }
 {{ end }}
 
-func (dao *{{.FixedTableName}}Dao) Delete({{.PrimaryKey.Params}}) bool {
-	var r *db.ExecResult
-	if dao.tx != nil {
-		r = dao.tx.Exec("DELETE FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
-	} else {
-		r = dao.conn.Exec("DELETE FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
+func (dao *{{.FixedTableName}}Dao) Delete({{.PrimaryKey.Params}}) {{ if .ReturnError }}error{{ else }}bool{{ end }} {
+	if dao.contextDone() {
+		return {{ if .ReturnError }}dao.ctx.Err(){{ else }}false{{ end }}
 	}
+	var r *execResult
+	r = dao.runner().Exec("DELETE FROM `{{.TableName}}` WHERE {{.PrimaryKey.Where}}", {{.PrimaryKey.Args}})
 	dao.lastError = r.Error
+{{ if .ReturnError }}
+	return makeError(r, true)
+{{ else }}
 	return r.Error == nil && r.Changes() > 0
+{{ end }}
 }
 
+{{ if .PrimaryKey.Column }}
+// DeleteMany 按主键批量删除，按 MaxPlaceholders 分批执行，不在事务中时使用新的事务，返回删除的数量，没有删除任何数据时不是错误
+func (dao *{{.FixedTableName}}Dao) DeleteMany(ids []{{.PrimaryKey.Type}}) (n int64, {{ if .ReturnError }}err error{{ else }}ok bool{{ end }}) {
+	if len(ids) == 0 {
+		{{ if not .ReturnError }}ok = true{{ end }}
+		return
+	}
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	tx := dao.runner()
+	if dao.tx == nil {
+		var beginErr error
+		if tx, beginErr = tx.Begin(); beginErr != nil {
+			dao.lastError = beginErr
+			{{ if .ReturnError }}err = beginErr{{ end }}
+			return
+		}
+	}
+	limit := maxPlaceholders(dao.conn)
+	var failed error
+	for start := 0; start < len(ids); start += limit {
+		if dao.contextDone() {
+			failed = dao.ctx.Err()
+			break
+		}
+		end := start + limit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, end-start)
+		for i, id := range ids[start:end] {
+			args[i] = id
+		}
+		r := tx.Exec("DELETE FROM `{{.TableName}}` WHERE `{{.PrimaryKey.Column}}` IN "+dao.conn.InKeys(len(args)), args...)
+		if r.Error != nil {
+			failed = r.Error
+			break
+		}
+		n += r.Changes()
+	}
+	if dao.tx == nil {
+		if failed != nil {
+			_ = tx.Rollback()
+		} else {
+			failed = tx.Commit()
+		}
+	}
+	dao.lastError = failed
+	if failed != nil {
+		n = 0
+		{{ if .ReturnError }}err = failed{{ end }}
+		return
+	}
+	{{ if not .ReturnError }}ok = true{{ end }}
+	return
+}
 {{ end }}
 
-{{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) UpdateBy(data interface{}, where string, args ...interface{}) (bool, uint64) {
-{{ else }}
-func (dao *{{.FixedTableName}}Dao) UpdateBy(data interface{}, where string, args ...interface{}) bool {
 {{ end }}
-	updateData, ok := data.(map[string]interface{})
-	if !ok {
+
+func (dao *{{.FixedTableName}}Dao) UpdateBy(data interface{}, where string, args ...interface{}) ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
+	if dao.contextDone() {
+		{{ if .ReturnError }}err = dao.ctx.Err(){{ end }}
+		return
+	}
+	updateData, isMap := data.(map[string]interface{})
+	if !isMap {
 		updateData = make(map[string]interface{})
 		u.Convert(data, updateData)
 	}
+{{ if .EnumFields }}
+	if enumErr := dao.checkEnums(updateData); enumErr != nil {
+		dao.lastError = enumErr
+		{{ if .ReturnError }}err = enumErr{{ end }}
+		return
+	}
+{{ end }}
 {{ if .HasVersion }}
-	version := dao.getVersion()
+	var versionErr error
+	version, versionErr = dao.getVersion()
+	if versionErr != nil {
+		dao.lastError = versionErr
+		{{ if .ReturnError }}err = versionErr{{ end }}
+		return
+	}
 	updateData["{{.VersionField}}"] = version
 {{ end }}
-	var r *db.ExecResult
-	if dao.tx != nil {
-		r = dao.tx.Update("{{.TableName}}", updateData, where, args...)
-	} else {
-		r = dao.conn.Update("{{.TableName}}", updateData, where, args...)
-	}
+	sql, values := dao.conn.MakeUpdateSql("{{.TableName}}", updateData, where, args...)
+	r := dao.runner().Exec(sql, values...)
 	dao.lastError = r.Error
 {{ if .HasVersion }}
 	dao.commitVersion(version)
-	return r.Error == nil && r.Changes() > 0, version
+{{ end }}
+{{ if .ReturnError }}
+	err = makeError(r, true)
 {{ else }}
-	return r.Error == nil && r.Changes() > 0
+	ok = r.Error == nil && r.Changes() > 0
 {{ end }}
+	return
 }
 
-{{ if .HasVersion }}
-func (dao *{{.FixedTableName}}Dao) getVersion() uint64 {
-	var version uint64 = 0
-	if dao.rd != nil {
-		version = uint64(dao.rd.INCR("_DATA_VERSION_{{.TableName}}"))
-		if version > 1 {
-			// 设置使用中的标记
-			dao.rd.SETEX("_DATA_VERSION_DOING_{{.TableName}}_"+u.String(version), 10, true)
-			return version
+{{ if .EnumFields }}
+// checkEnums 写入前检查枚举和集合的值，避免 MySQL 截断为空字符串
+func (dao *{{.FixedTableName}}Dao) checkEnums(data map[string]interface{}) error {
+{{ range .EnumFields }}
+	for _, k := range []string{"{{.Column}}", "{{.Name}}"} {
+		if v := u.String(data[k]); v != "" && !{{.Type}}(v).IsValid() {
+			return fmt.Errorf("invalid {{.Column}} value: %s", v)
 		}
+	}
+{{ end }}
+	return nil
+}
+{{ end }}
 
-		// 不存在redis数据时，使用数据库中的版本重建
-		dao.rd.DEL("_DATA_VERSION_{{.TableName}}")
-		version = 0
-	} else {
-		dao.logger.Warning("use version but not configured redis", "dao", "{{.DBName}}", "table", "{{.TableName}}")
+{{ if .HasVersion }}
+// getVersion 使用 VersionAllocator 分配新的版本号
+func (dao *{{.FixedTableName}}Dao) getVersion() (uint64, error) {
+	if dao.contextDone() {
+		return 0, dao.ctx.Err()
+	}
+	if dao.versions == nil {
+		return 0, fmt.Errorf("no version allocator for {{.TableName}}")
 	}
+	return dao.versions.Next(dao.ctx, dao.tx, "{{.TableName}}", dao.maxVersionInTable)
+}
 
-	var r *db.QueryResult
-	if dao.tx != nil {
-		r = dao.conn.Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`")
-	} else {
-		r = dao.conn.Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`")
+// getVersionRange 分配连续的 n 个版本号，返回第一个，写入完成后使用第一个版本调用 commitVersion
+func (dao *{{.FixedTableName}}Dao) getVersionRange(n uint64) (uint64, error) {
+	if dao.contextDone() {
+		return 0, dao.ctx.Err()
 	}
-	maxVersion := uint64(r.IntOnR1C1())
-	version = maxVersion+1
-	if dao.rd != nil {
-		// redis中信息丢失时从数据库中更新
-		dao.rd.MSET("_DATA_VERSION_{{.TableName}}", version, "_DATA_MAX_VERSION_{{.TableName}}", version)
-		// 设置使用中的标记
-		dao.rd.SETEX("_DATA_VERSION_DOING_{{.TableName}}_"+u.String(version), 10, true)
+	if dao.versions == nil {
+		return 0, fmt.Errorf("no version allocator for {{.TableName}}")
 	}
-	return version
+	return dao.versions.NextRange(dao.ctx, dao.tx, "{{.TableName}}", n, dao.maxVersionInTable)
+}
+
+// maxVersionInTable 在事务中时使用事务读取，能读到事务中未提交的写入
+func (dao *{{.FixedTableName}}Dao) maxVersionInTable() uint64 {
+	return uint64(dao.runner().Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`").IntOnR1C1())
 }
 
+// commitVersion 写入完成后总是需要调用，ctx 已取消时也调用，由 VersionAllocator 清除正在写入的版本
 func (dao *{{.FixedTableName}}Dao) commitVersion(version uint64) {
-	if dao.rd != nil {
-		// 先存储当前版本完成标记，然后检查所有新版本是否完成以设置MAX_VERSION
-		dao.rd.DEL("_DATA_VERSION_DOING_{{.TableName}}_"+u.String(version))
-		seqVersion := dao.rd.GET("_DATA_VERSION_{{.TableName}}").Uint64()
-		currentMaxVersion := dao.rd.GET("_DATA_MAX_VERSION_{{.TableName}}").Uint64()
-		for i := currentMaxVersion; i <= seqVersion; i++ {
-			if dao.rd.EXISTS("_DATA_VERSION_DOING_{{.TableName}}_" + u.String(i)) {
-				// 遇到仍在处理的版本，跳过更新MAX_VERSION，确保用户获取数据是有序的
-				break
+	if dao.versions != nil {
+		dao.versions.Commit(dao.ctx, "{{.TableName}}", version)
+	}
+}
+
+// {{.FixedTableName}}Change Sync 读取到的一条变化，Deleted 为 true 表示数据已被 Disable（墓碑），需要从缓存或索引中删除
+type {{.FixedTableName}}Change struct {
+	Item    *{{.FixedTableName}}Item
+	Deleted bool
+	Version uint64
+}
+
+// Sync 按版本顺序读取 fromVersion 之后的变化，每批最多 batch 条（0 为 1000）交给 handler 处理
+// 只读取到 VersionAllocator.Max 为止，不会跳过正在写入的版本，返回值为已处理完的版本，作为下次 Sync 的 fromVersion
+// fromVersion 为 0 时是全量同步，不返回已删除的数据，handler 返回 error 时停止，返回的版本不包含这一批
+func (dao *{{.FixedTableName}}Dao) Sync(fromVersion uint64, batch uint, handler func(changes []{{.FixedTableName}}Change) error) (uint64, error) {
+	if batch == 0 {
+		batch = 1000
+	}
+	cursor := fromVersion
+	var maxVersion uint64 = 0
+	for {
+		query, max := dao.NewQuery().QueryByVersion(cursor, maxVersion, batch{{ if .ValidSet }}, false{{ end }})
+		if err := query.LastError(); err != nil {
+			return cursor, err
+		}
+		// 使用第一次读取时的最大版本，避免同步期间不断写入时无法结束
+		maxVersion = max
+		list := query.List()
+		if len(list) == 0 {
+			break
+		}
+
+		full := uint(len(list)) >= batch
+		if full {
+			// UpdateBy 修改的多条数据使用同一个版本，最后一个版本可能没有读完，留到下一批
+			last := list[len(list)-1].{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }}
+			n := len(list)
+			for n > 0 && list[n-1].{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }} == last {
+				n--
+			}
+			if n > 0 {
+				list = list[:n]
 			} else {
-				// 更新MAX_VERSION，用户可以使用该版本
-				dao.rd.SET("_DATA_MAX_VERSION_{{.TableName}}", i)
+				// 整批都是同一个版本，一次读取这个版本的全部数据
+				query, _ = dao.NewQuery().QueryByVersion(last-1, last, 0{{ if .ValidSet }}, false{{ end }})
+				if err := query.LastError(); err != nil {
+					return cursor, err
+				}
+				list = query.List()
+			}
+		}
+
+		changes := make([]{{.FixedTableName}}Change, 0, len(list))
+		for i := range list {
+			item := &list[i]
+			deleted := {{ if .ValidCheck }}!({{.ValidCheck}}){{ else }}false{{ end }}
+			if deleted && fromVersion == 0 {
+				// 全量同步不需要墓碑
+				continue
 			}
+			changes = append(changes, {{.FixedTableName}}Change{
+				Item:    item,
+				Deleted: deleted,
+				Version: item.{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }},
+			})
 		}
+		if len(changes) > 0 {
+			if err := handler(changes); err != nil {
+				return cursor, err
+			}
+		}
+		cursor = list[len(list)-1].{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }}
+		if !full {
+			break
+		}
+	}
+	if maxVersion > cursor {
+		cursor = maxVersion
 	}
+	return cursor, nil
 }
 {{ end }}
 
@@ -461,7 +1127,7 @@ func (dao *{{.FixedTableName}}Dao) NewQuery() *{{.FixedTableName}}Query {
 
 type {{.FixedTableName}}Query struct {
 	dao            *{{.FixedTableName}}Dao
-	result         *db.QueryResult
+	result         *queryResult
 	validWhere     string
 	sql            string
 	fields         string
@@ -471,6 +1137,10 @@ type {{.FixedTableName}}Query struct {
 	args           []interface{}
 	leftJoins      []string
 	leftJoinArgs   []interface{}
+	mock           *{{.FixedTableName}}DaoMock // {{.FixedTableName}}DaoMock 返回的查询，使用 Mock 的 Rows、RowsFunc 作为结果
+	mockRows       []{{.FixedTableName}}Item
+	mockSql        string
+	mockArgs       []interface{}
 }
 
 func (query *{{.FixedTableName}}Query) parseFields(fields, table string) string {
@@ -697,34 +1367,86 @@ func (query *{{$.FixedTableName}}Query) Or{{.Name}}({{.Params}}) *{{$.FixedTable
 }
 {{ end }}
 
+{{range .BelongsTo}}
+func (query *{{$.FixedTableName}}Query) {{.QueryBy}}(item *{{.RefTable}}Item) *{{$.FixedTableName}}Query {
+	query.Where("`{{.Column}}`=?", item.{{.RefField}})
+	return query
+}
+{{ end }}
+
+// WithContext 使用 ctx 执行查询，读取到的 Item 也使用 ctx 保存，与 Dao 的 WithContext 相同
+func (query *{{.FixedTableName}}Query) WithContext(ctx context.Context) *{{.FixedTableName}}Query {
+	query.dao = query.dao.withContext(ctx)
+	return query
+}
+
+// execute ctx 已取消或超时时不执行，query.result 为 nil，LastError 返回 ctx.Err()
+func (query *{{.FixedTableName}}Query) execute(sql string, args []interface{}) {
+	query.result = nil
+	query.mockRows = nil
+	if query.dao.contextDone() {
+		return
+	}
+	if query.mock != nil {
+		query.mockSql, query.mockArgs = sql, args
+		query.mockRows = query.mock.queryRows(sql, args)
+		return
+	}
+	if query.dao.tx == nil && query.dao.conn == nil {
+		// 没有数据库连接的 Dao（如 &{{.FixedTableName}}Dao{}）查询结果为空
+		return
+	}
+	query.result = query.dao.runner().Query(sql, args...)
+}
+
+// executed 已经执行过查询，Mock 的查询结果保存在 mockRows 中
+func (query *{{.FixedTableName}}Query) executed() bool {
+	return query.result != nil || query.mockRows != nil
+}
+
+// resultTo 将查询结果写入 out，Mock 的查询使用 u.Convert 转换
+func (query *{{.FixedTableName}}Query) resultTo(out interface{}) {
+	if query.mockRows != nil {
+		if list, ok := out.(*[]{{.FixedTableName}}Item); ok {
+			*list = append(*list, query.mockRows...)
+		} else {
+			u.Convert(query.mockRows, out)
+		}
+	} else if query.result != nil {
+		_ = query.result.To(out)
+	}
+}
+
+// itemDao 读取到的 Item 使用的 Dao，Mock 的查询返回的 Item 属于 Mock
+func (query *{{.FixedTableName}}Query) itemDao() {{.FixedTableName}}DaoInterface {
+	if query.mock != nil {
+		return query.mock
+	}
+	return query.dao
+}
+
 func (query *{{.FixedTableName}}Query) Query() *{{.FixedTableName}}Query {
 	sql, args := query.parse("")
-	if query.dao.tx != nil {
-		query.result = query.dao.tx.Query(sql, args...)
-	} else {
-		query.result = query.dao.conn.Query(sql, args...)
-	}
+	query.execute(sql, args)
 	return query
 }
 
 {{ if .ValidSet }}
 func (query *{{.FixedTableName}}Query) QueryWithValid() *{{.FixedTableName}}Query {
 	sql, args := query.parse("ALL")
-	if query.dao.tx != nil {
-		query.result = query.dao.tx.Query(sql, args...)
-	} else {
-		query.result = query.dao.conn.Query(sql, args...)
-	}
+	query.execute(sql, args)
 	return query
 }
 {{ end }}
 
 func (query *{{.FixedTableName}}Query) Count() int {
 	sql, args := query.parse("COUNT")
-	if query.dao.tx != nil {
-		query.result = query.dao.tx.Query(sql, args...)
-	} else {
-		query.result = query.dao.conn.Query(sql, args...)
+	query.execute(sql, args)
+	if query.mockRows != nil {
+		return len(query.mockRows)
+	}
+	if query.result == nil {
+		return 0
 	}
 	return int(query.result.IntOnR1C1())
 }
@@ -732,10 +1454,12 @@ func (query *{{.FixedTableName}}Query) Count() int {
 {{ if .ValidSet }}
 func (query *{{.FixedTableName}}Query) CountAll() int {
 	sql, args := query.parse("COUNT_ALL")
-	if query.dao.tx != nil {
-		query.result = query.dao.tx.Query(sql, args...)
-	} else {
-		query.result = query.dao.conn.Query(sql, args...)
+	query.execute(sql, args)
+	if query.mockRows != nil {
+		return len(query.mockRows)
+	}
+	if query.result == nil {
+		return 0
 	}
 	return int(query.result.IntOnR1C1())
 }
@@ -767,43 +1491,42 @@ func (query *{{.FixedTableName}}Query) QueryByVersion(minVersion, maxVersion uin
     parseTag := "VERSION"
 {{ end }}
 
-	if maxVersion == 0 {
-		if query.dao.rd != nil {
-			maxVersion = query.dao.rd.GET("_DATA_MAX_VERSION_{{.TableName}}").Uint64()
-		} else {
-			query.dao.logger.Warning("use version but not configured redis", "dao", "{{.DBName}}", "table", "{{.TableName}}")
+	if query.dao.contextDone() {
+		query.result = nil
+		return query, maxVersion
+	}
+	if maxVersion == 0 && (query.dao.tx != nil || query.dao.conn != nil) {
+		if query.dao.versions != nil {
+			maxVersion = query.dao.versions.Max("{{.TableName}}")
 		}
 		if maxVersion == 0 {
-			if query.dao.tx != nil {
-				maxVersion = uint64(query.dao.tx.Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`").IntOnR1C1())
-			} else {
-				maxVersion = uint64(query.dao.conn.Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`").IntOnR1C1())
-			}
+			maxVersion = uint64(query.dao.runner().Query("SELECT MAX(`{{.VersionField}}`) FROM `{{.TableName}}`").IntOnR1C1())
 		}
 	}
 
-	query.And("`version` BETWEEN ? AND ?", minVersion+1, maxVersion )
+	query.And("`{{.VersionField}}` BETWEEN ? AND ?", minVersion+1, maxVersion )
 	if limit > 0 {
     	query.OrderBy("`{{.VersionField}}`")
     	query.Limit(0, limit)
 	}
 
 	sql, args := query.parse(parseTag)
-	if query.dao.tx != nil {
-		query.result = query.dao.tx.Query(sql, args...)
-	} else {
-		query.result = query.dao.conn.Query(sql, args...)
-	}
+	query.execute(sql, args)
 
 	return query, maxVersion
 }
 {{ end }}
 
+// Result ctx 已取消或超时时返回 nil，Mock 的查询总是返回 nil
+// 使用 WithContext 时查询通过 database/sql 执行并已读取所有行，没有 db.QueryResult，也返回 nil，需要使用 List 等方法读取
 func (query *{{.FixedTableName}}Query) Result() *db.QueryResult {
-	if query.result == nil {
+	if !query.executed() {
 		query.Query()
 	}
-	return query.result
+	if query.result == nil {
+		return nil
+	}
+	return query.result.r
 }
 
 func (query *{{.FixedTableName}}Query) Complete() {
@@ -830,7 +1553,7 @@ func (query *{{.FixedTableName}}Query) ToWithoutPrefix(out interface{}, ignorePr
 }
 
 func (query *{{.FixedTableName}}Query) to(out interface{}, ignorePrefix string) {
-	if query.result == nil {
+	if !query.executed() {
 		query.Query()
 	}
 
@@ -853,11 +1576,11 @@ func (query *{{.FixedTableName}}Query) to(out interface{}, ignorePrefix string)
 			return
 		}
 	}
-	_ = query.result.To(out)
+	query.resultTo(out)
 }
 
 func (query *{{.FixedTableName}}Query) ToByFields(out interface{}, fields ...string) {
-	if query.result == nil {
+	if !query.executed() {
 		query.Query()
 	}
 
@@ -881,18 +1604,18 @@ func (query *{{.FixedTableName}}Query) ToByFields(out interface{}, fields ...str
 			}
 		}
 	}
-	_ = query.result.To(out)
+	query.resultTo(out)
 }
 
 func (query *{{.FixedTableName}}Query) List() []{{.FixedTableName}}Item {
-	if query.result == nil {
+	if !query.executed() {
 		query.Query()
 	}
 
 	list := make([]{{.FixedTableName}}Item, 0)
-	_ = query.result.To(&list)
+	query.resultTo(&list)
 	for i := range list {
-		list[i].dao = query.dao
+		list[i].dao = query.itemDao()
 		list[i].changes = map[string]any{}
 	}
 	return list
@@ -907,13 +1630,13 @@ func (query *{{.FixedTableName}}Query) ListBy(fields ...string) map[string]*{{.F
 {{ end }}
 	}
 
-	if query.result == nil {
+	if !query.executed() {
 		query.Query()
 	}
 
 	out := make(map[string]*{{.FixedTableName}}Item)
 	list := make([]{{.FixedTableName}}Item, 0)
-	_ = query.result.To(&list)
+	query.resultTo(&list)
 	fieldIndexes := make([]int, len(fields))
 	for i, item := range list {
 		itemValue := reflect.ValueOf(item)
@@ -946,13 +1669,138 @@ func (query *{{.FixedTableName}}Query) ListBy(fields ...string) map[string]*{{.F
 		out[key] = &list[i]
 	}
 	for k := range out {
-		out[k].dao = query.dao
+		out[k].dao = query.itemDao()
 		out[k].changes = map[string]any{}
 	}
 	return out
 }
 
+// Each 逐行读取查询结果并调用 fn，不会将所有数据读取到内存中，fn 返回 error 时停止读取并返回这个 error
+// 不在事务中时从连接池中取出一个连接读取，读取完、出错或停止后释放；在事务中时按 EachPageSize 分页读取，fn 中可以继续使用事务
+// 分页时没有 OrderBy 按主键排序，使用 OrderBy 时需要包含唯一的字段，否则可能重复或遗漏
+func (query *{{.FixedTableName}}Query) Each(fn func(item *{{.FixedTableName}}Item) error) error {
+	if query.dao.contextDone() {
+		return query.dao.ctx.Err()
+	}
+	if query.mock != nil {
+		list := query.Query().List()
+		for i := range list {
+			if err := fn(&list[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if query.dao.tx == nil && query.dao.conn == nil {
+		return nil
+	}
+	sql, args := query.parse("")
+	if query.dao.tx != nil {
+		return query.eachByPage(sql, args, fn)
+	}
+
+	rows, err := query.dao.conn.GetOriginDB().QueryContext(query.dao.Context(), sql, args...)
+	if err != nil {
+		query.dao.lastError = err
+		return err
+	}
+	defer rows.Close()
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		query.dao.lastError = err
+		return err
+	}
+	values := make([]any, len(colTypes))
+	scanArgs := make([]any, len(colTypes))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	for rows.Next() {
+		if query.dao.contextDone() {
+			return query.dao.ctx.Err()
+		}
+		if err = rows.Scan(scanArgs...); err != nil {
+			query.dao.lastError = err
+			return err
+		}
+		item := &{{.FixedTableName}}Item{dao: query.dao, changes: map[string]any{}}
+		u.Convert(makeRowData(colTypes, values), item)
+		if err = fn(item); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		query.dao.lastError = err
+	}
+	return err
+}
+
+func (query *{{.FixedTableName}}Query) eachByPage(sql string, args []interface{}, fn func(item *{{.FixedTableName}}Item) error) error {
+	// 已经使用 Limit 的查询一次读取
+	upperSql := strings.ToUpper(sql)
+	paged := EachPageSize > 0 && !strings.Contains(upperSql, " LIMIT ")
+{{- if .PrimaryKey }}
+	if paged && !strings.Contains(upperSql, " ORDER BY ") && !strings.Contains(upperSql, " GROUP BY ") {
+		// 没有顺序时每页的结果不确定，按主键排序
+		sql += " ORDER BY `{{.TableName}}`.`" + strings.Join([]string{ {{.PrimaryKey.Columns}} }, "`, `{{.TableName}}`.`") + "`"
+	}
+{{- else }}
+	if !strings.Contains(upperSql, " ORDER BY ") {
+		// 没有主键时无法保证分页的顺序，一次读取
+		paged = false
+	}
+{{- end }}
+	for start := uint(0); ; start += EachPageSize {
+		if query.dao.contextDone() {
+			return query.dao.ctx.Err()
+		}
+		pageSql, pageArgs := sql, args
+		if paged {
+			pageSql += " LIMIT ?,?"
+			pageArgs = append(append([]interface{}{}, args...), start, EachPageSize)
+		}
+		r := query.dao.runner().Query(pageSql, pageArgs...)
+		if r.Error != nil {
+			query.dao.lastError = r.Error
+			return r.Error
+		}
+		list := make([]{{.FixedTableName}}Item, 0)
+		if err := r.To(&list); err != nil {
+			query.dao.lastError = err
+			return err
+		}
+		for i := range list {
+			list[i].dao = query.dao
+			list[i].changes = map[string]any{}
+			if err := fn(&list[i]); err != nil {
+				return err
+			}
+		}
+		if !paged || uint(len(list)) < EachPageSize {
+			return nil
+		}
+	}
+}
+
+// All 与 Each 相同，用于 for item, err := range query.All() {}（Go 1.23），break 时停止读取并释放连接，出错时最后返回一次 nil, err
+func (query *{{.FixedTableName}}Query) All() iter.Seq2[*{{.FixedTableName}}Item, error] {
+	return func(yield func(*{{.FixedTableName}}Item, error) bool) {
+		err := query.Each(func(item *{{.FixedTableName}}Item) error {
+			if !yield(item, nil) {
+				return errEachStopped
+			}
+			return nil
+		})
+		if err != nil && err != errEachStopped {
+			yield(nil, err)
+		}
+	}
+}
+
 func (query *{{.FixedTableName}}Query) LastSql() *string {
+	if query.mockRows != nil {
+		return &query.mockSql
+	}
 	if query.result != nil {
 		return query.result.Sql
 	}
@@ -960,6 +1808,9 @@ func (query *{{.FixedTableName}}Query) LastSql() *string {
 }
 
 func (query *{{.FixedTableName}}Query) LastArgs() []interface{} {
+	if query.mockRows != nil {
+		return query.mockArgs
+	}
 	if query.result != nil {
 		return query.result.Args
 	}
@@ -970,24 +1821,59 @@ func (query *{{.FixedTableName}}Query) LastError() error {
 	if query.result != nil {
 		return query.result.Error
 	}
+	if query.dao.ctx != nil {
+		return query.dao.ctx.Err()
+	}
 	return nil
 }
 
-{{range .Fields}}{{ if .Options }}
+{{range .EnumFields}}{{ if .Options }}
 type {{.Type}} string
 {{$typ := .Type}}
 const (
 {{range $k, $v := .Options}}
 	{{$k}} {{$typ}} = "{{$v}}"{{ end }}
 )
+
+var valid{{.Type}} = map[{{.Type}}]bool{
+{{range $k, $v := .Options}}
+	{{$k}}: true,{{ end }}
+}
+
+{{ if .IsSet }}
+// IsValid 检查集合中的每一个值，空字符串为空集合
+func (v {{.Type}}) IsValid() bool {
+	if v == "" {
+		return true
+	}
+	for _, s := range strings.Split(string(v), ",") {
+		if !valid{{.Type}}[{{.Type}}(s)] {
+			return false
+		}
+	}
+	return true
+}
+{{ else }}
+func (v {{.Type}}) IsValid() bool {
+	return valid{{.Type}}[v]
+}
+{{ end }}
+
+func Parse{{.Type}}(s string) ({{.Type}}, error) {
+	v := {{.Type}}(s)
+	if !v.IsValid() {
+		return "", fmt.Errorf("invalid {{.Type}} value: %s", s)
+	}
+	return v, nil
+}
 {{ end }}{{ end }}
 
 type {{.FixedTableName}}Item struct {
-	dao *{{.FixedTableName}}Dao
+	dao {{.FixedTableName}}DaoInterface
 	isNew bool
 	changes map[string]any
 {{range .Fields}}
-	{{.Name}} {{.Type}}{{ end }}
+	{{.Name}} {{.Type}}{{ if .RefTable }} // {{.RefTable}}.{{.RefField}}{{ end }}{{ end }}
 }
 
 {{range .PointFields}}
@@ -997,83 +1883,154 @@ func (item *{{$.FixedTableName}}Item) {{.Name}}Value() {{.Type}} {
 	}
 	return *item.{{.Name}}
 }
-
+{{ if .IsEnum }}
+func (item *{{$.FixedTableName}}Item) Set{{.Name}}Value(value {{.Type}}) error {
+	if !value.IsValid() {
+		return fmt.Errorf("invalid {{.Type}} value: %s", value)
+	}
+	item.{{.Name}} = &value
+	item.changes["{{.Name}}"] = &value
+	return nil
+}
+{{ else }}
 func (item *{{$.FixedTableName}}Item) Set{{.Name}}Value(value {{.Type}}) {
 	item.{{.Name}} = &value
 	item.changes["{{.Name}}"] = &value
 }
-{{ end }}
+{{ end }}{{ end }}
 
-{{range .Fields}}
+{{range .Fields}}{{ if .IsEnum }}
+func (item *{{$.FixedTableName}}Item) Set{{.Name}}(value {{.Type}}) error {
+	if value != nil && !value.IsValid() {
+		return fmt.Errorf("invalid {{.Name}} value: %s", *value)
+	}
+	item.{{.Name}} = value
+	item.changes["{{.Name}}"] = value
+	return nil
+}
+{{ else }}
 func (item *{{$.FixedTableName}}Item) Set{{.Name}}(value {{.Type}}) {
 	item.{{.Name}} = value
 	item.changes["{{.Name}}"] = value
 }
-{{ end }}
+{{ end }}{{ end }}
 
 {{ if .PrimaryKey }}
 
-{{ if .HasVersion }}
-func (item *{{.FixedTableName}}Item) Save() (ok bool, version uint64) {
-{{ else }}
-func (item *{{.FixedTableName}}Item) Save() (ok bool) {
-{{ end }}
+func (item *{{.FixedTableName}}Item) Save() ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
 	if item.dao == nil {
 		log.DefaultLogger.Error("save item without dao", "dao", "{{.DBName}}", "table", "{{.TableName}}", "item", item)
-		ok = false
+		{{ if .ReturnError }}err = ErrNoDao{{ else }}ok = false{{ end }}
 		return
 	}
 	{{ if .IsAutoId }}
-	if item.{{.AutoIdField}} == nil {
-	    newId, insertOk := item.dao.Insert(item)
-	    newIdX := {{.AutoIdFieldType}}(newId)
-	    item.{{.AutoIdField}} = &newIdX
+	if item.{{.AutoIdField}} == nil || item.isNew {
+	{{ if .ReturnError }}
+	    {{ if .HasVersion }}newId, newVersion, insertErr := item.dao.Insert(item)
+	    version = newVersion{{ else }}newId, insertErr := item.dao.Insert(item){{ end }}
+	    if insertErr == nil && item.{{.AutoIdField}} == nil {
+	        newIdX := {{.AutoIdFieldType}}(newId)
+	        item.{{.AutoIdField}} = &newIdX
+	    }
+	    {{ if and .OptimisticLock .HasVersion }}if insertErr == nil {
+	        item.isNew = false
+	        item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}newVersion
+	    }{{ end }}
+	    err = insertErr
+	{{ else }}
+	    {{ if .HasVersion }}newId, insertOk, newVersion := item.dao.Insert(item)
+	    version = newVersion{{ else }}newId, insertOk := item.dao.Insert(item){{ end }}
+	    if item.{{.AutoIdField}} == nil {
+	        newIdX := {{.AutoIdFieldType}}(newId)
+	        item.{{.AutoIdField}} = &newIdX
+	    }
+	    {{ if and .OptimisticLock .HasVersion }}if insertOk {
+	        item.isNew = false
+	        item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}newVersion
+	    }{{ end }}
 	    ok = insertOk
+	{{ end }}
 	    return
 	}
-    {{ end }}
+    {{ else }}
     if item.isNew {
+    {{ if and .OptimisticLock .HasVersion }}
+        {{ if .ReturnError }}version, err = item.dao.Insert(item)
+        ok := err == nil{{ else }}ok, version = item.dao.Insert(item){{ end }}
+        if ok {
+            item.isNew = false
+            item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}version
+        }
+        return
+    {{ else }}
         return item.dao.Insert(item)
+    {{ end }}
     }
+    {{ end }}
+{{ if and .OptimisticLock .HasVersion }}
+	// 乐观锁，使用读取时的版本修改，成功后更新 item 中的版本，版本冲突时保留 changes
+	var data interface{} = item.changes
+	if len(item.changes) == 0 {
+		data = item
+	}
+	{{ if .ReturnError }}version, err = item.dao.UpdateWithVersion(data, {{.PrimaryKey.ItemArgs}}, item.{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }})
+	ok := err == nil{{ else }}ok, version = item.dao.UpdateWithVersion(data, {{.PrimaryKey.ItemArgs}}, item.{{.VersionFieldName}}{{ if .VersionIsPoint }}Value(){{ end }}){{ end }}
+	if ok {
+		item.changes = map[string]any{}
+		item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}version
+	}
+	return
+{{ else }}
     if len(item.changes) == 0 {
-	    return item.dao.Replace(item)
+	    return item.dao.Upsert(item)
     }
     data := item.changes
     item.changes = map[string]any{}
     return item.dao.Update(data, {{.PrimaryKey.ItemArgs}})
+{{ end }}
 }
 
 {{ if .InvalidSet }}
-{{ if .HasVersion }}
-func (item *{{.FixedTableName}}Item) Enable() (ok bool, version uint64) {
-{{ else }}
-func (item *{{.FixedTableName}}Item) Enable() (ok bool) {
-{{ end }}
+func (item *{{.FixedTableName}}Item) Enable() ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
 	if item.dao == nil {
 		log.DefaultLogger.Error("enable item without dao", "dao", "{{.DBName}}", "table", "{{.TableName}}", "item", item)
-		ok = false
+		{{ if .ReturnError }}err = ErrNoDao{{ else }}ok = false{{ end }}
 		return
 	}
+{{ if and .OptimisticLock .HasVersion }}
+	{{ if .ReturnError }}version, err = item.dao.Enable({{.PrimaryKey.ItemArgs}})
+	ok := err == nil{{ else }}ok, version = item.dao.Enable({{.PrimaryKey.ItemArgs}}){{ end }}
+	if ok {
+		item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}version
+	}
+	return
+{{ else }}
 	return item.dao.Enable({{.PrimaryKey.ItemArgs}})
+{{ end }}
 }
 
-{{ if .HasVersion }}
-func (item *{{.FixedTableName}}Item) Disable() (ok bool, version uint64) {
-{{ else }}
-func (item *{{.FixedTableName}}Item) Disable() (ok bool) {
-{{ end }}
+func (item *{{.FixedTableName}}Item) Disable() ({{ if .ReturnError }}{{ if .HasVersion }}version uint64, {{ end }}err error{{ else }}ok bool{{ if .HasVersion }}, version uint64{{ end }}{{ end }}) {
 	if item.dao == nil {
 		log.DefaultLogger.Error("disable item without dao", "dao", "{{.DBName}}", "table", "{{.TableName}}", "item", item)
-		ok = false
+		{{ if .ReturnError }}err = ErrNoDao{{ else }}ok = false{{ end }}
 		return
 	}
+{{ if and .OptimisticLock .HasVersion }}
+	{{ if .ReturnError }}version, err = item.dao.Disable({{.PrimaryKey.ItemArgs}})
+	ok := err == nil{{ else }}ok, version = item.dao.Disable({{.PrimaryKey.ItemArgs}}){{ end }}
+	if ok {
+		item.{{.VersionFieldName}} = {{ if .VersionIsPoint }}&{{ end }}version
+	}
+	return
+{{ else }}
 	return item.dao.Disable({{.PrimaryKey.ItemArgs}})
+{{ end }}
 }
 {{ else }}
-func (item *{{.FixedTableName}}Item) Delete() bool {
+func (item *{{.FixedTableName}}Item) Delete() {{ if .ReturnError }}error{{ else }}bool{{ end }} {
 	if item.dao == nil {
 		log.DefaultLogger.Error("delete item without dao", "dao", "{{.DBName}}", "table", "{{.TableName}}", "item", item)
-		return false
+		return {{ if .ReturnError }}ErrNoDao{{ else }}false{{ end }}
 	}
 	return item.dao.Delete({{.PrimaryKey.ItemArgs}})
 }
@@ -1081,6 +2038,39 @@ func (item *{{.FixedTableName}}Item) Delete() bool {
 
 {{ end }}
 
+{{range .BelongsTo}}
+func (item *{{$.FixedTableName}}Item) {{.Name}}() *{{.RefTable}}Item {
+	// 关联数据需要通过数据库读取，{{$.FixedTableName}}DaoMock 创建的 Item 不能读取
+	dao, isDao := item.dao.(*{{$.FixedTableName}}Dao)
+	if !isDao {
+		log.DefaultLogger.Error("load relation without dao", "dao", "{{$.DBName}}", "table", "{{$.TableName}}", "relation", "{{.Name}}", "item", item)
+		return nil
+	}
+{{ if .IsPoint }}
+	if item.{{.Field}} == nil {
+		return nil
+	}
+{{ end }}
+{{ if .Getter }}
+	return dao.get{{.RefTable}}Dao().{{.Getter}}({{.RefType}}({{ if .IsPoint }}*{{ end }}item.{{.Field}}))
+{{ else }}
+	return dao.get{{.RefTable}}Dao().NewQuery().Where("`{{.RefColumn}}`=?", item.{{.Field}}).First()
+{{ end }}
+}
+{{ end }}
+
+{{range .HasMany}}
+func (item *{{$.FixedTableName}}Item) {{.Name}}() []{{.RefTable}}Item {
+	// 关联数据需要通过数据库读取，{{$.FixedTableName}}DaoMock 创建的 Item 不能读取
+	dao, isDao := item.dao.(*{{$.FixedTableName}}Dao)
+	if !isDao {
+		log.DefaultLogger.Error("load relation without dao", "dao", "{{$.DBName}}", "table", "{{$.TableName}}", "relation", "{{.Name}}", "item", item)
+		return nil
+	}
+	return dao.get{{.RefTable}}Dao().NewQuery().{{.QueryBy}}(item).List()
+}
+{{ end }}
+
 func (item *{{.FixedTableName}}Item) SetByField(field string, value interface{}) {
 	fieldValue := reflect.ValueOf(item).Elem().FieldByName(u.GetUpperName(field))
 	if fieldValue.IsValid() {
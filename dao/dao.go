@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -34,6 +37,7 @@ type TableDesc struct {
 	Default *string
 	Extra   string
 	After   string
+	Comment string
 }
 
 type TableIndex struct {
@@ -41,11 +45,15 @@ type TableIndex struct {
 	Key_name     string
 	Seq_in_index int
 	Column_name  string
+	Collation    string // A 升序，D 降序
+	Sub_part     int    // 前缀索引的长度
+	Index_type   string
 }
 
 type DaoData struct {
 	DBName       string
 	VersionField string
+	ReturnError  bool
 	Tables       []string
 	FixedTables  []string
 }
@@ -59,6 +67,44 @@ var tableTpl string // 当前目录，解析为string类型
 //go:embed a_er.html
 var erTpl string // 当前目录，解析为string类型
 
+// TemplateConfig 自定义模版，Path 目录中有 a_table.go.tpl、a_config.go.tpl 时替换内置的模版
+// Tables 为每张表生成的文件，Files 为每个数据库生成一次的文件，key 为输出的文件名（{{table}} 替换为表名），value 为 Path 中的模版文件
+type TemplateConfig struct {
+	Path   string
+	Tables map[string]string
+	Files  map[string]string
+}
+
+var Templates = TemplateConfig{}
+
+// TemplateFuncs 模版中可以使用的函数
+var TemplateFuncs = template.FuncMap{
+	"upper": func(s string) string { // user => User
+		if s == "" {
+			return s
+		}
+		return u.GetUpperName(s)
+	},
+	"lower": func(s string) string { // User => user
+		if s == "" {
+			return s
+		}
+		return u.GetLowerName(s)
+	},
+	"toUpper":    strings.ToUpper,
+	"toLower":    strings.ToLower,
+	"join":       strings.Join,
+	"split":      strings.Split,
+	"contains":   strings.Contains,
+	"hasPrefix":  strings.HasPrefix,
+	"hasSuffix":  strings.HasSuffix,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+	"quote":      strconv.Quote,
+	"json":       u.Json,
+}
+
 var DefaultVersionField = "version"
 var DefaultValidFields = []ValidFieldConfig{
 	{
@@ -103,6 +149,21 @@ var DefaultValidFields = []ValidFieldConfig{
 	},
 }
 
+// EnableForeignKey 为 true 时为 >Table.field 引用创建外键约束，默认只用于生成代码和ER图
+var EnableForeignKey = false
+
+// ReturnError 为 true 时生成的 Insert、Update、Save 等写入方法返回 error，而不是 bool
+var ReturnError = false
+
+// OptimisticLock 为 true 时有版本字段的表在 Item.Save 中使用 UpdateWithVersion，数据库中的版本与读取时不同则不修改
+var OptimisticLock = false
+
+// AllowDrop 为 true 时允许导入时删除字段、索引、主键，以及执行可能丢失数据的字段修改
+var AllowDrop = false
+
+// sqlitePrimaryGroup sqlite 中主键（PK）使用的唯一索引分组，索引名为 uk_表名_99
+const sqlitePrimaryGroup = "99"
+
 var typeMapping = map[string]map[string]string{
 	"mysql": {
 		"PK":  "PRIMARY KEY",
@@ -136,39 +197,63 @@ var typeMapping = map[string]map[string]string{
 		"umi": "MIDDLEINT UNSIGNED",
 		"t":   "LONGTEXT",
 		"bb":  "LONGBLOB",
+		"dec": "DECIMAL",
+		"j":   "JSON",
+		"e":   "ENUM",
+		"s":   "SET",
+		"vb":  "VARBINARY",
+		"bn":  "BINARY",
+		"lt":  "LONGTEXT",
+		"mt":  "MEDIUMTEXT",
+		"ts":  "TIMESTAMP(6)",
+		"bit": "BIT",
+		"y":   "YEAR",
 	},
 	"sqlite": {
+		// sqlite 保存声明的类型名称，使用与 mysql 相同的名称，读取表结构时可以还原
+		// json、enum、set 使用 TEXT，通过 CHECK 约束保存类型和取值范围
 		"PK":  "UNIQUE",
 		"AI":  "AUTOINCREMENT",
 		"I":   "INDEX",
 		"U":   "UNIQUE",
 		"TI":  "FULLTEXT INDEX",
-		"ct":  "",
-		"ctu": "",
+		"ct":  "CURRENT_TIMESTAMP",
+		"ctu": "CURRENT_TIMESTAMP",
 		"n":   "NULL",
 		"nn":  "NOT NULL",
-		"c":   "TEXT",
-		"v":   "TEXT",
-		"dt":  "TEXT",
-		"d":   "TEXT",
-		"tm":  "TEXT",
-		"i":   "INTEGER",
-		"ui":  "INTEGER",
-		"ti":  "INTEGER",
-		"uti": "INTEGER",
-		"b":   "INTEGER",
-		"bi":  "INTEGER",
-		"ubi": "INTEGER",
-		"f":   "REAL",
-		"uf":  "REAL",
-		"ff":  "REAL",
-		"uff": "REAL",
-		"si":  "INTEGER",
-		"usi": "INTEGER",
-		"mi":  "INTEGER",
-		"umi": "INTEGER",
+		"c":   "CHAR",
+		"v":   "VARCHAR",
+		"dt":  "DATETIME",
+		"d":   "DATE",
+		"tm":  "TIME",
+		"i":   "INT",
+		"ui":  "INT UNSIGNED",
+		"ti":  "TINYINT",
+		"uti": "TINYINT UNSIGNED",
+		"b":   "TINYINT UNSIGNED",
+		"bi":  "BIGINT",
+		"ubi": "BIGINT UNSIGNED",
+		"f":   "FLOAT",
+		"uf":  "FLOAT UNSIGNED",
+		"ff":  "DOUBLE",
+		"uff": "DOUBLE UNSIGNED",
+		"si":  "SMALLINT",
+		"usi": "SMALLINT UNSIGNED",
+		"mi":  "MEDIUMINT",
+		"umi": "MEDIUMINT UNSIGNED",
 		"t":   "TEXT",
 		"bb":  "BLOB",
+		"dec": "DECIMAL",
+		"j":   "TEXT",
+		"e":   "TEXT",
+		"s":   "TEXT",
+		"vb":  "VARBINARY",
+		"bn":  "BINARY",
+		"lt":  "LONGTEXT",
+		"mt":  "MEDIUMTEXT",
+		"ts":  "TIMESTAMP",
+		"bit": "BIT",
+		"y":   "YEAR",
 	},
 	"pgsql": {
 		"PK":  "PRIMARY KEY",
@@ -202,6 +287,17 @@ var typeMapping = map[string]map[string]string{
 		"umi": "INTEGER",
 		"t":   "TEXT",
 		"bb":  "BYTEA",
+		"dec": "NUMERIC",
+		"j":   "JSONB",
+		"e":   "TEXT",
+		"s":   "TEXT",
+		"vb":  "BYTEA",
+		"bn":  "BYTEA",
+		"lt":  "TEXT",
+		"mt":  "TEXT",
+		"ts":  "TIMESTAMP(6) WITH TIME ZONE",
+		"bit": "BIT",
+		"y":   "SMALLINT",
 	},
 	"sqlserver": {
 		"PK":  "PRIMARY KEY",
@@ -235,6 +331,17 @@ var typeMapping = map[string]map[string]string{
 		"umi": "INT",
 		"t":   "TEXT",
 		"bb":  "VARBINARY(MAX)",
+		"dec": "DECIMAL",
+		"j":   "NVARCHAR(MAX)",
+		"e":   "VARCHAR(255)",
+		"s":   "VARCHAR(255)",
+		"vb":  "VARBINARY",
+		"bn":  "BINARY",
+		"lt":  "TEXT",
+		"mt":  "TEXT",
+		"ts":  "DATETIMEOFFSET(6)",
+		"bit": "BIT",
+		"y":   "SMALLINT",
 	},
 	"oracle": {
 		"PK":  "PRIMARY KEY",
@@ -268,9 +375,36 @@ var typeMapping = map[string]map[string]string{
 		"umi": "NUMBER",
 		"t":   "CLOB",
 		"bb":  "BLOB",
+		"dec": "NUMBER",
+		"j":   "CLOB",
+		"e":   "VARCHAR2(255)",
+		"s":   "VARCHAR2(255)",
+		"vb":  "RAW",
+		"bn":  "RAW",
+		"lt":  "CLOB",
+		"mt":  "CLOB",
+		"ts":  "TIMESTAMP(6) WITH TIME ZONE",
+		"bit": "NUMBER",
+		"y":   "NUMBER(4)",
 	},
 }
 
+// fixDBType 将驱动类型（db.Config.Type）转换为 typeMapping 中使用的类型
+func fixDBType(dbType string) string {
+	switch dbType {
+	case "postgres", "postgresql", "pgx", "pgsql":
+		return "pgsql"
+	case "oci8", "godror", "oracle":
+		return "oracle"
+	case "sqlserver", "mssql":
+		return "sqlserver"
+	}
+	if strings.HasPrefix(dbType, "sqlite") {
+		return "sqlite"
+	}
+	return dbType
+}
+
 //type AA string
 //
 //const (
@@ -279,10 +413,15 @@ var typeMapping = map[string]map[string]string{
 //)
 
 type FieldData struct {
-	Name    string
-	Type    string
-	Default string
-	Options map[string]string
+	Name     string
+	Column   string
+	Type     string
+	Default  string
+	Options  map[string]string
+	IsEnum   bool // 枚举或集合，生成 IsValid 检查
+	IsSet    bool
+	RefTable string
+	RefField string
 }
 
 type IndexField struct {
@@ -292,6 +431,9 @@ type IndexField struct {
 	Params     string
 	ItemArgs   string
 	StringArgs string
+	Columns    string // "a", "b"，与 StringArgs 不同，不转换字段名，用于 Upsert
+	Column     string // 只有一个字段时的字段名和类型，用于 GetMany、DeleteMany
+	Type       string
 }
 
 type TableData struct {
@@ -300,22 +442,50 @@ type TableData struct {
 	FixedTableName  string
 	IsAutoId        bool
 	AutoIdField     string
+	AutoIdColumn    string
 	AutoIdFieldType string
 	PrimaryKey      *IndexField
 	UniqueKeys      map[string]*IndexField
 	IndexKeys       map[string]*IndexField
 	Fields          []FieldData
 	PointFields     []FieldData
+	EnumFields      []FieldData // 枚举和集合字段，Type 不带指针
 	//FieldsWithoutAutoId []FieldData
 	SelectFields          string
 	ValidField            string
 	ValidWhere            string
+	ValidCheck            string // 判断 item 是否有效的 Go 表达式，与 ValidWhere 的条件相同，用于 Sync 识别软删除的数据
 	ValidSet              string
 	InvalidSet            string
 	VersionField          string
+	ReturnError           bool
+	OptimisticLock        bool
+	VersionFieldName      string
+	VersionIsPoint        bool
 	HasVersion            bool
 	AutoGenerated         []string
 	AutoGeneratedOnUpdate []string
+	BelongsTo             []*RelationData
+	HasMany               []*RelationData
+	RelatedTables         []string
+	getters               map[string]string // 字段 => 读取单条记录的方法（Get、GetByXxx）
+}
+
+// RelationData 表之间的引用关系，Field 为本表字段，RefField 为关联表的字段
+type RelationData struct {
+	Name         string // 读取关联数据的方法名，如 User、Devices
+	QueryBy      string // 引用方 Query 上按关联对象查询的方法名，如 ByUser
+	Field        string
+	Column       string
+	Type         string
+	IsPoint      bool
+	RefTable     string
+	RefTableName string
+	RefField     string
+	RefColumn    string
+	RefType      string
+	RefIsPoint   bool
+	Getter       string // 关联表中按字段读取单条记录的方法，为空时使用查询
 }
 
 type FindingDBConfig struct {
@@ -338,31 +508,200 @@ func fixJoinParams(elems []string, sep string) string {
 	return strings.Join(a, sep)
 }
 
+// makeValidCheck 将 ValidOperator、ValidValue 转换为 Go 表达式，与 SQL 一样 NULL 视为无效
+func makeValidCheck(field string, isPoint bool, info *ValidFieldConfig) string {
+	op := strings.TrimSpace(info.ValidOperator)
+	switch op {
+	case "=":
+		op = "=="
+	case "<>":
+		op = "!="
+	}
+	value := strings.TrimSpace(info.ValidValue)
+	check := ""
+	if strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) >= 2 {
+		check = "u.String(" + field + ") " + op + " \"" + value[1:len(value)-1] + "\""
+	} else {
+		check = "u.Float64(" + field + ") " + op + " " + value
+	}
+	if isPoint {
+		check = field + " != nil && " + strings.Replace(check, "("+field+")", "(*"+field+")", 1)
+	}
+	return check
+}
+
 func MakeDaoFromDB(conn *db.DB, logger *log.Logger) error {
 	return MakeDaoFromDBWithOption(conn, DefaultVersionField, DefaultValidFields, logger)
 }
 
 func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []ValidFieldConfig, logger *log.Logger) error {
-	r := conn.Query("SHOW TABLES")
-	if r.Error != nil {
+	allTables, err := GetTables(conn)
+	if err != nil {
 		if logger != nil {
-			logger.Error("failed to connect to db", "err", r.Error.Error())
+			logger.Error("failed to connect to db", "err", err.Error())
 		} else {
-			fmt.Println("failed to connect to db", u.Red(r.Error.Error()))
+			fmt.Println("failed to connect to db", u.Red(err.Error()))
 		}
-		return r.Error
+		return err
 	}
-	tables := make([]string, 0)
-	fixedTables := make([]string, 0)
-	for _, table := range r.StringsOnC1() {
+
+	schemas := make([]*TableSchema, 0)
+	for _, table := range allTables {
 		if strings.HasPrefix(table, "_") || strings.HasPrefix(table, ".") {
 			continue
 		}
-		tables = append(tables, table)
-		fixedTables = append(fixedTables, strings.ToUpper(table[0:1])+table[1:])
+		// 按数据库类型读取表结构，统一为 mysql 的 DESC 和 SHOW INDEX 格式
+		schema, err := GetTableSchema(conn, table)
+		if err != nil {
+			if logger != nil {
+				logger.Error("failed to read table", "tableName", table, "err", err.Error())
+			} else {
+				fmt.Println(" -", table, u.Red(err.Error()))
+			}
+			continue
+		}
+		schemas = append(schemas, schema)
 	}
-
 	dbName := conn.Config.DB
+	if dbName == "" && fixDBType(conn.Config.Type) == "sqlite" {
+		// sqlite 没有数据库名，与 -c 一样使用文件名，如 app.db 生成 appDao
+		dbName = strings.SplitN(filepath.Base(conn.Config.Host), ".", 2)[0]
+	}
+	return makeDao(dbName, schemas, versionField, validFields, logger)
+}
+
+func MakeDaoFromDesc(dbType, desc string, dbName string, logger *log.Logger) error {
+	return MakeDaoFromDescWithOption(dbType, desc, dbName, DefaultVersionField, DefaultValidFields, logger)
+}
+
+func MakeDaoFromDescWithOption(dbType, desc string, dbName string, versionField string, validFields []ValidFieldConfig, logger *log.Logger) error {
+	dbType = fixDBType(dbType)
+	tablesByGroup := MakeERFromDesc(dbType, desc)
+	if tablesByGroup == nil {
+		return errors.New("failed to parse desc")
+	}
+
+	schemas := make([]*TableSchema, 0)
+	for _, g := range tablesByGroup {
+		for _, table := range g.Tables {
+			if strings.HasPrefix(table.Name, "_") || strings.HasPrefix(table.Name, ".") {
+				continue
+			}
+			schemas = append(schemas, makeSchemaFromDesc(dbType, table))
+		}
+	}
+	return makeDao(dbName, schemas, versionField, validFields, logger)
+}
+
+// makeSchemaFromDesc 将描述文件中的表转换为 GetTableSchema 的格式，保证 -c 和 -u 生成相同的代码
+func makeSchemaFromDesc(dbType string, table *TableStruct) *TableSchema {
+	schema := &TableSchema{
+		Name:    table.Name,
+		Comment: table.Comment,
+		Fields:  make([]TableDesc, 0),
+		Indexes: make([]TableIndex, 0),
+		Refs:    make([]TableRef, 0),
+	}
+
+	// 复合索引中的字段按 I1:2 指定的位置排列
+	keyNames := make([]string, 0)
+	keyFields := map[string][]TableField{}
+	keyNonUnique := map[string]int{}
+	for _, field := range table.Fields {
+		typ := strings.ToLower(field.Type)
+		switch dbType {
+		case "sqlite":
+			typ = fixSqliteType(typ)
+		case "pgsql":
+			typ = fixPgsqlTypeForDao(fixPgsqlType(typ))
+		}
+		if field.DaoType != "" {
+			typ = field.DaoType
+		}
+		desc := TableDesc{
+			Field:   field.Name,
+			Type:    typ,
+			Null:    u.StringIf(field.Null == typeMapping[dbType]["nn"], "NO", "YES"),
+			Comment: field.Comment,
+		}
+		if field.Default != "" && (field.Default == typeMapping[dbType]["ct"] || field.Default == typeMapping[dbType]["ctu"]) {
+			desc.Extra = "DEFAULT_GENERATED" + u.StringIf(field.OnUpdate, " on update CURRENT_TIMESTAMP", "")
+		}
+
+		keyName := ""
+		nonUnique := 0
+		if field.Extra != "" && field.Extra == typeMapping[dbType]["AI"] {
+			// 自增字段总是主键（sqlite 中为 INTEGER PRIMARY KEY）
+			desc.Extra = "auto_increment"
+			keyName = "PRIMARY"
+		} else {
+			switch field.Index {
+			case "":
+			case "PRIMARY KEY":
+				keyName = "PRIMARY"
+			case "UNIQUE":
+				keyName = fmt.Sprint("uk_", table.Name, "_", u.StringIf(field.IndexGroup != "", field.IndexGroup, field.Name))
+				if dbType == "sqlite" && field.IndexGroup == sqlitePrimaryGroup {
+					// sqlite 的主键为 uk_表名_99 唯一索引
+					keyName = "PRIMARY"
+				}
+			case "INDEX":
+				keyName = fmt.Sprint("ik_", table.Name, "_", u.StringIf(field.IndexGroup != "", field.IndexGroup, field.Name))
+				nonUnique = 1
+			default:
+				keyName = fmt.Sprint("tk_", table.Name, "_", field.Name)
+				nonUnique = 1
+			}
+		}
+		if keyName != "" {
+			if keyFields[keyName] == nil {
+				keyNames = append(keyNames, keyName)
+			}
+			keyFields[keyName] = append(keyFields[keyName], field)
+			keyNonUnique[keyName] = nonUnique
+		}
+
+		if field.RefTable != "" {
+			schema.Refs = append(schema.Refs, TableRef{
+				Name:     fmt.Sprint("fk_", table.Name, "_", field.Name),
+				Field:    field.Name,
+				RefTable: field.RefTable,
+				RefField: field.RefField,
+			})
+		}
+		schema.Fields = append(schema.Fields, desc)
+	}
+
+	for _, keyName := range keyNames {
+		sortIndexFields(keyFields[keyName])
+		for i, field := range keyFields[keyName] {
+			subPart := 0
+			if dbType == "mysql" {
+				subPart = field.IndexLen
+			}
+			schema.Indexes = append(schema.Indexes, TableIndex{
+				Non_unique:   keyNonUnique[keyName],
+				Key_name:     keyName,
+				Seq_in_index: i + 1,
+				Column_name:  field.Name,
+				Collation:    u.StringIf(field.IndexDesc, "D", "A"),
+				Sub_part:     subPart,
+			})
+		}
+	}
+	return schema
+}
+
+func makeDao(dbName string, schemas []*TableSchema, versionField string, validFields []ValidFieldConfig, logger *log.Logger) error {
+	// 按表名排序，与从数据库读取时的顺序相同
+	sort.SliceStable(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	tables := make([]string, 0)
+	fixedTables := make([]string, 0)
+	for _, schema := range schemas {
+		tables = append(tables, schema.Name)
+		fixedTables = append(fixedTables, strings.ToUpper(schema.Name[0:1])+schema.Name[1:])
+	}
+
 	dbPath := dbName + "Dao"
 	if !u.FileExists(dbPath) {
 		_ = os.Mkdir(dbPath, 0755)
@@ -379,18 +718,25 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 	daoData := DaoData{
 		DBName:       dbName,
 		VersionField: versionField,
+		ReturnError:  ReturnError,
 		Tables:       tables,
 		FixedTables:  fixedTables,
 	}
 	dbConfigFile := path.Join(dbPath, "a__config.go")
-	err := writeWithTpl(dbConfigFile, configTpl, daoData)
+	tpl, err := loadTemplate("a_config.go.tpl", configTpl)
+	if err == nil {
+		err = writeWithTpl(dbConfigFile, tpl, daoData)
+	}
+	if err == nil {
+		err = writeExtraFiles(dbPath, Templates.Files, "", daoData)
+	}
 	//if err == nil {
 	//	queryFile := path.Join(dbPath, "query.go")
 	//	err = writeWithTpl(queryFile, queryTpl, daoData)
 	//}
 	if err != nil {
 		if logger != nil {
-			logger.Error("failed to make dao config", "dbName", dbName, "err", r.Error.Error())
+			logger.Error("failed to make dao config", "dbName", dbName, "err", err.Error())
 		} else {
 			fmt.Println(dbName, u.Red(err.Error()))
 		}
@@ -403,16 +749,16 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 	}
 
 	enumTypeExists := map[string]bool{}
-	for i, table := range tables {
+	tableDatas := make([]*TableData, 0)
+	for i, schema := range schemas {
+		table := schema.Name
 		fixedTableName := fixedTables[i]
-		tableFile := path.Join(dbPath, "a_"+table+".go")
-
-		descs := make([]TableDesc, 0)
-		_ = conn.Query("DESC `" + table + "`").To(&descs)
-
-		indexs := make([]TableIndex, 0)
-		_ = conn.Query("SHOW INDEX FROM `" + table + "`").To(&indexs)
-
+		descs := schema.Fields
+		indexs := schema.Indexes
+		refs := map[string]TableRef{}
+		for _, ref := range schema.Refs {
+			refs[ref.Field] = ref
+		}
 		tableData := TableData{
 			DBName:                dbName,
 			TableName:             table,
@@ -425,15 +771,22 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 			IndexKeys:             make(map[string]*IndexField),
 			Fields:                make([]FieldData, 0),
 			PointFields:           make([]FieldData, 0),
+			EnumFields:            make([]FieldData, 0),
 			SelectFields:          "",
 			ValidField:            "",
 			ValidWhere:            "",
 			ValidSet:              "",
 			InvalidSet:            "",
 			VersionField:          versionField,
+			ReturnError:           ReturnError,
+			OptimisticLock:        OptimisticLock,
 			HasVersion:            false,
 			AutoGenerated:         make([]string, 0),
 			AutoGeneratedOnUpdate: make([]string, 0),
+			BelongsTo:             make([]*RelationData, 0),
+			HasMany:               make([]*RelationData, 0),
+			RelatedTables:         make([]string, 0),
+			getters:               map[string]string{},
 		}
 		fields := make([]string, 0)
 		fieldTypesForId := map[string]string{}
@@ -454,6 +807,7 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 			if strings.Contains(desc.Extra, "auto_increment") {
 				tableData.IsAutoId = true
 				tableData.AutoIdField = u.GetUpperName(desc.Field)
+				tableData.AutoIdColumn = desc.Field
 				tableData.AutoGenerated = append(tableData.AutoGenerated, desc.Field)
 			}
 
@@ -468,10 +822,14 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 
 			if desc.Field == versionField && strings.Contains(desc.Type, "bigint") && strings.Contains(desc.Type, "unsigned") {
 				tableData.HasVersion = true
+				tableData.VersionFieldName = u.GetUpperName(desc.Field)
+				tableData.VersionIsPoint = desc.Null == "YES"
 			}
 
-			for _, validFieldInfo := range validFields {
+			var validInfo *ValidFieldConfig
+			for i, validFieldInfo := range validFields {
 				if desc.Field == validFieldInfo.Field && strings.Contains(desc.Type, validFieldInfo.Type) {
+					validInfo = &validFields[i]
 					tableData.ValidWhere = " AND `" + validFieldInfo.Field + "`" + validFieldInfo.ValidOperator + validFieldInfo.ValidValue
 					tableData.ValidSet = "`" + validFieldInfo.Field + "`" + validFieldInfo.ValidSetOperator + validFieldInfo.ValidSetValue
 					tableData.InvalidSet = "`" + validFieldInfo.Field + "`" + validFieldInfo.InvalidSetOperator + validFieldInfo.InvalidSetValue
@@ -483,7 +841,35 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 			typ := ""
 			defaultValue := "0"
 			options := map[string]string{}
-			if strings.Contains(desc.Type, "bigint") {
+			isEnum := strings.HasPrefix(desc.Type, "enum(") || strings.HasPrefix(desc.Type, "set(")
+			// datetime(6)、time(6) 等带精度的时间类型与不带精度的相同
+			timeType := strings.SplitN(desc.Type, "(", 2)[0]
+			if isEnum {
+				// 枚举和集合生成常量，集合的值为逗号分隔的多个常量，类型名带上表名，不同表中的同名字段可以有不同的值
+				typ = fixedTableName + u.GetUpperName(desc.Field)
+				if !enumTypeExists[table+"."+desc.Field] {
+					enumTypeExists[table+"."+desc.Field] = true
+					a := u.SplitWithoutNone(desc.Type[strings.IndexByte(desc.Type, '(')+1:len(desc.Type)-1], ",")
+					for _, v := range a {
+						if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
+							v = v[1 : len(v)-1]
+						}
+						options[typ+u.GetUpperName(v)] = v
+					}
+				}
+				defaultValue = "\"\""
+			} else if strings.HasPrefix(desc.Type, "decimal") || strings.HasPrefix(desc.Type, "numeric(") {
+				typ = "Decimal"
+				defaultValue = "\"0\""
+			} else if desc.Type == "json" {
+				typ = "Json"
+				defaultValue = "nil"
+			} else if strings.HasPrefix(desc.Type, "binary") || strings.HasPrefix(desc.Type, "varbinary") || strings.HasPrefix(desc.Type, "bit") {
+				typ = "[]byte"
+				defaultValue = "nil"
+			} else if desc.Type == "year" {
+				typ = "int"
+			} else if strings.Contains(desc.Type, "bigint") {
 				typ = "int64"
 			} else if strings.Contains(desc.Type, "int") {
 				typ = "int"
@@ -491,28 +877,15 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 				typ = "float32"
 			} else if strings.Contains(desc.Type, "double") {
 				typ = "float64"
-			} else if desc.Type == "datetime" {
+			} else if timeType == "datetime" || timeType == "timestamp" {
 				typ = "Datetime"
 				defaultValue = "\"0000-00-00 00:00:00\""
-			} else if desc.Type == "date" {
+			} else if timeType == "date" {
 				typ = "Date"
 				defaultValue = "\"0000-00-00\""
-			} else if desc.Type == "time" {
+			} else if timeType == "time" {
 				typ = "Time"
 				defaultValue = "\"00:00:00\""
-			} else if strings.HasPrefix(desc.Type, "enum(") {
-				typ = u.GetUpperName(desc.Field)
-				if !enumTypeExists[typ] {
-					enumTypeExists[typ] = true
-					a := u.SplitWithoutNone(desc.Type[5:len(desc.Type)-1], ",")
-					for _, v := range a {
-						if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
-							v = v[1 : len(v)-1]
-						}
-						options[typ+u.GetUpperName(v)] = v
-					}
-				}
-				defaultValue = "\"\""
 			} else {
 				typ = "string"
 				defaultValue = "\"\""
@@ -521,26 +894,46 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 				typ = "u" + typ
 			}
 			fieldTypesForId[desc.Field] = typ // 用于ID的类型不加指针
+			if isEnum {
+				tableData.EnumFields = append(tableData.EnumFields, FieldData{
+					Name:    u.GetUpperName(desc.Field),
+					Column:  desc.Field,
+					Type:    typ,
+					Options: options,
+					IsEnum:  true,
+					IsSet:   strings.HasPrefix(desc.Type, "set("),
+				})
+			}
 
 			if strings.Contains(desc.Extra, "auto_increment") && tableData.IsAutoId {
 				tableData.AutoIdFieldType = typ
 			}
 
 			//if desc.Null == "YES" || desc.Default != nil || desc.Extra == "auto_increment" {
-			if desc.Null == "YES" || strings.Contains(desc.Extra, "auto_increment") {
+			// []byte、Json 使用 nil 表示 NULL，不使用指针
+			// 枚举和 Decimal 是命名的字符串类型，db 只能通过指针读取，NOT NULL 时也使用指针
+			if (desc.Null == "YES" || strings.Contains(desc.Extra, "auto_increment") || isEnum || typ == "Decimal") && typ != "[]byte" && typ != "Json" {
 				tableData.PointFields = append(tableData.PointFields, FieldData{
 					Name:    u.GetUpperName(desc.Field),
 					Type:    typ,
 					Default: defaultValue,
 					Options: options,
+					IsEnum:  isEnum,
 				})
 				typ = "*" + typ
 			}
+			if validInfo != nil {
+				tableData.ValidCheck = makeValidCheck("item."+u.GetUpperName(desc.Field), strings.HasPrefix(typ, "*"), validInfo)
+			}
 			tableData.Fields = append(tableData.Fields, FieldData{
-				Name:    u.GetUpperName(desc.Field),
-				Type:    typ,
-				Default: defaultValue,
-				Options: options,
+				Name:     u.GetUpperName(desc.Field),
+				Column:   desc.Field,
+				Type:     typ,
+				Default:  defaultValue,
+				Options:  options,
+				IsEnum:   isEnum,
+				RefTable: refs[desc.Field].RefTable,
+				RefField: refs[desc.Field].RefField,
 			})
 			//if desc.Key != "PRI" {
 			//	tableData.FieldsWithoutAutoId = append(tableData.FieldsWithoutAutoId, FieldData{
@@ -590,6 +983,12 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 				Params:     fixJoinParams(idFieldParams, ", "),
 				ItemArgs:   strings.Join(idFieldItemArgs, ", "),
 				StringArgs: "\"" + fixJoinParams(idFields, "\", \"") + "\"",
+				Columns:    "\"" + strings.Join(idFields, "\", \"") + "\"",
+			}
+			if len(idFields) == 1 {
+				tableData.getters[idFields[0]] = "Get"
+				tableData.PrimaryKey.Column = idFields[0]
+				tableData.PrimaryKey.Type = fieldTypesForId[idFields[0]]
 			}
 
 			// 将复合主键中的索引添加到 NewQuery().ByXXX
@@ -621,6 +1020,10 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 					Params:     fixJoinParams(uniqueFieldParams[k], ", "),
 					ItemArgs:   strings.Join(uniqueFieldItemArgs[k], ", "),
 					StringArgs: "\"" + fixJoinParams(fieldNames, "\", \"") + "\"",
+					Columns:    "\"" + strings.Join(fieldNames, "\", \"") + "\"",
+				}
+				if len(fieldNames) == 1 && tableData.getters[fieldNames[0]] == "" {
+					tableData.getters[fieldNames[0]] = "GetBy" + name1
 				}
 			}
 
@@ -662,10 +1065,29 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 		}
 		tableData.SelectFields = "`" + strings.Join(fields, "`, `") + "`"
 
-		err := writeWithTpl(tableFile, tableTpl, tableData)
+		tableDatas = append(tableDatas, &tableData)
+	}
+
+	// 根据引用关系生成关联读取的方法
+	makeRelations(tableDatas)
+
+	tpl, tplErr := loadTemplate("a_table.go.tpl", tableTpl)
+	for _, tableData := range tableDatas {
+		table := tableData.TableName
+		tableFile := path.Join(dbPath, "a_"+table+".go")
+		err := tplErr
+		if err == nil {
+			err = writeWithTpl(tableFile, tpl, tableData)
+		}
+		if err == nil {
+			err = makeMock(tableFile, path.Join(dbPath, "a_"+table+"_mock.go"), tableData)
+		}
+		if err == nil {
+			err = writeExtraFiles(dbPath, Templates.Tables, table, tableData)
+		}
 		if err != nil {
 			if logger != nil {
-				logger.Error("failed to make dao", "tableName", table, "tableFile", tableFile, "err", r.Error.Error())
+				logger.Error("failed to make dao", "tableName", table, "tableFile", tableFile, "err", err.Error())
 			} else {
 				fmt.Println(" -", table, u.Red(err.Error()))
 			}
@@ -680,379 +1102,142 @@ func MakeDaoFromDBWithOption(conn *db.DB, versionField string, validFields []Val
 	return nil
 }
 
-func MakeDaoFromDesc(dbType, desc string, dbName string, logger *log.Logger) error {
-	return MakeDaoFromDescWithOption(dbType, desc, dbName, DefaultVersionField, DefaultValidFields, logger)
-}
-
-func MakeDaoFromDescWithOption(dbType, desc string, dbName string, versionField string, validFields []ValidFieldConfig, logger *log.Logger) error {
-	tablesByGroup := MakeERFromDesc(dbType, desc)
+// makeRelations 为引用其他表的字段生成 belongs-to 关系，并在被引用的表上生成 has-many 关系
+func makeRelations(tableDatas []*TableData) {
+	tableDataBy := map[string]*TableData{}
+	for _, tableData := range tableDatas {
+		tableDataBy[tableData.TableName] = tableData
+	}
 
-	if tablesByGroup != nil {
-		tables := make([]string, 0)
-		fixedTables := make([]string, 0)
-		tableSets := map[string]*TableStruct{}
-		for _, g := range tablesByGroup {
-			for _, table := range g.Tables {
-				if strings.HasPrefix(table.Name, "_") || strings.HasPrefix(table.Name, ".") {
-					continue
-				}
-				tables = append(tables, table.Name)
-				tableSets[table.Name] = table
-				fixedTables = append(fixedTables, strings.ToUpper(table.Name[0:1])+table.Name[1:])
+	for _, tableData := range tableDatas {
+		// 同一张表多次引用同一张表时，has-many 的方法名需要带上字段名区分
+		refCount := map[string]int{}
+		for _, field := range tableData.Fields {
+			if field.RefTable != "" {
+				refCount[field.RefTable]++
 			}
 		}
 
-		dbPath := dbName + "Dao"
-		if !u.FileExists(dbPath) {
-			_ = os.Mkdir(dbPath, 0755)
-		}
-
-		if files, err := u.ReadDir(dbPath); err == nil {
-			for _, file := range files {
-				if strings.HasPrefix(file.Name, "a_") && strings.HasSuffix(file.Name, ".go") {
-					_ = os.Remove(path.Join(dbPath, file.Name))
-				}
+		for _, field := range tableData.Fields {
+			refTableData := tableDataBy[field.RefTable]
+			if refTableData == nil {
+				continue
+			}
+			refField := refTableData.getField(field.RefField)
+			if refField == nil {
+				continue
 			}
-		}
 
-		daoData := DaoData{
-			DBName:       dbName,
-			VersionField: versionField,
-			Tables:       tables,
-			FixedTables:  fixedTables,
-		}
-		dbConfigFile := path.Join(dbPath, "a__config.go")
-		err := writeWithTpl(dbConfigFile, configTpl, daoData)
-		//if err == nil {
-		//	queryFile := path.Join(dbPath, "query.go")
-		//	err = writeWithTpl(queryFile, queryTpl, daoData)
-		//}
-		if err != nil {
-			if logger != nil {
-				logger.Error("failed to make dao config", "dbName", dbName, "err", err.Error())
+			name := ""
+			if strings.HasSuffix(field.Column, "Id") && len(field.Column) > 2 {
+				name = u.GetUpperName(field.Column[0 : len(field.Column)-2])
 			} else {
-				fmt.Println(dbName, u.Red(err.Error()))
+				name = field.Name + refTableData.FixedTableName
 			}
-		} else {
-			if logger != nil {
-				logger.Info("make dao config success", "dbName", dbName)
-			} else {
-				fmt.Println(dbName, u.Green("OK"))
+			if tableData.hasMember(name) {
+				name += "Item"
 			}
-		}
-
-		enumTypeExists := map[string]bool{}
-		for i, table := range tables {
-			fixedTableName := fixedTables[i]
-			tableFile := path.Join(dbPath, "a_"+table+".go")
-
-			//descs := make([]TableDesc, 0)
-			//_ = conn.Query("DESC `" + table + "`").To(&descs)
-			//
-			indexs := make([]TableIndex, 0)
-			//_ = conn.Query("SHOW INDEX FROM `" + table + "`").To(&indexs)
-
-			tableData := TableData{
-				DBName:                dbName,
-				TableName:             table,
-				FixedTableName:        fixedTableName,
-				IsAutoId:              false,
-				AutoIdField:           "",
-				AutoIdFieldType:       "",
-				PrimaryKey:            nil,
-				UniqueKeys:            make(map[string]*IndexField),
-				IndexKeys:             make(map[string]*IndexField),
-				Fields:                make([]FieldData, 0),
-				PointFields:           make([]FieldData, 0),
-				SelectFields:          "",
-				ValidField:            "",
-				ValidWhere:            "",
-				ValidSet:              "",
-				InvalidSet:            "",
-				VersionField:          versionField,
-				HasVersion:            false,
-				AutoGenerated:         make([]string, 0),
-				AutoGeneratedOnUpdate: make([]string, 0),
+			queryBy := "By" + name
+			if tableData.IndexKeys["Index_"+name] != nil {
+				queryBy += "Item"
 			}
-			fields := make([]string, 0)
-			fieldTypesForId := map[string]string{}
-			idFields := make([]string, 0)
-			idFieldsUpper := make([]string, 0)
-			idFieldParams := make([]string, 0)
-			idFieldItemArgs := make([]string, 0)
-			uniqueFields := map[string][]string{}
-			uniqueFieldsUpper := map[string][]string{}
-			uniqueFieldParams := map[string][]string{}
-			uniqueFieldItemArgs := map[string][]string{}
-			indexFields := map[string][]string{}
-			indexFieldsUpper := map[string][]string{}
-			indexFieldParams := map[string][]string{}
-			indexFieldItemArgs := map[string][]string{}
-
-			//for _, desc := range descs {
-			tableSet := tableSets[table]
-			for _, desc := range tableSet.Fields {
-				if desc.Index != "" {
-					idx := TableIndex{
-						//Non_unique:   0,
-						//Key_name:     "",
-						//Seq_in_index: 0,
-						Column_name: desc.Name,
-					}
-					switch desc.Index {
-					case "pk":
-						idx.Key_name = "PRIMARY"
-					case "unique":
-						idx.Key_name = fmt.Sprint("uk_", table, "_", desc.Name)
-						if desc.IndexGroup != "" {
-							idx.Key_name = fmt.Sprint("uk_", table, "_", desc.IndexGroup)
-						}
-					case "fulltext":
-						idx.Non_unique = 1
-						idx.Key_name = fmt.Sprint("tk_", table, "_", desc.Name)
-					case "index":
-						idx.Non_unique = 1
-						idx.Key_name = fmt.Sprint("ik_", table, "_", desc.Name)
-					}
-					indexs = append(indexs, idx)
-					//idxP := indexs[idx.Key_name]
-					//if idxP == nil {
-					//	indexs[idx.Key_name] = &idx
-					//}else{
-					//	idxP.Column_name += " "+idx.Column_name
-					//}
-				}
-
-				if strings.Contains(desc.Extra, "auto_increment") {
-					tableData.IsAutoId = true
-					tableData.AutoIdField = u.GetUpperName(desc.Name)
-					tableData.AutoGenerated = append(tableData.AutoGenerated, desc.Name)
-				}
-
-				// DEFAULT_GENERATED on update CURRENT_TIMESTAMP
-				if strings.Contains(desc.Extra, "DEFAULT_GENERATED") {
-					if strings.Contains(desc.Extra, "on update") {
-						tableData.AutoGeneratedOnUpdate = append(tableData.AutoGeneratedOnUpdate, desc.Name)
-					} else {
-						tableData.AutoGenerated = append(tableData.AutoGenerated, desc.Name)
-					}
-				}
 
-				if desc.Name == versionField && strings.Contains(desc.Type, "bigint") && strings.Contains(desc.Type, "unsigned") {
-					tableData.HasVersion = true
-				}
-
-				for _, validFieldInfo := range validFields {
-					if desc.Name == validFieldInfo.Field && strings.Contains(desc.Type, validFieldInfo.Type) {
-						tableData.ValidWhere = " AND `" + validFieldInfo.Field + "`" + validFieldInfo.ValidOperator + validFieldInfo.ValidValue
-						tableData.ValidSet = "`" + validFieldInfo.Field + "`" + validFieldInfo.ValidSetOperator + validFieldInfo.ValidSetValue
-						tableData.InvalidSet = "`" + validFieldInfo.Field + "`" + validFieldInfo.InvalidSetOperator + validFieldInfo.InvalidSetValue
-					}
-				}
-
-				fields = append(fields, desc.Name)
-
-				typ := ""
-				defaultValue := "0"
-				options := map[string]string{}
-				if strings.Contains(desc.Type, "bigint") {
-					typ = "int64"
-				} else if strings.Contains(desc.Type, "int") {
-					typ = "int"
-				} else if strings.Contains(desc.Type, "float") {
-					typ = "float32"
-				} else if strings.Contains(desc.Type, "double") {
-					typ = "float64"
-				} else if desc.Type == "datetime" {
-					typ = "Datetime"
-					defaultValue = "\"0000-00-00 00:00:00\""
-				} else if desc.Type == "date" {
-					typ = "Date"
-					defaultValue = "\"0000-00-00\""
-				} else if desc.Type == "time" {
-					typ = "Time"
-					defaultValue = "\"00:00:00\""
-				} else if strings.HasPrefix(desc.Type, "enum(") {
-					typ = u.GetUpperName(desc.Name)
-					if !enumTypeExists[typ] {
-						enumTypeExists[typ] = true
-						a := u.SplitWithoutNone(desc.Type[5:len(desc.Type)-1], ",")
-						for _, v := range a {
-							if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
-								v = v[1 : len(v)-1]
-							}
-							options[typ+u.GetUpperName(v)] = v
-						}
-					}
-					defaultValue = "\"\""
-				} else {
-					typ = "string"
-					defaultValue = "\"\""
-				}
-				if strings.Contains(desc.Type, " unsigned") && strings.HasPrefix(typ, "int") {
-					typ = "u" + typ
-				}
-				fieldTypesForId[desc.Name] = typ // 用于ID的类型不加指针
-
-				if strings.Contains(desc.Extra, "auto_increment") && tableData.IsAutoId {
-					tableData.AutoIdFieldType = typ
-				}
-
-				//if desc.Null == "YES" || desc.Default != nil || desc.Extra == "auto_increment" {
-				if desc.Null == "YES" || strings.Contains(desc.Extra, "auto_increment") {
-					tableData.PointFields = append(tableData.PointFields, FieldData{
-						Name:    u.GetUpperName(desc.Name),
-						Type:    typ,
-						Default: defaultValue,
-						Options: options,
-					})
-					typ = "*" + typ
-				}
-				tableData.Fields = append(tableData.Fields, FieldData{
-					Name:    u.GetUpperName(desc.Name),
-					Type:    typ,
-					Default: defaultValue,
-					Options: options,
-				})
-				//if desc.Key != "PRI" {
-				//	tableData.FieldsWithoutAutoId = append(tableData.FieldsWithoutAutoId, FieldData{
-				//		Name: u.GetUpperName(desc.Name),
-				//		Type: typ,
-				//	})
-				//}
+			belongsTo := &RelationData{
+				Name:         name,
+				QueryBy:      queryBy,
+				Field:        field.Name,
+				Column:       field.Column,
+				Type:         strings.TrimPrefix(field.Type, "*"),
+				IsPoint:      strings.HasPrefix(field.Type, "*"),
+				RefTable:     refTableData.FixedTableName,
+				RefTableName: refTableData.TableName,
+				RefField:     refField.Name,
+				RefColumn:    refField.Column,
+				RefType:      strings.TrimPrefix(refField.Type, "*"),
+				RefIsPoint:   strings.HasPrefix(refField.Type, "*"),
+				Getter:       refTableData.getters[refField.Column],
 			}
+			tableData.BelongsTo = append(tableData.BelongsTo, belongsTo)
+			tableData.addRelatedTable(refTableData.FixedTableName)
 
-			for _, index := range indexs {
-				if index.Key_name == "PRIMARY" {
-					idFields = append(idFields, index.Column_name)
-					idFieldsUpper = append(idFieldsUpper, u.GetUpperName(index.Column_name))
-					idFieldParams = append(idFieldParams, fixParamName(index.Column_name)+" "+fieldTypesForId[index.Column_name])
-					idFieldItemArgs = append(idFieldItemArgs, u.StringIf(tableData.IsAutoId, "*", "")+"item."+u.GetUpperName(index.Column_name))
-				} else if index.Non_unique == 0 {
-					if uniqueFields[index.Key_name] == nil {
-						uniqueFields[index.Key_name] = make([]string, 0)
-						uniqueFieldsUpper[index.Key_name] = make([]string, 0)
-						uniqueFieldParams[index.Key_name] = make([]string, 0)
-						uniqueFieldItemArgs[index.Key_name] = make([]string, 0)
-					}
-					uniqueFields[index.Key_name] = append(uniqueFields[index.Key_name], index.Column_name)
-					uniqueFieldsUpper[index.Key_name] = append(uniqueFieldsUpper[index.Key_name], u.GetUpperName(index.Column_name))
-					uniqueFieldParams[index.Key_name] = append(uniqueFieldParams[index.Key_name], fixParamName(index.Column_name)+" "+fieldTypesForId[index.Column_name])
-					uniqueFieldItemArgs[index.Key_name] = append(uniqueFieldItemArgs[index.Key_name], u.StringIf(tableData.IsAutoId, "*", "")+"item."+u.GetUpperName(index.Column_name))
-				} else {
-					if indexFields[index.Key_name] == nil {
-						indexFields[index.Key_name] = make([]string, 0)
-						indexFieldsUpper[index.Key_name] = make([]string, 0)
-						indexFieldParams[index.Key_name] = make([]string, 0)
-						indexFieldItemArgs[index.Key_name] = make([]string, 0)
-					}
-					indexFields[index.Key_name] = append(indexFields[index.Key_name], index.Column_name)
-					indexFieldsUpper[index.Key_name] = append(indexFieldsUpper[index.Key_name], u.GetUpperName(index.Column_name))
-					indexFieldParams[index.Key_name] = append(indexFieldParams[index.Key_name], fixParamName(index.Column_name)+" "+fieldTypesForId[index.Column_name])
-					indexFieldItemArgs[index.Key_name] = append(indexFieldItemArgs[index.Key_name], u.StringIf(tableData.IsAutoId, "*", "")+"item."+u.GetUpperName(index.Column_name))
-				}
+			hasManyName := pluralName(tableData.FixedTableName)
+			if refCount[field.RefTable] > 1 {
+				hasManyName += "By" + name
 			}
-			//fmt.Println("keys: ", u.JsonP(idFields), u.JsonP(uniqueFields), u.JsonP(indexFields))
-
-			if len(idFields) > 0 {
-				tableData.PrimaryKey = &IndexField{
-					Name:       strings.Join(idFieldsUpper, ""),
-					Where:      "(`" + strings.Join(idFields, "`=? AND `") + "`=?)",
-					Args:       fixJoinParams(idFields, ", "),
-					Params:     fixJoinParams(idFieldParams, ", "),
-					ItemArgs:   strings.Join(idFieldItemArgs, ", "),
-					StringArgs: "\"" + fixJoinParams(idFields, "\", \"") + "\"",
-				}
-
-				// 将复合主键中的索引添加到 NewQuery().ByXXX
-				for i := len(idFields) - 1; i >= 0; i-- {
-					name2 := strings.Join(idFieldsUpper[0:i+1], "")
-					k2 := "Index_" + name2
-					// 唯一索引和普通索引中都不存在时创建
-					if tableData.UniqueKeys[k2] == nil && tableData.IndexKeys[k2] == nil {
-						tableData.IndexKeys[k2] = &IndexField{
-							Name:       name2,
-							Where:      "(`" + strings.Join(idFields[0:i+1], "`=? AND `") + "`=?)",
-							Args:       fixJoinParams(idFields[0:i+1], ", "),
-							Params:     fixJoinParams(idFieldParams[0:i+1], ", "),
-							ItemArgs:   strings.Join(idFieldItemArgs[0:i+1], ", "),
-							StringArgs: "\"" + fixJoinParams(idFields[0:i+1], "\", \"") + "\"",
-						}
-					}
-				}
+			if refTableData.hasMember(hasManyName) {
+				hasManyName += "List"
 			}
+			refTableData.HasMany = append(refTableData.HasMany, &RelationData{
+				Name:         hasManyName,
+				QueryBy:      queryBy,
+				Field:        belongsTo.RefField,
+				Column:       belongsTo.RefColumn,
+				Type:         belongsTo.RefType,
+				IsPoint:      belongsTo.RefIsPoint,
+				RefTable:     tableData.FixedTableName,
+				RefTableName: tableData.TableName,
+				RefField:     belongsTo.Field,
+				RefColumn:    belongsTo.Column,
+				RefType:      belongsTo.Type,
+				RefIsPoint:   belongsTo.IsPoint,
+			})
+			refTableData.addRelatedTable(tableData.FixedTableName)
+		}
+	}
+}
 
-			for k, fieldNames := range uniqueFields {
-				name1 := strings.Join(uniqueFieldsUpper[k], "")
-				k1 := "Unique_" + name1
-				if tableData.UniqueKeys[k1] == nil {
-					tableData.UniqueKeys[k1] = &IndexField{
-						Name:       name1,
-						Where:      "(`" + strings.Join(fieldNames, "`=? AND `") + "`=?)",
-						Args:       fixJoinParams(fieldNames, ", "),
-						Params:     fixJoinParams(uniqueFieldParams[k], ", "),
-						ItemArgs:   strings.Join(uniqueFieldItemArgs[k], ", "),
-						StringArgs: "\"" + fixJoinParams(fieldNames, "\", \"") + "\"",
-					}
-				}
-
-				// 将复合唯一索引中的索引添加到 NewQuery().ByXXX
-				for i := len(fieldNames) - 1; i >= 0; i-- {
-					name2 := strings.Join(uniqueFieldsUpper[k][0:i+1], "")
-					k2 := "Index_" + name2
-					// 唯一索引和普通索引中都不存在时创建
-					if tableData.UniqueKeys[k2] == nil && tableData.IndexKeys[k2] == nil {
-						tableData.IndexKeys[k2] = &IndexField{
-							Name:       name2,
-							Where:      "(`" + strings.Join(fieldNames[0:i+1], "`=? AND `") + "`=?)",
-							Args:       fixJoinParams(fieldNames[0:i+1], ", "),
-							Params:     fixJoinParams(uniqueFieldParams[k][0:i+1], ", "),
-							ItemArgs:   strings.Join(uniqueFieldItemArgs[k][0:i+1], ", "),
-							StringArgs: "\"" + fixJoinParams(fieldNames[0:i+1], "\", \"") + "\"",
-						}
-					}
-				}
-			}
+func (tableData *TableData) getField(column string) *FieldData {
+	for i := range tableData.Fields {
+		if tableData.Fields[i].Column == column {
+			return &tableData.Fields[i]
+		}
+	}
+	return nil
+}
 
-			// 将其他索引添加到 NewQuery().ByXXX
-			for k, fieldNames := range indexFields {
-				for i := range fieldNames {
-					name := strings.Join(indexFieldsUpper[k][0:i+1], "")
-					k2 := "Index_" + name
-					// 唯一索引和普通索引中都不存在时创建
-					if tableData.UniqueKeys[k2] == nil && tableData.IndexKeys[k2] == nil {
-						tableData.IndexKeys[k2] = &IndexField{
-							Name:       name,
-							Where:      "(`" + strings.Join(fieldNames[0:i+1], "`=? AND `") + "`=?)",
-							Args:       fixJoinParams(fieldNames[0:i+1], ", "),
-							Params:     fixJoinParams(indexFieldParams[k][0:i+1], ", "),
-							ItemArgs:   strings.Join(indexFieldItemArgs[k][0:i+1], ", "),
-							StringArgs: "\"" + fixJoinParams(fieldNames[0:i+1], "\", \"") + "\"",
-						}
-					}
-				}
-			}
-			tableData.SelectFields = "`" + strings.Join(fields, "`, `") + "`"
+// hasMember 检查 Item 上是否已经存在同名的字段或方法
+func (tableData *TableData) hasMember(name string) bool {
+	switch name {
+	case "Save", "Enable", "Disable", "Delete", "SetByField", "To", "ToWithoutPrefix":
+		return true
+	}
+	for _, field := range tableData.Fields {
+		if name == field.Name || name == "Set"+field.Name || name == field.Name+"Value" {
+			return true
+		}
+	}
+	for _, relation := range tableData.BelongsTo {
+		if name == relation.Name {
+			return true
+		}
+	}
+	for _, relation := range tableData.HasMany {
+		if name == relation.Name {
+			return true
+		}
+	}
+	return false
+}
 
-			err := writeWithTpl(tableFile, tableTpl, tableData)
-			if err != nil {
-				if logger != nil {
-					logger.Error("failed to make dao", "tableName", table, "tableFile", tableFile, "err", err.Error())
-				} else {
-					fmt.Println(" -", table, u.Red(err.Error()))
-				}
-			} else {
-				if logger != nil {
-					logger.Info("make dao success", "tableName", table, "tableFile", tableFile)
-				} else {
-					fmt.Println(" -", table, u.Green("OK"))
-				}
-			}
+func (tableData *TableData) addRelatedTable(fixedTableName string) {
+	for _, t := range tableData.RelatedTables {
+		if t == fixedTableName {
+			return
 		}
-	} else {
-		return errors.New("failed to parse desc")
 	}
-	return nil
+	tableData.RelatedTables = append(tableData.RelatedTables, fixedTableName)
+}
+
+// pluralName 简单的英文复数形式，用于 has-many 的方法名
+func pluralName(name string) string {
+	lowerName := strings.ToLower(name)
+	if strings.HasSuffix(lowerName, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(lowerName[len(lowerName)-2])) {
+		return name[0:len(name)-1] + "ies"
+	}
+	if strings.HasSuffix(lowerName, "s") || strings.HasSuffix(lowerName, "x") || strings.HasSuffix(lowerName, "ch") || strings.HasSuffix(lowerName, "sh") {
+		return name + "es"
+	}
+	return name + "s"
 }
 
 func MakeDBFromDesc(conn *db.DB, desc string, logger *log.Logger) error {
@@ -1089,12 +1274,113 @@ func MakeDBFromDesc(conn *db.DB, desc string, logger *log.Logger) error {
 	}
 }
 
+// MakePlanFromDesc 计算描述文件与数据库的差异，只返回需要变更的表，不执行任何SQL
+func MakePlanFromDesc(conn *db.DB, desc string, logger *log.Logger) ([]*TablePlan, error) {
+	tablesByGroup := MakeERFromDesc(conn.Config.Type, desc)
+	if tablesByGroup == nil {
+		return nil, errors.New("failed to parse desc")
+	}
+
+	plans := make([]*TablePlan, 0)
+	for _, group := range tablesByGroup {
+		for _, table := range group.Tables {
+			plan, err := MakeTablePlan(conn, table)
+			if err != nil {
+				if logger != nil {
+					logger.Error("failed to make table plan", "tableName", table.Name, "err", err.Error())
+				}
+				return nil, err
+			}
+			if len(plan.Sqls) > 0 {
+				plans = append(plans, plan)
+			}
+		}
+	}
+	return plans, nil
+}
+
+// MakeDiffFromDesc 对比描述文件与数据库，返回存在差异的表，包括数据库中存在但描述文件中没有的表
+func MakeDiffFromDesc(conn *db.DB, desc string, logger *log.Logger) ([]*TablePlan, error) {
+	tablesByGroup := MakeERFromDesc(conn.Config.Type, desc)
+	if tablesByGroup == nil {
+		return nil, errors.New("failed to parse desc")
+	}
+
+	plans := make([]*TablePlan, 0)
+	tableExists := map[string]bool{}
+	for _, group := range tablesByGroup {
+		for _, table := range group.Tables {
+			tableExists[table.Name] = true
+			plan, err := MakeTablePlan(conn, table)
+			if err != nil {
+				if logger != nil {
+					logger.Error("failed to make table plan", "tableName", table.Name, "err", err.Error())
+				}
+				return nil, err
+			}
+			if len(plan.Diffs) > 0 {
+				plans = append(plans, plan)
+			}
+		}
+	}
+
+	tables, err := GetTables(conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if tableExists[table] || strings.HasPrefix(table, "_") || strings.HasPrefix(table, ".") {
+			continue
+		}
+		plans = append(plans, &TablePlan{
+			Name:  table,
+			Sqls:  make([]string, 0),
+			Diffs: []TableDiff{{Type: "table", Action: "drop", Name: table}},
+		})
+	}
+	return plans, nil
+}
+
+// MakeDiffText 将差异输出为便于阅读的文本，+ 表示描述文件中新增，- 表示描述文件中已删除，~ 表示有修改
+func MakeDiffText(plans []*TablePlan) string {
+	out := make([]string, 0)
+	for _, plan := range plans {
+		out = append(out, strings.TrimSpace(plan.Name+" "+plan.Comment))
+		for _, diff := range plan.Diffs {
+			flag := "~"
+			switch diff.Action {
+			case "add":
+				flag = "+"
+			case "drop":
+				flag = "-"
+			case "rename":
+				flag = ">"
+			}
+			line := fmt.Sprint("  ", flag, " ", diff.Type, " ", diff.Name)
+			if diff.Old != "" || diff.New != "" {
+				line += ": " + diff.Old
+				if diff.Action == "change" || diff.Action == "rename" {
+					line += " => "
+				}
+				line += diff.New
+			}
+			if diff.Level != "" && diff.Level != "safe" {
+				line += " [" + diff.levelText() + "]"
+			}
+			out = append(out, line)
+		}
+		out = append(out, "")
+	}
+	return strings.Join(out, "\n")
+}
+
 type ERGroup struct {
 	Name   string
 	Tables []*TableStruct
 }
 
 func MakeERFromDesc(dbType string, desc string) []*ERGroup {
+	dbType = fixDBType(dbType)
 
 	//tablesByGroup := map[string]map[string]*TableStruct{}
 	tablesByGroup := make([]*ERGroup, 0)
@@ -1106,6 +1392,13 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 	lastTableComment := ""
 	spliter := regexp.MustCompile(`\s+`)
 	wnMatcher := regexp.MustCompile(`^([a-zA-Z]+)([0-9]+)$`)
+	// 带位置、前缀长度、方向的索引，如 I1:2、U1:1-、I(20)、PK:2
+	indexMatcher := regexp.MustCompile(`^(PK|I|U)([0-9]*)(:[0-9]+)?(\([0-9]+\))?(-?)$`)
+	// 表名后的选项，如 engine=InnoDB、partition="HASH(userId) PARTITIONS 8"
+	optionMatcher := regexp.MustCompile(`(\w+)=("[^"]*"|\S*)`)
+	// 定点数 dec10_2，枚举 e(a,b,c)，集合 s(a,b,c)
+	decMatcher := regexp.MustCompile(`^dec([0-9]+)(_([0-9]+))?$`)
+	enumMatcher := regexp.MustCompile(`^(e|s)\((.+)\)$`)
 	lines := strings.Split(desc, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -1128,7 +1421,7 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 		}
 
 		a := spliter.Split(line, 10)
-		if len(a) == 1 {
+		if len(a) == 1 || a[1] == "allowDrop" || strings.Contains(a[1], "=") {
 			lastTableName = a[0]
 			lastTableComment = comment
 			lastTable = &TableStruct{
@@ -1136,6 +1429,28 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 				Comment: lastTableComment,
 				Fields:  make([]TableField, 0),
 			}
+			for _, m := range optionMatcher.FindAllStringSubmatch(line, -1) {
+				value := strings.Trim(m[2], "\"")
+				switch m[1] {
+				case "engine":
+					lastTable.Engine = value
+				case "charset":
+					lastTable.Charset = value
+				case "collate":
+					lastTable.Collate = value
+				case "rowFormat":
+					lastTable.RowFormat = value
+				case "partition":
+					lastTable.Partition = value
+				case "autoIncrement":
+					lastTable.AutoIncrement = u.Int(value)
+				}
+			}
+			for _, v := range a[1:] {
+				if v == "allowDrop" {
+					lastTable.AllowDrop = true
+				}
+			}
 			if lastGroup == nil {
 				lastGroup = &ERGroup{
 					Name:   "Default",
@@ -1162,6 +1477,73 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 			}
 
 			for i := 1; i < len(a); i++ {
+				if strings.HasPrefix(a[i], ">") {
+					// 引用其他表的字段，>User.id，省略字段时默认为 id
+					ref := strings.SplitN(a[i][1:], ".", 2)
+					field.RefTable = ref[0]
+					field.RefField = "id"
+					if len(ref) == 2 && ref[1] != "" {
+						field.RefField = ref[1]
+					}
+					continue
+				}
+				if strings.HasPrefix(a[i], "collate=") {
+					// 字段的排序规则，如 collate=utf8mb4_bin
+					field.Collate = a[i][8:]
+					continue
+				}
+				if strings.HasPrefix(a[i], "<") {
+					// 字段重命名，<oldName，导入时使用 RENAME COLUMN 保留数据
+					field.OldName = a[i][1:]
+					continue
+				}
+				if im := indexMatcher.FindStringSubmatch(a[i]); im != nil && (im[3] != "" || im[4] != "" || im[5] != "") {
+					tag := im[1]
+					if tag == "PK" && strings.HasPrefix(dbType, "sqlite") {
+						tag = "U"
+						im[2] = sqlitePrimaryGroup
+						field.Null = typeMapping[dbType]["nn"]
+					}
+					field.Index = typeMapping[dbType][tag]
+					if tag == "PK" {
+						field.Null = typeMapping[dbType]["nn"]
+					} else {
+						field.IndexGroup = im[2]
+						field.IndexDesc = im[5] == "-"
+						if im[4] != "" {
+							field.IndexLen = u.Int(im[4][1 : len(im[4])-1])
+						}
+					}
+					if im[3] != "" {
+						field.IndexPos = u.Int(im[3][1:])
+					}
+					continue
+				}
+				if dm := decMatcher.FindStringSubmatch(a[i]); dm != nil || a[i] == "dec" {
+					size := "10,0"
+					if dm != nil {
+						size = dm[1] + "," + u.StringIf(dm[3] != "", dm[3], "0")
+					}
+					field.DaoType = "decimal(" + size + ")"
+					if dbType == "pgsql" {
+						// pgsql 中 numeric(10,0) 显示为 numeric(10)
+						size = strings.TrimSuffix(size, ",0")
+					}
+					field.Type = typeMapping[dbType]["dec"] + "(" + size + ")"
+					continue
+				}
+				if em := enumMatcher.FindStringSubmatch(a[i]); em != nil {
+					values := make([]string, 0)
+					for _, v := range strings.Split(em[2], ",") {
+						values = append(values, "'"+strings.ReplaceAll(v, "'", "''")+"'")
+					}
+					field.Type = typeMapping[dbType][em[1]]
+					if field.Type == "ENUM" || field.Type == "SET" {
+						field.Type += "(" + strings.Join(values, ",") + ")"
+					}
+					field.DaoType = u.StringIf(em[1] == "e", "enum", "set") + "(" + strings.Join(values, ",") + ")"
+					continue
+				}
 				wn := wnMatcher.FindStringSubmatch(a[i])
 				tag := a[i]
 				size := 0
@@ -1169,13 +1551,13 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 					tag = wn[1]
 					size = u.Int(wn[2])
 				}
-				if tag == "PK" && strings.HasPrefix(dbType, "sqlite") {
-					tag = "U99"
-				}
 				switch tag {
 				case "PK":
 					field.Index = typeMapping[dbType][tag]
 					field.Null = typeMapping[dbType]["nn"]
+					if strings.HasPrefix(dbType, "sqlite") {
+						field.IndexGroup = sqlitePrimaryGroup
+					}
 				case "I":
 					field.Index = typeMapping[dbType][tag]
 				case "AI":
@@ -1192,6 +1574,7 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 				case "ctu":
 					field.Default = typeMapping[dbType][tag]
 					field.Null = typeMapping[dbType]["nn"]
+					field.OnUpdate = true
 				case "n":
 					field.Null = typeMapping[dbType][tag]
 				case "nn":
@@ -1240,6 +1623,17 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 					field.Type = typeMapping[dbType][tag]
 				case "bb":
 					field.Type = typeMapping[dbType][tag]
+				case "lt", "mt":
+					field.Type = typeMapping[dbType][tag]
+				case "j", "ts", "y":
+					field.Type = typeMapping[dbType][tag]
+					field.DaoType = strings.ToLower(typeMapping["mysql"][tag])
+				case "vb", "bn", "bit":
+					field.Type = typeMapping[dbType][tag]
+					field.DaoType = strings.ToLower(typeMapping["mysql"][tag])
+					if size == 0 && tag == "bit" && strings.HasPrefix(field.Type, "BIT") && dbType != "sqlserver" {
+						field.Type += "(1)"
+					}
 				default:
 				}
 
@@ -1253,6 +1647,12 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 						// 唯一索引分组
 						field.Index = typeMapping[dbType][tag]
 						field.IndexGroup = u.String(size)
+					case "vb", "bn", "bit":
+						// pgsql 的 BYTEA、sqlite 的 BLOB 等没有长度
+						if strings.Contains(field.Type, "BINARY") || field.Type == "RAW" || (field.Type == "BIT" && dbType != "sqlserver") {
+							field.Type += fmt.Sprintf("(%d)", size)
+						}
+						field.DaoType += fmt.Sprintf("(%d)", size)
 					default:
 						// 带长度的类型
 						field.Type += fmt.Sprintf("(%d)", size)
@@ -1268,6 +1668,7 @@ func MakeERFromDesc(dbType string, desc string) []*ERGroup {
 }
 
 func MakeERFile(dbType, desc, dbName string, erOutFile string, logger *log.Logger) {
+	dbType = fixDBType(dbType)
 	tablesByGroup := MakeERFromDesc(dbType, desc)
 	// 创建ER图文件
 	tpl := template.New(erOutFile).Funcs(template.FuncMap{
@@ -1309,8 +1710,46 @@ func MakeERFile(dbType, desc, dbName string, erOutFile string, logger *log.Logge
 	}
 }
 
+// loadTemplate 优先使用 Templates.Path 中的同名模版
+func loadTemplate(name, builtin string) (string, error) {
+	if Templates.Path != "" {
+		file := path.Join(Templates.Path, name)
+		if u.FileExists(file) {
+			return u.ReadFile(file)
+		}
+	}
+	return builtin, nil
+}
+
+// writeExtraFiles 生成 Templates.Tables、Templates.Files 中配置的文件
+func writeExtraFiles(dbPath string, files map[string]string, table string, data interface{}) error {
+	for outFile, tplFile := range files {
+		content, err := u.ReadFile(path.Join(Templates.Path, tplFile))
+		if err != nil {
+			return err
+		}
+		filename := path.Join(dbPath, strings.ReplaceAll(outFile, "{{table}}", table))
+		u.CheckPath(filename)
+		if err = writeWithTpl(filename, content, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportTemplates 导出内置的模版，作为自定义模版的基础
+func ExportTemplates(dir string) error {
+	if err := u.WriteFile(path.Join(dir, "a_config.go.tpl"), configTpl); err != nil {
+		return err
+	}
+	if err := u.WriteFile(path.Join(dir, "a_mock.go.tpl"), mockTpl); err != nil {
+		return err
+	}
+	return u.WriteFile(path.Join(dir, "a_table.go.tpl"), tableTpl)
+}
+
 func writeWithTpl(filename, tplContent string, data interface{}) error {
-	tpl, err := template.New(filename).Parse(tplContent)
+	tpl, err := template.New(filename).Funcs(TemplateFuncs).Parse(tplContent)
 	if err == nil {
 		exists := u.FileExists(filename)
 		if exists {
@@ -0,0 +1,136 @@
+package dao
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/u"
+	_ "modernc.org/sqlite"
+)
+
+// TestGeneratedCode 从 testdata/gen/er.txt 生成代码和 SQLite 数据库，在临时模块中编译并运行 testdata/gen/main.go
+// redis、s 使用 testdata/stubs 中的替代包，ReturnError、OptimisticLock 的每种组合分别生成和运行
+func TestGeneratedCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip compiling generated code in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	defer func() { ReturnError, OptimisticLock = false, false }()
+	for _, returnError := range []bool{false, true} {
+		t.Run(fmt.Sprint("ReturnError=", returnError), func(t *testing.T) {
+			for _, optimisticLock := range []bool{false, true} {
+				ReturnError, OptimisticLock = returnError, optimisticLock
+				options := make([]string, 0)
+				if returnError {
+					options = append(options, "returnError")
+				}
+				if optimisticLock {
+					options = append(options, "optimisticLock")
+				}
+				t.Run(fmt.Sprint("OptimisticLock=", optimisticLock), func(t *testing.T) {
+					testGeneratedCode(t, goBin, options)
+				})
+			}
+		})
+	}
+}
+
+// testGeneratedCode 使用当前的 ReturnError、OptimisticLock 选项生成代码并运行，options 为传给 main.go 的选项
+func testGeneratedCode(t *testing.T, goBin string, options []string) {
+	wd, _ := os.Getwd()
+	desc := u.ReadFileN(filepath.Join(wd, "testdata/gen/er.txt"))
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	// 与 dao -c 一样默认按 MySQL 生成，从 SQLite 生成的代码相同（见 TestMakeDaoFromSqlite）
+	if err := MakeDaoFromDesc("mysql", desc, "app", nil); err != nil {
+		t.Fatal("make dao failed: ", err)
+	}
+	dbFile := filepath.Join(dir, "test.db")
+	conn := db.GetDB("sqlite://"+dbFile, nil)
+	if err := MakeDBFromDesc(conn, desc, nil); err != nil {
+		t.Fatal("make db failed: ", err)
+	}
+	_ = conn.Destroy()
+
+	// 使用 dao 的依赖版本，redis、s 替换为 testdata 中的包
+	goMod := u.ReadFileN(filepath.Join(wd, "../go.mod"))
+	goMod = strings.Replace(goMod, "module github.com/ssgo/dao", "module gentest", 1)
+	goMod += "\nrequire (\n\tgithub.com/ssgo/redis v0.0.0\n\tgithub.com/ssgo/s v0.0.0\n)\n"
+	goMod += "\nreplace github.com/ssgo/redis => " + filepath.Join(wd, "testdata/stubs/redis") + "\n"
+	goMod += "\nreplace github.com/ssgo/s => " + filepath.Join(wd, "testdata/stubs/s") + "\n"
+	_ = u.WriteFile(filepath.Join(dir, "go.mod"), goMod)
+	_ = u.WriteFile(filepath.Join(dir, "go.sum"), u.ReadFileN(filepath.Join(wd, "../go.sum")))
+	_ = u.WriteFile(filepath.Join(dir, "main.go"), u.ReadFileN(filepath.Join(wd, "testdata/gen/main.go")))
+
+	for _, args := range [][]string{{"vet", "./..."}, append([]string{"run", ".", dbFile}, options...)} {
+		cmd := exec.Command(goBin, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s failed: %s\n%s", args[0], err, out)
+		}
+	}
+}
+
+// TestMakeDaoFromSqlite 从 er.txt 创建的 SQLite 数据库生成的代码与按 MySQL 从 er.txt 生成的代码相同
+// SQLite 中没有注释，关联的表从外键读取
+func TestMakeDaoFromSqlite(t *testing.T) {
+	EnableForeignKey = true
+	defer func() { EnableForeignKey = false }()
+	wd, _ := os.Getwd()
+	desc := u.ReadFileN(filepath.Join(wd, "testdata/gen/er.txt"))
+	dir := t.TempDir()
+	defer func() { _ = os.Chdir(wd) }()
+
+	descDir := filepath.Join(dir, "desc")
+	_ = os.Mkdir(descDir, 0755)
+	_ = os.Chdir(descDir)
+	if err := MakeDaoFromDesc("mysql", regexp.MustCompile(` *//.*`).ReplaceAllString(desc, ""), "app", nil); err != nil {
+		t.Fatal("make dao from desc failed: ", err)
+	}
+
+	dbDir := filepath.Join(dir, "db")
+	_ = os.Mkdir(dbDir, 0755)
+	_ = os.Chdir(dbDir)
+	conn := db.GetDB("sqlite://"+filepath.Join(dir, "app.db"), nil)
+	defer func() { _ = conn.Destroy() }()
+	if err := MakeDBFromDesc(conn, desc, nil); err != nil {
+		t.Fatal("make db failed: ", err)
+	}
+	if err := MakeDaoFromDB(conn, nil); err != nil {
+		t.Fatal("make dao from db failed: ", err)
+	}
+
+	files, _ := u.ReadDir(filepath.Join(descDir, "appDao"))
+	if len(files) == 0 {
+		t.Fatal("no files generated")
+	}
+	for _, file := range files {
+		expected := u.ReadFileN(filepath.Join(descDir, "appDao", file.Name))
+		lines := strings.Split(u.ReadFileN(filepath.Join(dbDir, "appDao", file.Name)), "\n")
+		expectedLines := strings.Split(expected, "\n")
+		for i := range expectedLines {
+			if i >= len(lines) || lines[i] != expectedLines[i] {
+				t.Errorf("%s:%d generated from sqlite: %q, expected: %q", file.Name, i+1, strings.Join(lines[i:min(i+3, len(lines))], "\n"), strings.Join(expectedLines[i:min(i+3, len(expectedLines))], "\n"))
+				break
+			}
+		}
+		if len(lines) > len(expectedLines) {
+			t.Errorf("%s generated from sqlite has more lines", file.Name)
+		}
+	}
+}
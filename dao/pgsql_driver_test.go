@@ -0,0 +1,6 @@
+//go:build pgsql
+
+package dao
+
+// 注册 PostgreSQL 测试使用的 pgx 驱动，go.mod 中没有这个依赖，需要先 go get github.com/jackc/pgx/v5
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,511 @@
+// 由 TestGeneratedCode 复制到临时模块中，使用从 er.txt 生成的代码操作 SQLite 数据库，检查失败时以非 0 退出
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	app "gentest/appDao"
+
+	"github.com/ssgo/db"
+	"github.com/ssgo/log"
+	"github.com/ssgo/u"
+	_ "modernc.org/sqlite"
+)
+
+var failed = false
+
+// returnError、optimisticLock 与生成代码时的选项相同，由 TestGeneratedCode 通过参数传入
+var returnError = false
+var optimisticLock = false
+
+func check(name string, ok bool, args ...any) {
+	if !ok {
+		failed = true
+		fmt.Println("FAIL", name, fmt.Sprint(args...))
+	}
+}
+
+// writeResult 写入方法的返回值，ReturnError 为 false 时返回 ok bool，为 true 时返回 err error
+type writeResult struct {
+	ok      bool
+	err     error
+	id      int64
+	version uint64
+}
+
+// result 按类型读取写入方法的返回值，使同一份代码可以测试两种模式，int64 为自增ID或删除的数量，uint64 为版本
+func result(values ...any) writeResult {
+	r := writeResult{ok: true}
+	for _, v := range values {
+		switch x := v.(type) {
+		case bool:
+			r.ok = x
+		case error:
+			r.ok, r.err = false, x
+		case int64:
+			r.id = x
+		case uint64:
+			r.version = x
+		}
+	}
+	return r
+}
+
+// is ReturnError 为 true 时检查返回的 error，为 false 时只能判断失败
+func (r writeResult) is(target error) bool {
+	return !r.ok && (!returnError || errors.Is(r.err, target))
+}
+
+func main() {
+	conn := db.GetDB("sqlite://"+os.Args[1], log.DefaultLogger)
+	returnError = u.StringIn(os.Args[2:], "returnError")
+	optimisticLock = u.StringIn(os.Args[2:], "optimisticLock")
+	serve := app.New(conn, nil)
+	testEnums(serve)
+	testTransactionVersion(serve)
+	testDecimalAndJson(serve)
+	testErrors(serve)
+	testOptimisticLock(serve)
+	testSync(serve)
+	testBatch(serve)
+	testUpsert(serve)
+	testRelations(serve)
+	testMockQuery()
+	testEachInTransaction(serve)
+	testContext(serve)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// 不同表中的同名枚举字段使用各自的类型和值
+func testEnums(serve *app.Serve) {
+	users := serve.GetUserDao(nil)
+	devices := serve.GetDeviceDao(nil)
+
+	userId, name := "u1", "tom"
+	valid := uint(1)
+	user := users.New()
+	user.Id, user.Name, user.IsValid = userId, &name, &valid
+	check("set user status", user.SetStatusValue(app.UserStatusBlocked) == nil)
+	check("invalid user status", !app.UserStatus("on").IsValid())
+	check("insert user", result(users.Insert(user)).ok, users.LastError())
+
+	on := app.DeviceStatusOn
+	check("insert device with its own status", result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on})).ok, devices.LastError())
+
+	blocked := app.DeviceStatus(app.UserStatusBlocked)
+	check("insert device with user status", !result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &blocked})).ok)
+
+	_, err := app.ParseDeviceStatus("off")
+	check("parse device status", err == nil, err)
+	_, err = app.ParseUserStatus("off")
+	check("parse user status", err != nil)
+}
+
+// 事务中的版本号在这个事务中分配，SQLite 中另开事务会因为外层事务持有写锁而失败
+func testTransactionVersion(serve *app.Serve) {
+	userId := "u1"
+	on := app.DeviceStatusOn
+	devices, tx := serve.GetDeviceDao(nil).NewTransaction()
+	r1 := result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on}))
+	check("insert device in transaction", r1.ok, devices.LastError())
+	check("read device in transaction", devices.Get(uint64(r1.id)) != nil, devices.LastError())
+	r2 := result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on}))
+	check("insert device again in transaction", r2.ok, devices.LastError())
+	check("version in transaction", r2.version == r1.version+1, r1.version, r2.version)
+	check("commit", tx.Commit() == nil)
+
+	devices, tx = serve.GetDeviceDao(nil).NewTransaction()
+	check("insert device before rollback", result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on})).ok, devices.LastError())
+	check("rollback", tx.Rollback() == nil)
+
+	r3 := result(serve.GetDeviceDao(nil).Insert(&app.DeviceItem{UserId: &userId, Status: &on}))
+	check("version after rollback", r3.ok && r3.version == r2.version+1, r2.version, r3.version)
+}
+
+// Decimal 总是使用指针读取，Json 以字符串写入，输出JSON时保持原样
+func testDecimalAndJson(serve *app.Serve) {
+	products := serve.GetProductDao(nil)
+	price := app.DecimalByFloat(12.5, 2)
+	r1 := result(products.Insert(&app.ProductItem{Price: &price, Attrs: app.JsonByValue(map[string]string{"color": "red"})}))
+	check("insert product", r1.ok, products.LastError())
+	r2 := result(products.Insert(&app.ProductItem{Price: &price}))
+	check("insert product without attrs", r2.ok, products.LastError())
+
+	product := products.Get(uint64(r1.id))
+	if product == nil {
+		check("get product", false, products.LastError())
+		return
+	}
+	check("decimal", app.DecimalFloat(product.PriceValue()) == 12.5 && product.Discount == nil, product.PriceValue(), product.Discount)
+	attrs := map[string]string{}
+	check("json to", product.Attrs.To(&attrs) == nil && attrs["color"] == "red", attrs)
+	out := map[string]any{}
+	_ = json.Unmarshal([]byte(u.Json(product)), &out)
+	check("json output", fmt.Sprint(out["Attrs"]) == "map[color:red]", out["Attrs"])
+
+	product = products.Get(uint64(r2.id))
+	check("null json", product != nil && product.Attrs == nil, product)
+}
+
+// 主键重复、没有修改任何数据、Item 没有 Dao 时失败，ReturnError 为 true 时可以使用 errors.Is 判断
+func testErrors(serve *app.Serve) {
+	users := serve.GetUserDao(nil)
+	products := serve.GetProductDao(nil)
+	active := app.UserStatusActive
+	check("duplicate key", result(users.Insert(&app.UserItem{Id: "u1", Status: &active})).is(app.ErrDuplicateKey), users.LastError())
+	check("duplicate key error", returnError || users.LastError() != nil, users.LastError())
+
+	price := app.DecimalByFloat(1, 2)
+	r := result(products.Update(map[string]any{"price": price}, uint64(999999)))
+	check("update not found", r.is(app.ErrNotFound), r.err)
+	r = result(products.Delete(999999))
+	check("delete not found", r.is(app.ErrNotFound), r.err)
+	r = result(users.Update(map[string]any{"name": "nobody"}, "u0"))
+	check("update versioned not found", r.is(app.ErrNotFound), r.err)
+
+	r = result((&app.ProductItem{Price: &price}).Save())
+	check("save without dao", r.is(app.ErrNoDao), r.err)
+
+	r = result(products.Insert(&app.ProductItem{Price: &price}))
+	check("insert returns id", r.ok && r.err == nil && r.id > 0, r)
+	check("delete", result(products.Delete(uint64(r.id))).ok, products.LastError())
+}
+
+// UpdateWithVersion 只修改版本相同的数据，OptimisticLock 为 true 时 Item.Save 使用读取时的版本，否则后保存的覆盖先保存的
+func testOptimisticLock(serve *app.Serve) {
+	users := serve.GetUserDao(nil)
+	user := users.Get("u1")
+	if user == nil {
+		check("get user", false, users.LastError())
+		return
+	}
+	r := result(users.UpdateWithVersion(map[string]any{"name": "v1", "version": 1}, "u1", user.VersionValue()))
+	check("update with version", r.ok && r.version > user.VersionValue(), r.err, users.LastError())
+	check("update with version data", users.Get("u1").NameValue() == "v1" && users.Get("u1").VersionValue() == r.version, users.Get("u1"))
+	r = result(users.UpdateWithVersion(map[string]any{"name": "v2"}, "u1", user.VersionValue()))
+	check("update with old version", r.is(app.ErrVersionConflict) && errors.Is(users.LastError(), app.ErrVersionConflict), r.err, users.LastError())
+	check("update with old version data", users.Get("u1").NameValue() == "v1", users.Get("u1"))
+
+	first, second := users.Get("u1"), users.Get("u1")
+	first.SetNameValue("first")
+	r = result(first.Save())
+	check("save first", r.ok, r.err, users.LastError())
+	second.SetNameValue("second")
+	r2 := result(second.Save())
+	if optimisticLock {
+		check("item version after save", first.VersionValue() == r.version, first.VersionValue(), r.version)
+		check("save with old version", r2.is(app.ErrVersionConflict) && users.Get("u1").NameValue() == "first", r2.err, users.Get("u1"))
+		first.SetNameValue("again")
+		check("save again", result(first.Save()).ok && users.Get("u1").NameValue() == "again", users.LastError())
+
+		// 新建的 Item 插入后使用插入时的版本修改
+		active, valid := app.UserStatusActive, uint(1)
+		item := users.New()
+		item.Id, item.Status, item.IsValid = "u2", &active, &valid
+		r = result(item.Save())
+		check("save new item", r.ok && item.VersionValue() == r.version, r.err, users.LastError())
+		item.SetNameValue("new")
+		check("save inserted item", result(item.Save()).ok && users.Get("u2").NameValue() == "new", users.LastError())
+	} else {
+		check("save overwrites", r2.ok && users.Get("u1").NameValue() == "second", r2.err, users.Get("u1"))
+	}
+}
+
+// Sync 按版本读取变化，同一个版本的多条数据总是在同一批中读完，增量同步返回 Disable 的数据作为墓碑，全量同步不返回
+func testSync(serve *app.Serve) {
+	users := serve.GetUserDao(nil)
+	active, valid := app.UserStatusActive, uint(1)
+	for _, id := range []string{"s1", "s2", "s3", "s4"} {
+		check("insert user for sync", result(users.Insert(&app.UserItem{Id: id, Status: &active, IsValid: &valid})).ok, users.LastError())
+	}
+	cursor, err := users.Sync(0, 2, func(changes []app.UserChange) error { return nil })
+	check("sync all", err == nil && cursor > 0, err, cursor)
+
+	// UpdateBy 修改的多条数据使用同一个版本，超过 batch 时在一批中读取这个版本的全部数据
+	r := result(users.UpdateBy(map[string]any{"name": "synced"}, "`id` LIKE 's%'"))
+	check("update by for sync", r.ok, r.err, users.LastError())
+	batches := make([][]string, 0)
+	next, err := users.Sync(cursor, 2, func(changes []app.UserChange) error {
+		ids := make([]string, 0)
+		for _, change := range changes {
+			check("sync one version change", change.Version == r.version && !change.Deleted && change.Item.NameValue() == "synced", change)
+			ids = append(ids, change.Item.Id)
+		}
+		batches = append(batches, ids)
+		return nil
+	})
+	check("sync one version", err == nil && next == r.version && len(batches) == 1 && len(batches[0]) == 4, err, next, r.version, batches)
+
+	// handler 失败时返回的版本不包含这一批
+	failedCursor, err := users.Sync(cursor, 2, func(changes []app.UserChange) error { return errors.New("stop") })
+	check("sync handler error", err != nil && failedCursor == cursor, err, failedCursor)
+
+	// 增量同步返回墓碑，全量同步跳过
+	d := result(users.Disable("s1"))
+	check("disable for sync", d.ok, d.err, users.LastError())
+	deleted := map[string]bool{}
+	next, err = users.Sync(next, 0, func(changes []app.UserChange) error {
+		for _, change := range changes {
+			deleted[change.Item.Id] = change.Deleted
+		}
+		return nil
+	})
+	check("sync tombstone", err == nil && next == d.version && len(deleted) == 1 && deleted["s1"], err, next, deleted)
+	deleted = map[string]bool{}
+	_, err = users.Sync(0, 2, func(changes []app.UserChange) error {
+		for _, change := range changes {
+			deleted[change.Item.Id] = change.Deleted
+		}
+		return nil
+	})
+	_, hasTombstone := deleted["s1"]
+	check("full sync skips tombstones", err == nil && !hasTombstone && len(deleted) >= 3 && !deleted["s2"], err, deleted)
+}
+
+// InsertMany 分批插入，SQLite 从每条语句最后一行的自增ID推算出每一行的ID，GetMany、DeleteMany 按主键批量读取和删除
+func testBatch(serve *app.Serve) {
+	app.MaxPlaceholders["sqlite"] = 6
+	defer func() { app.MaxPlaceholders["sqlite"] = 999 }()
+	devices := serve.GetDeviceDao(nil)
+	userId := "u1"
+	on, off := app.DeviceStatusOn, app.DeviceStatusOff
+	items := make([]*app.DeviceItem, 0)
+	for i := 0; i < 7; i++ {
+		status := &on
+		if i%2 == 1 {
+			status = &off
+		}
+		items = append(items, &app.DeviceItem{UserId: &userId, Status: status})
+	}
+	// 指定了ID的数据使用另一条 INSERT 语句，ID 保持不变
+	fixedId := uint64(1000000)
+	items = append(items[:3], append([]*app.DeviceItem{{Id: &fixedId, UserId: &userId, Status: &off}}, items[3:]...)...)
+	check("insert many", result(devices.InsertMany(items)).ok, devices.LastError())
+
+	ids := make([]uint64, 0)
+	for i, item := range items {
+		check("insert many id", item.Id != nil, i)
+		check("insert many version", item.VersionValue() == items[0].VersionValue()+uint64(i), i, item.VersionValue())
+		ids = append(ids, item.IdValue())
+	}
+	check("insert many fixed id", items[3].IdValue() == fixedId, items[3].IdValue())
+	check("insert many ids", ids[1] == ids[0]+1 && ids[2] == ids[1]+1 && ids[4] == ids[2]+1 && ids[7] == ids[4]+3, ids)
+
+	got := devices.GetMany(append(ids, 999999))
+	check("get many", len(got) == len(items), len(got))
+	for _, item := range items {
+		found := got[item.IdValue()]
+		check("get many item", found != nil && *found.Status == *item.Status && found.VersionValue() == item.VersionValue(), item.IdValue(), found)
+	}
+
+	// 事务回滚后 InsertMany 写入的数据不存在
+	txDevices, tx := serve.GetDeviceDao(nil).NewTransaction()
+	rollbackItems := []*app.DeviceItem{{UserId: &userId, Status: &on}, {UserId: &userId, Status: &on}, {UserId: &userId, Status: &on}}
+	check("insert many in transaction", result(txDevices.InsertMany(rollbackItems)).ok, txDevices.LastError())
+	check("get many in transaction", len(txDevices.GetMany([]uint64{rollbackItems[0].IdValue(), rollbackItems[2].IdValue()})) == 2)
+	check("rollback insert many", tx.Rollback() == nil)
+	check("get many after rollback", len(devices.GetMany([]uint64{rollbackItems[0].IdValue(), rollbackItems[2].IdValue()})) == 0)
+
+	r := result(devices.DeleteMany(append(ids, 999999)))
+	check("delete many", r.ok && r.id == int64(len(ids)), r.err, r.id)
+	check("get many after delete", len(devices.GetMany(ids)) == 0)
+}
+
+// Upsert 冲突时修改原来的数据，不指定字段时值为 nil 的字段写入 NULL，UpsertByXxx 使用唯一索引判断冲突并分配新的版本
+func testUpsert(serve *app.Serve) {
+	products := serve.GetProductDao(nil)
+	ownerId := "u1"
+	price, discount := app.DecimalByFloat(10, 2), app.DecimalByFloat(1, 2)
+	r := result(products.Insert(&app.ProductItem{Price: &price, Discount: &discount, OwnerId: &ownerId}))
+	check("insert product for upsert", r.ok, products.LastError())
+	id := uint64(r.id)
+
+	price = app.DecimalByFloat(20, 2)
+	check("upsert", result(products.Upsert(&app.ProductItem{Id: &id, Price: &price})).ok, products.LastError())
+	product := products.Get(id)
+	check("upsert writes null", product != nil && app.DecimalFloat(product.PriceValue()) == 20 && product.OwnerId == nil && product.Discount == nil, product)
+
+	price = app.DecimalByFloat(30, 2)
+	check("upsert columns", result(products.Upsert(&app.ProductItem{Id: &id, Price: &price, OwnerId: &ownerId}, "price")).ok, products.LastError())
+	product = products.Get(id)
+	check("upsert only columns", product != nil && app.DecimalFloat(product.PriceValue()) == 30 && product.OwnerId == nil, product)
+	check("upsert unknown column", !result(products.Upsert(&app.ProductItem{Id: &id, Price: &price}, "ownerName")).ok)
+
+	total := products.NewQuery().Count()
+	check("upsert insert", result(products.Upsert(&app.ProductItem{Price: &price, OwnerId: &ownerId})).ok && products.NewQuery().Count() == total+1, products.LastError())
+
+	devices := serve.GetDeviceDao(nil)
+	userId, sn := "u1", "sn1"
+	on, off := app.DeviceStatusOn, app.DeviceStatusOff
+	inserted := result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on, Sn: &sn}))
+	check("insert device for upsert", inserted.ok, devices.LastError())
+	r = result(devices.UpsertBySn(&app.DeviceItem{UserId: &userId, Status: &off, Sn: &sn}))
+	check("upsert by unique key", r.ok && r.version > inserted.version, r.err, devices.LastError())
+	device := devices.GetBySn(sn)
+	check("upsert by unique key data", device != nil && device.IdValue() == uint64(inserted.id) && *device.Status == off && device.VersionValue() == r.version, device)
+	check("upsert primary key conflict", result(devices.Upsert(&app.DeviceItem{UserId: &userId, Status: &on, Sn: &sn})).is(app.ErrDuplicateKey), devices.LastError())
+}
+
+// 通过 >User.id 引用生成的关联读取，事务中读取的 Item 使用同一个事务读取关联数据
+func testRelations(serve *app.Serve) {
+	users := serve.GetUserDao(nil)
+	devices := serve.GetDeviceDao(nil)
+	products := serve.GetProductDao(nil)
+	active, valid := app.UserStatusActive, uint(1)
+	check("insert user for relations", result(users.Insert(&app.UserItem{Id: "r1", Status: &active, IsValid: &valid})).ok, users.LastError())
+	userId := "r1"
+	on := app.DeviceStatusOn
+	check("insert devices for relations", result(devices.InsertMany([]*app.DeviceItem{{UserId: &userId, Status: &on}, {UserId: &userId, Status: &on}})).ok, devices.LastError())
+	price := app.DecimalByFloat(1, 2)
+	owned := result(products.Insert(&app.ProductItem{Price: &price, OwnerId: &userId}))
+	noOwner := result(products.Insert(&app.ProductItem{Price: &price}))
+	check("insert products for relations", owned.ok && noOwner.ok, products.LastError())
+
+	user := users.Get("r1")
+	if user == nil {
+		check("get user for relations", false, users.LastError())
+		return
+	}
+	list := user.Devices()
+	check("has many", len(list) == 2 && list[0].UserIdValue() == "r1", list)
+	owner := list[0].User()
+	check("belongs to", owner != nil && owner.Id == "r1", owner)
+	check("has many other table", len(user.Products()) == 1 && user.Products()[0].IdValue() == uint64(owned.id), user.Products())
+
+	byUser := users.DevicesOf([]app.UserItem{*user, *users.Get("u1")})
+	check("has many of", len(byUser["r1"]) == 2 && len(byUser["u1"]) > 0, byUser)
+	productList := []app.ProductItem{*products.Get(uint64(owned.id)), *products.Get(uint64(noOwner.id))}
+	owners := products.OwnerOf(productList)
+	check("belongs to of", len(owners) == 1 && owners["r1"] != nil && owners["r1"].Id == "r1", owners)
+	check("belongs to nil", productList[1].Owner() == nil)
+
+	// Mock 中的 Item 不能读取关联数据
+	mock := app.NewDeviceDaoMock()
+	mock.Rows = []app.DeviceItem{{UserId: &userId}}
+	check("mock relation", mock.NewQuery().First().User() == nil)
+
+	txUsers, tx := serve.GetUserDao(nil).NewTransaction()
+	defer tx.Rollback()
+	check("insert device in transaction for relations", result(serve.GetDeviceDaoByTransaction(tx, nil).Insert(&app.DeviceItem{UserId: &userId, Status: &on})).ok)
+	txUser := txUsers.Get("r1")
+	check("has many in transaction", txUser != nil && len(txUser.Devices()) == 3, txUser)
+}
+
+// Mock 返回的查询使用 Rows、RowsFunc 作为结果，读取到的 Item 属于 Mock
+func testMockQuery() {
+	mock := app.NewUserDaoMock()
+	name := "tom"
+	mock.RowsFunc = func(sql string, args []any) []app.UserItem {
+		return []app.UserItem{{Id: "u1", Name: &name}, {Id: "u2"}}
+	}
+	var users app.UserDaoInterface = mock
+
+	list := users.NewQuery().Where("`name`=?", name).List()
+	check("mock list", len(list) == 2 && list[0].Id == "u1" && list[0].NameValue() == "tom", list)
+	calls := mock.CallsOf("Query")
+	check("mock query sql", len(calls) == 1 && strings.Contains(fmt.Sprint(calls[0][0]), "`name`=?"), calls)
+	check("mock count", users.NewQuery().Count() == 2)
+	check("mock list by", len(users.NewQuery().ListBy("name")) == 2)
+	n := 0
+	check("mock each", users.NewQuery().Each(func(item *app.UserItem) error {
+		n++
+		return nil
+	}) == nil && n == 2, n)
+
+	first := users.NewQuery().First()
+	if first == nil {
+		check("mock first", false)
+		return
+	}
+	first.SetNameValue("jerry")
+	first.Save()
+	if optimisticLock {
+		calls = mock.CallsOf("UpdateWithVersion")
+	} else {
+		calls = mock.CallsOf("Update")
+	}
+	check("mock item dao", len(calls) == 1 && calls[0][1] == "u1", mock.Calls())
+
+	mock.RowsFunc = nil
+	mock.Rows = []app.UserItem{{Id: "u3"}}
+	check("mock rows", users.NewQuery().First().Id == "u3")
+	mock.Rows = nil
+	check("mock empty", len(users.NewQuery().List()) == 0 && users.NewQuery().First() == nil)
+}
+
+// 事务中分页读取时按主键排序，不会重复或遗漏
+func testEachInTransaction(serve *app.Serve) {
+	app.EachPageSize = 2
+	defer func() { app.EachPageSize = 1000 }()
+	devices, tx := serve.GetDeviceDao(nil).NewTransaction()
+	defer tx.Rollback()
+	total := devices.NewQuery().Count()
+	ids := make([]uint64, 0)
+	err := devices.NewQuery().Each(func(item *app.DeviceItem) error {
+		ids = append(ids, item.IdValue())
+		return nil
+	})
+	ordered := true
+	for i := 1; i < len(ids); i++ {
+		ordered = ordered && ids[i] > ids[i-1]
+	}
+	check("each in transaction", err == nil && total > 2 && len(ids) == total && ordered, err, total, ids)
+}
+
+// 设置了 ctx 的 Dao 使用 ctx 执行语句，超时时中断正在执行的查询，事务和版本分配也使用 ctx
+func testContext(serve *app.Serve) {
+	ctx, cancel := context.WithCancel(context.Background())
+	products := serve.GetProductDao(nil).WithContext(ctx)
+	price := app.DecimalByFloat(3.5, 2)
+	r := result(products.Insert(&app.ProductItem{Price: &price, Attrs: app.JsonByValue([]int{1, 2})}))
+	check("insert with context", r.ok, products.LastError())
+	id := r.id
+	product := products.Get(uint64(id))
+	check("get with context", product != nil && app.DecimalFloat(product.PriceValue()) == 3.5 && string(product.Attrs) == "[1,2]", product, products.LastError())
+	price = app.DecimalByFloat(4, 2)
+	check("update with context", result(products.Update(map[string]any{"price": price}, uint64(id))).ok, products.LastError())
+	list := products.NewQuery().Where("`price`=?", price).List()
+	check("list with context", len(list) == 1 && list[0].IdValue() == uint64(id), list)
+	check("count with context", products.NewQuery().Count() >= 1)
+
+	userId := "u1"
+	on := app.DeviceStatusOn
+	devices, tx := serve.GetDeviceDao(nil).WithContext(ctx).NewTransaction()
+	r = result(devices.Insert(&app.DeviceItem{UserId: &userId, Status: &on}))
+	check("insert in transaction with context", r.ok && r.version > 0, devices.LastError())
+	items := []*app.DeviceItem{{UserId: &userId, Status: &on}, {UserId: &userId, Status: &on}}
+	check("insert many in transaction with context", result(devices.InsertMany(items)).ok && items[1].IdValue() == items[0].IdValue()+1, devices.LastError())
+	check("read in transaction with context", devices.Get(uint64(r.id)) != nil, devices.LastError())
+	check("commit with context", tx.Commit() == nil)
+	r = result(serve.GetDeviceDao(nil).WithContext(ctx).DeleteMany([]uint64{items[0].IdValue(), items[1].IdValue()}))
+	check("delete many with context", r.ok && r.id == 2, r.id)
+
+	// 已取消的 ctx 不执行
+	cancel()
+	check("get with cancelled context", products.Get(uint64(id)) == nil && errors.Is(products.LastError(), context.Canceled), products.LastError())
+	r = result(serve.GetDeviceDao(nil).WithContext(ctx).Insert(&app.DeviceItem{UserId: &userId, Status: &on}))
+	check("insert with cancelled context", r.is(context.Canceled), r.err)
+
+	// 超时时由驱动中断正在执行的查询
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	slow := serve.GetProductDao(nil).WithContext(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- slow.NewQuery().Where("`id` IN (WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT x FROM c WHERE x < 0)").Query().LastError()
+	}()
+	select {
+	case err := <-done:
+		check("interrupt slow query", err != nil, err)
+	case <-time.After(10 * time.Second):
+		check("interrupt slow query", false, "query not interrupted")
+	}
+}
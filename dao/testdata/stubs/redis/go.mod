@@ -0,0 +1,5 @@
+module github.com/ssgo/redis
+
+go 1.23
+
+require github.com/ssgo/log v1.7.7
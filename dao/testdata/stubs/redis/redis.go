@@ -0,0 +1,21 @@
+// Package redis 只包含生成的代码用到的方法，用于在没有 redis 的环境中编译测试生成的代码
+package redis
+
+import "github.com/ssgo/log"
+
+type Result struct{}
+
+func (r *Result) Uint64() uint64 { return 0 }
+func (r *Result) String() string { return "" }
+
+type Redis struct{}
+
+func (rd *Redis) CopyByLogger(logger *log.Logger) *Redis { return rd }
+func (rd *Redis) INCR(key string) int64                  { return 0 }
+func (rd *Redis) Do(cmd string, values ...any) *Result   { return &Result{} }
+func (rd *Redis) SETEX(key string, s int, v any) bool    { return true }
+func (rd *Redis) DEL(keys ...string) int                 { return 0 }
+func (rd *Redis) MSET(kv ...any) bool                    { return true }
+func (rd *Redis) GET(key string) *Result                 { return &Result{} }
+func (rd *Redis) SET(key string, v any) bool             { return true }
+func (rd *Redis) EXISTS(key string) bool                 { return false }
@@ -0,0 +1,3 @@
+module github.com/ssgo/s
+
+go 1.23
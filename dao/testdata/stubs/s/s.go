@@ -0,0 +1,4 @@
+// Package s 只包含生成的代码用到的方法，用于编译测试生成的代码
+package s
+
+func SetInject(obj any) {}